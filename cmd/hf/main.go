@@ -1,26 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
+	"geminicli2api/pkg/accesslog"
 	"geminicli2api/pkg/auth"
 	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
 	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/metrics"
 	"geminicli2api/pkg/routes"
+	"geminicli2api/pkg/server"
+	"geminicli2api/pkg/templates"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 )
 
 func main() {
-	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found or error loading .env file")
-	}
+	// Load environment variables from ENV_FILE or .env
+	config.LoadEnvFiles()
 
 	// Set port for Hugging Face Spaces
 	os.Setenv("PORT", "7860")
@@ -28,6 +32,9 @@ func main() {
 
 	// Initialize configuration
 	cfg := config.NewConfig()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize authentication
 	authConfig := auth.NewAuthConfig(cfg)
@@ -35,19 +42,41 @@ func main() {
 	// Initialize Google API client
 	googleClient := google.NewClient(authConfig, cfg)
 
+	// Load server-side prompt templates when PROMPT_TEMPLATE_DIR is set
+	promptTemplates, err := templates.Load(cfg.PromptTemplateDir)
+	if err != nil {
+		log.Fatalf("Failed to load prompt templates: %v", err)
+	}
+
 	// Initialize handlers
-	openaiHandler := routes.NewOpenAIHandler(authConfig, googleClient, cfg)
+	openaiHandler := routes.NewOpenAIHandler(authConfig, googleClient, cfg, promptTemplates)
 	geminiHandler := routes.NewGeminiHandler(authConfig, googleClient, cfg)
+	anthropicHandler := routes.NewAnthropicHandler(authConfig, googleClient, cfg)
+	adminHandler := routes.NewAdminHandler(authConfig, cfg)
 
 	// Initialize Gin router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Logger(), apierrors.Recovery())
+
+	// Write structured access logs to a file when ACCESS_LOG_PATH is set
+	if accessLogger := accesslog.New(cfg); accessLogger != nil {
+		router.Use(accessLogger.Middleware())
+	}
+
+	// Log redacted request bodies to stdout when LOG_REQUEST_BODIES is set
+	if bodyLogger := accesslog.BodyMiddleware(cfg); bodyLogger != nil {
+		router.Use(bodyLogger)
+	}
+
+	// Schedule upstream calls by the X-Priority header
+	router.Use(routes.PriorityMiddleware())
 
 	// Add CORS middleware
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
 		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "Server-Timing", "X-Upstream-Status", "X-Unsupported-Params", "X-Request-ID"},
 		AllowCredentials: true,
 	}))
 
@@ -60,58 +89,101 @@ func main() {
 		c.Status(http.StatusOK)
 	})
 
+	// All routes live under the optional ROUTE_PREFIX
+	base := router.Group(cfg.RoutePrefix)
+	prefix := cfg.RoutePrefix
+
 	// Root endpoint - no authentication required
-	router.GET("/", func(c *gin.Context) {
+	base.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"name":        "geminicli2api",
 			"description": "OpenAI-compatible API proxy for Google's Gemini models via gemini-cli",
 			"purpose":     "Provides both OpenAI-compatible endpoints (/v1/chat/completions) and native Gemini API endpoints for accessing Google's Gemini models",
-			"version":     "1.0.0",
+			"version":     config.ProxyVersion,
 			"endpoints": gin.H{
 				"openai_compatible": gin.H{
-					"chat_completions": "/v1/chat/completions",
-					"models":           "/v1/models",
+					"chat_completions": prefix + "/v1/chat/completions",
+					"models":           prefix + "/v1/models",
+					"compare":          prefix + "/v1/compare",
+					"moderations":      prefix + "/v1/moderations",
+				},
+				"anthropic_compatible": gin.H{
+					"messages": prefix + "/v1/messages",
 				},
 				"native_gemini": gin.H{
-					"models":    "/v1beta/models",
-					"generate":  "/v1beta/models/{model}/generateContent",
-					"stream":    "/v1beta/models/{model}/streamGenerateContent",
+					"models":   prefix + "/v1beta/models",
+					"generate": prefix + "/v1beta/models/{model}/generateContent",
+					"stream":   prefix + "/v1beta/models/{model}/streamGenerateContent",
 				},
-				"health": "/health",
+				"health":  prefix + "/health",
+				"metrics": prefix + "/metrics",
 			},
-			"authentication": "Required for all endpoints except root and health",
+			"authentication": "Required for all endpoints except root, health and metrics",
 			"repository":     "https://github.com/user/geminicli2api",
 		})
 	})
 
 	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
+	base.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
 			"status":  "healthy",
 			"service": "geminicli2api",
 		})
 	})
 
+	// Metrics endpoint
+	base.GET("/metrics", metrics.Handler)
+
 	// Register OpenAI routes
-	openaiHandler.RegisterRoutes(router)
+	openaiHandler.RegisterRoutes(base)
 
 	// Register Gemini routes
-	geminiHandler.RegisterRoutes(router)
+	geminiHandler.RegisterRoutes(base)
+
+	// Register Anthropic routes
+	anthropicHandler.RegisterRoutes(base)
+
+	// Register admin routes
+	adminHandler.RegisterRoutes(base)
 
 	// Perform startup authentication and onboarding
 	if err := performStartupSetup(authConfig); err != nil {
 		log.Printf("Startup setup warning: %v", err)
 	}
 
+	// Optionally prime the upstream connection with a tiny request
+	if cfg.WarmupModel != "" {
+		go warmup(googleClient, cfg)
+	}
+
+	// Optionally keep the model list in sync with what Google serves
+	if cfg.ModelListRefresh > 0 {
+		go googleClient.RefreshModels(context.Background(), cfg.ModelListRefresh)
+	}
+
 	log.Printf("Starting Gemini proxy server on port 7860")
 	log.Printf("Authentication required - Password: see .env file")
 
-	// Start server
-	if err := router.Run(":7860"); err != nil {
+	// Start server, over TLS when configured, until a shutdown signal
+	if err := server.Run(router, ":7860", cfg); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
 
+// warmup sends a tiny request to WARMUP_MODEL and logs the result. Failures
+// are not fatal; the server keeps running.
+func warmup(googleClient *google.Client, cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := googleClient.Warmup(ctx, cfg.WarmupModel); err != nil {
+		log.Printf("Warm-up request to %s failed: %v", cfg.WarmupModel, err)
+		return
+	}
+	log.Printf("Warm-up request to %s succeeded in %v", cfg.WarmupModel, time.Since(start).Round(time.Millisecond))
+}
+
 // performStartupSetup handles startup authentication and onboarding
 func performStartupSetup(authConfig *auth.AuthConfig) error {
 	log.Println("Starting Gemini proxy server...")
@@ -136,11 +208,18 @@ func performStartupSetup(authConfig *auth.AuthConfig) error {
 					log.Printf("Setup failed: %v", err)
 					return fmt.Errorf("setup failed: %w", err)
 				}
+			} else if err != nil {
+				log.Printf("Could not determine project ID: %v", err)
 			}
 		} else {
 			log.Println("Credentials file exists but could not be loaded. Server started - authentication will be required on first request.")
 			return nil
 		}
+	} else if authConfig.Config.Headless {
+		// No credentials and nobody to complete the browser flow - start anyway
+		log.Println("No credentials found and running headless; skipping the interactive OAuth flow.")
+		log.Println("Provide GEMINI_CREDENTIALS or a credentials file (GOOGLE_APPLICATION_CREDENTIALS) and restart. Requests will return 503 until then.")
+		return nil
 	} else {
 		// No credentials found - prompt user to authenticate
 		log.Println("No credentials found. Starting OAuth authentication flow...")
@@ -153,6 +232,8 @@ func performStartupSetup(authConfig *auth.AuthConfig) error {
 					log.Printf("Setup failed: %v", err)
 					return fmt.Errorf("setup failed: %w", err)
 				}
+			} else if err != nil {
+				log.Printf("Could not determine project ID: %v", err)
 			}
 		} else {
 			log.Println("Authentication failed. Server started but will not function until credentials are provided.")
@@ -0,0 +1,138 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"geminicli2api/pkg/config"
+)
+
+// Context keys handlers use to enrich the access log entry
+const (
+	modelKey    = "accesslog.model"
+	tokensKey   = "accesslog.tokens"
+	metadataKey = "accesslog.metadata"
+)
+
+// Entry is a single structured access log record
+type Entry struct {
+	Time       string            `json:"time"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Status     int               `json:"status"`
+	DurationMs int64             `json:"duration_ms"`
+	Bytes      int               `json:"bytes"`
+	Identity   string            `json:"identity,omitempty"`
+	Model      string            `json:"model,omitempty"`
+	Tokens     int               `json:"tokens,omitempty"`
+	ClientIP   string            `json:"client_ip"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Logger writes access log entries as JSON lines
+type Logger struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New creates an access logger writing to a rotated file, or nil if no
+// ACCESS_LOG_PATH is configured
+func New(cfg *config.Config) *Logger {
+	if cfg.AccessLogPath == "" {
+		return nil
+	}
+
+	log.Printf("Writing access logs to %s", cfg.AccessLogPath)
+	return &Logger{
+		out: &lumberjack.Logger{
+			Filename:   cfg.AccessLogPath,
+			MaxSize:    cfg.AccessLogMaxSizeMB,
+			MaxBackups: cfg.AccessLogMaxBackups,
+			MaxAge:     cfg.AccessLogMaxAgeDays,
+			Compress:   cfg.AccessLogCompress,
+		},
+	}
+}
+
+// Middleware records an entry once the handler returns. Streaming handlers only
+// return when the stream completes, so their entry covers the whole stream.
+func (l *Logger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		entry := Entry{
+			Time:       start.UTC().Format(time.RFC3339),
+			Method:     c.Request.Method,
+			Path:       c.Request.URL.Path,
+			Status:     c.Writer.Status(),
+			DurationMs: time.Since(start).Milliseconds(),
+			Bytes:      c.Writer.Size(),
+			Identity:   c.GetString("username"),
+			Model:      c.GetString(modelKey),
+			Tokens:     c.GetInt(tokensKey),
+			ClientIP:   c.ClientIP(),
+			Metadata:   c.GetStringMapString(metadataKey),
+		}
+		if entry.Bytes < 0 {
+			entry.Bytes = 0
+		}
+		l.write(entry)
+	}
+}
+
+func (l *Logger) write(entry Entry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to write access log: %v", err)
+	}
+}
+
+// SetModel records the model served by the request
+func SetModel(c *gin.Context, model string) {
+	c.Set(modelKey, model)
+}
+
+// SetMetadata records client-supplied request metadata, such as trace tags
+func SetMetadata(c *gin.Context, metadata map[string]string) {
+	if len(metadata) > 0 {
+		c.Set(metadataKey, metadata)
+	}
+}
+
+// RecordUsage records the total token count from a Gemini response's
+// usageMetadata. Streamed chunks carry cumulative usage, so the latest wins.
+func RecordUsage(c *gin.Context, geminiResponse map[string]interface{}) {
+	usage, ok := geminiResponse["usageMetadata"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if total, ok := usage["totalTokenCount"].(float64); ok {
+		c.Set(tokensKey, int(total))
+	}
+}
+
+// TotalTokens returns the total token count of a Gemini response, or 0
+func TotalTokens(geminiResponse map[string]interface{}) int {
+	usage, _ := geminiResponse["usageMetadata"].(map[string]interface{})
+	total, _ := usage["totalTokenCount"].(float64)
+	return int(total)
+}
+
+// AddTokens adds tokens to the count recorded for the request, for requests
+// that make several upstream calls
+func AddTokens(c *gin.Context, tokens int) {
+	c.Set(tokensKey, c.GetInt(tokensKey)+tokens)
+}
@@ -0,0 +1,139 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+)
+
+// sensitiveKeys are body fields and query parameters whose values are never
+// logged
+var sensitiveKeys = map[string]bool{
+	"key":           true,
+	"api_key":       true,
+	"apikey":        true,
+	"password":      true,
+	"authorization": true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"client_secret": true,
+}
+
+// BodyMiddleware logs a redacted copy of each request body to stdout when
+// LOG_REQUEST_BODIES is enabled, or returns nil otherwise. The body is
+// restored so handlers read it unchanged.
+func BodyMiddleware(cfg *config.Config) gin.HandlerFunc {
+	if !cfg.LogRequestBodies {
+		return nil
+	}
+
+	log.Printf("Logging redacted request bodies (text truncated to %d characters)", cfg.LogRequestBodyMaxChars)
+	return func(c *gin.Context) {
+		if c.Request.Body == nil || c.Request.Body == http.NoBody {
+			c.Next()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body.Close()
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil || len(body) == 0 {
+			c.Next()
+			return
+		}
+
+		log.Printf("Request body %s %s: %s", c.Request.Method, redactURL(c.Request.URL), RedactBody(body, cfg.LogRequestBodyMaxChars))
+		c.Next()
+	}
+}
+
+// RedactBody returns a loggable form of a JSON request body: base64 payloads
+// are replaced by their size, credentials are masked and strings longer than
+// maxChars are truncated. Bodies that aren't JSON are summarised by size.
+func RedactBody(body []byte, maxChars int) string {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return fmt.Sprintf("<non-JSON %d bytes>", len(body))
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(redactValue("", decoded, maxChars)); err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	return strings.TrimSuffix(out.String(), "\n")
+}
+
+func redactValue(key string, value interface{}, maxChars int) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			redacted[k] = redactValue(k, item, maxChars)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = redactValue(key, item, maxChars)
+		}
+		return redacted
+	case string:
+		return redactString(key, v, maxChars)
+	}
+	return value
+}
+
+func redactString(key, value string, maxChars int) string {
+	if sensitiveKeys[strings.ToLower(key)] {
+		return "REDACTED"
+	}
+
+	// inlineData.data, input_audio.data and Anthropic base64 sources
+	if key == "data" {
+		return fmt.Sprintf("<base64 %d bytes>", base64Size(value))
+	}
+
+	// OpenAI image_url data URIs
+	if strings.HasPrefix(value, "data:") {
+		if i := strings.Index(value, ";base64,"); i >= 0 {
+			return fmt.Sprintf("%s<base64 %d bytes>", value[:i+len(";base64,")], base64Size(value[i+len(";base64,"):]))
+		}
+	}
+
+	runes := []rune(value)
+	if maxChars > 0 && len(runes) > maxChars {
+		return fmt.Sprintf("%s...<%d more characters>", string(runes[:maxChars]), len(runes)-maxChars)
+	}
+	return value
+}
+
+// base64Size estimates the decoded size of base64 data without decoding it
+func base64Size(encoded string) int {
+	encoded = strings.TrimRight(strings.TrimSpace(encoded), "=")
+	return len(encoded) * 3 / 4
+}
+
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	if len(query) == 0 {
+		return u.Path
+	}
+	for k := range query {
+		if sensitiveKeys[strings.ToLower(k)] {
+			query.Set(k, "REDACTED")
+		}
+	}
+	return u.Path + "?" + query.Encode()
+}
@@ -0,0 +1,30 @@
+package accesslog
+
+import "testing"
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"inline data", `{"inlineData": {"mimeType": "image/png", "data": "aGVsbG8gd29ybGQh"}}`,
+			`{"inlineData":{"data":"<base64 12 bytes>","mimeType":"image/png"}}`},
+		{"data URI", `{"image_url": {"url": "data:image/png;base64,aGVsbG8gd29ybGQh"}}`,
+			`{"image_url":{"url":"data:image/png;base64,<base64 12 bytes>"}}`},
+		{"credentials", `{"api_key": "sk-secret", "nested": {"Password": "hunter2"}, "tokens": ["a"]}`,
+			`{"api_key":"REDACTED","nested":{"Password":"REDACTED"},"tokens":["a"]}`},
+		{"long string", `{"messages": [{"content": "abcdefghijkl"}]}`,
+			`{"messages":[{"content":"abcdefghij...<2 more characters>"}]}`},
+		{"short string", `{"content": "abcdefghij", "n": 1}`, `{"content":"abcdefghij","n":1}`},
+		{"non-JSON", `not json`, `<non-JSON 8 bytes>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactBody([]byte(tt.body), 10); got != tt.want {
+				t.Errorf("RedactBody() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,13 +2,16 @@ package auth
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
@@ -17,6 +20,7 @@ import (
 	"golang.org/x/oauth2/google"
 
 	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/transport"
 )
 
 var (
@@ -24,16 +28,45 @@ var (
 	userProjectID   string
 	onboardingDone  bool
 	credsFromEnv    bool
+	credsSource     string
+	currentTier     map[string]interface{}
+	codeAssistInfo  map[string]interface{}
+	revokedRefreshToken string
+	serviceAccountCreds bool
 	credentialsMux  sync.RWMutex
 )
 
+// QuotaInfo describes the user's Code Assist tier as reported by loadCodeAssist
+type QuotaInfo struct {
+	ProjectID   string                 `json:"project_id"`
+	TierID      string                 `json:"tier_id,omitempty"`
+	TierName    string                 `json:"tier_name,omitempty"`
+	CurrentTier map[string]interface{} `json:"current_tier,omitempty"`
+	Raw         map[string]interface{} `json:"raw,omitempty"`
+}
+
+// AuthStatus describes the current authentication state
+type AuthStatus struct {
+	CredentialsLoaded bool       `json:"credentials_loaded"`
+	TokenValid        bool       `json:"token_valid"`
+	TokenExpiry       *time.Time `json:"token_expiry,omitempty"`
+	ProjectID         string     `json:"project_id,omitempty"`
+	OnboardingDone    bool       `json:"onboarding_done"`
+	CredentialsSource string     `json:"credentials_source,omitempty"`
+}
+
 // AuthConfig holds authentication configuration
 type AuthConfig struct {
 	Config         *config.Config
 	OAuth2Config   *oauth2.Config
 	HTTPClient     *http.Client
+	Transport      *http.Transport
 }
 
+// ErrNoCredentials is returned when no Google credentials are available and the
+// interactive OAuth flow can't be started
+var ErrNoCredentials = errors.New("no Google credentials available; provide GEMINI_CREDENTIALS or a credentials file, or run the server interactively to sign in")
+
 // NewAuthConfig creates a new authentication configuration
 func NewAuthConfig(cfg *config.Config) *AuthConfig {
 	oauth2Config := &oauth2.Config{
@@ -44,54 +77,95 @@ func NewAuthConfig(cfg *config.Config) *AuthConfig {
 		RedirectURL:  "http://localhost:8080",
 	}
 
+	// Shared with the Google API client for connection reuse
+	upstreamTransport := transport.NewUpstreamTransport(cfg)
+
 	return &AuthConfig{
 		Config:       cfg,
 		OAuth2Config: oauth2Config,
-		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second, Transport: upstreamTransport},
+		Transport:    upstreamTransport,
 	}
 }
 
 // AuthenticateUser authenticates the user with multiple methods
 func (ac *AuthConfig) AuthenticateUser(r *http.Request) (string, error) {
-	// Check for API key in query parameters first (for Gemini client compatibility)
-	apiKey := r.URL.Query().Get("key")
-	if apiKey == ac.Config.GeminiAuthPassword {
-		return "api_key_user", nil
+	// Collect every credential the client supplied, in precedence order
+	type suppliedCredential struct {
+		method   string
+		secret   string
+		username string
 	}
+	var supplied []suppliedCredential
 
-	// Check for API key in x-goog-api-key header (Google SDK format)
-	googAPIKey := r.Header.Get("x-goog-api-key")
-	if googAPIKey == ac.Config.GeminiAuthPassword {
-		return "goog_api_key_user", nil
-	}
-
-	// Check for API key in Authorization header (Bearer token format)
 	authHeader := r.Header.Get("authorization")
-	if strings.HasPrefix(authHeader, "Bearer ") {
-		bearerToken := strings.TrimPrefix(authHeader, "Bearer ")
-		if bearerToken == ac.Config.GeminiAuthPassword {
-			return "bearer_user", nil
-		}
-	}
-
-	// Check for HTTP Basic Authentication
 	if strings.HasPrefix(authHeader, "Basic ") {
 		encodedCreds := strings.TrimPrefix(authHeader, "Basic ")
 		decodedCreds, err := base64.StdEncoding.DecodeString(encodedCreds)
-		if err == nil {
-			creds := string(decodedCreds)
-			parts := strings.SplitN(creds, ":", 2)
-			if len(parts) == 2 && parts[1] == ac.Config.GeminiAuthPassword {
-				return parts[0], nil
-			}
+		parts := strings.SplitN(string(decodedCreds), ":", 2)
+		if err != nil || len(parts) != 2 {
+			return "", fmt.Errorf("malformed Basic authorization header")
 		}
+		supplied = append(supplied, suppliedCredential{"Basic auth", parts[1], parts[0]})
+	} else if strings.HasPrefix(authHeader, "Bearer ") {
+		supplied = append(supplied, suppliedCredential{"Bearer token", strings.TrimPrefix(authHeader, "Bearer "), "bearer_user"})
+	}
+	if googAPIKey := r.Header.Get("x-goog-api-key"); googAPIKey != "" {
+		supplied = append(supplied, suppliedCredential{"x-goog-api-key header", googAPIKey, "goog_api_key_user"})
+	}
+	if anthropicKey := r.Header.Get("x-api-key"); anthropicKey != "" {
+		supplied = append(supplied, suppliedCredential{"x-api-key header", anthropicKey, "api_key_user"})
+	}
+	if apiKey := r.URL.Query().Get("key"); apiKey != "" {
+		supplied = append(supplied, suppliedCredential{"key query parameter", apiKey, "api_key_user"})
 	}
 
-	return "", fmt.Errorf("invalid authentication credentials. Use HTTP Basic Auth, Bearer token, 'key' query parameter, or 'x-goog-api-key' header")
+	if len(supplied) == 0 {
+		return "", fmt.Errorf("invalid authentication credentials. Use HTTP Basic Auth, Bearer token, 'key' query parameter, 'x-goog-api-key' or 'x-api-key' header")
+	}
+
+	// An empty password must never match an empty credential
+	password := ac.Config.GeminiAuthPassword
+	if password == "" {
+		return "", fmt.Errorf("authentication is not configured on the server")
+	}
+
+	// Every supplied credential must be valid, so a stale or conflicting one
+	// isn't masked by another that happens to match
+	for _, cred := range supplied {
+		current, previous := ac.matchPassword(cred.secret)
+		if !current && !previous {
+			return "", fmt.Errorf("invalid authentication credentials in %s", cred.method)
+		}
+		if previous {
+			log.Printf("Client authenticated with GEMINI_AUTH_PASSWORD_PREVIOUS via %s", cred.method)
+		}
+	}
+
+	return supplied[0].username, nil
+}
+
+// matchPassword reports whether secret matches the current password and,
+// during a rotation, the previous one. Both comparisons are constant-time and
+// always made, so timing doesn't reveal which password matched.
+func (ac *AuthConfig) matchPassword(secret string) (current, previous bool) {
+	if secret == "" {
+		return false, false
+	}
+	current = subtle.ConstantTimeCompare([]byte(secret), []byte(ac.Config.GeminiAuthPassword)) == 1
+	previousPassword := ac.Config.GeminiAuthPasswordPrevious
+	previous = subtle.ConstantTimeCompare([]byte(secret), []byte(previousPassword)) == 1 && previousPassword != ""
+	return current, previous && !current
 }
 
 // GetCredentials loads OAuth2 credentials
 func (ac *AuthConfig) GetCredentials(allowOAuthFlow bool) (*oauth2.Token, error) {
+	return ac.GetCredentialsContext(context.Background(), allowOAuthFlow)
+}
+
+// GetCredentialsContext loads OAuth2 credentials, giving up on refreshing
+// loaded credentials once ctx is done
+func (ac *AuthConfig) GetCredentialsContext(ctx context.Context, allowOAuthFlow bool) (*oauth2.Token, error) {
 	credentialsMux.RLock()
 	if credentials != nil && credentials.Valid() {
 		creds := credentials
@@ -102,11 +176,15 @@ func (ac *AuthConfig) GetCredentials(allowOAuthFlow bool) (*oauth2.Token, error)
 
 	// Check environment variable first
 	if envCredsJSON := os.Getenv("GEMINI_CREDENTIALS"); envCredsJSON != "" {
-		token, err := ac.parseEnvCredentials(envCredsJSON)
+		token, err := ac.parseEnvCredentials(ctx, envCredsJSON)
 		if err == nil {
 			credentialsMux.Lock()
 			credentials = token
 			credsFromEnv = true
+			credsSource = "env"
+			if serviceAccountCreds {
+				credsSource = "service_account"
+			}
 			credentialsMux.Unlock()
 			return token, nil
 		}
@@ -115,11 +193,15 @@ func (ac *AuthConfig) GetCredentials(allowOAuthFlow bool) (*oauth2.Token, error)
 
 	// Check credential file
 	if _, err := os.Stat(ac.Config.CredentialFile); err == nil {
-		token, err := ac.loadFileCredentials()
+		token, err := ac.loadFileCredentials(ctx)
 		if err == nil {
 			credentialsMux.Lock()
 			credentials = token
 			credsFromEnv = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != ""
+			credsSource = "file"
+			if serviceAccountCreds {
+				credsSource = "service_account"
+			}
 			credentialsMux.Unlock()
 			return token, nil
 		}
@@ -130,19 +212,28 @@ func (ac *AuthConfig) GetCredentials(allowOAuthFlow bool) (*oauth2.Token, error)
 		return nil, nil
 	}
 
+	// Never block on the browser callback without a user to complete it
+	if ac.Config.Headless {
+		return nil, ErrNoCredentials
+	}
+
 	// Start OAuth flow
 	return ac.startOAuthFlow()
 }
 
 // parseEnvCredentials parses credentials from environment variable
-func (ac *AuthConfig) parseEnvCredentials(envCredsJSON string) (*oauth2.Token, error) {
+func (ac *AuthConfig) parseEnvCredentials(ctx context.Context, envCredsJSON string) (*oauth2.Token, error) {
 	var credsData map[string]interface{}
 	if err := json.Unmarshal([]byte(envCredsJSON), &credsData); err != nil {
 		return nil, fmt.Errorf("failed to parse environment credentials JSON: %w", err)
 	}
 
+	if isServiceAccountJSON(credsData) {
+		return ac.loadServiceAccountCredentials([]byte(envCredsJSON))
+	}
+
 	// Check for refresh token
-	if refreshToken, ok := credsData["refresh_token"].(string); ok && refreshToken != "" {
+	if refreshToken, ok := credsData["refresh_token"].(string); ok && refreshToken != "" && !isRevokedRefreshToken(refreshToken) {
 		log.Println("Environment refresh token found - creating credentials")
 
 		token := &oauth2.Token{
@@ -172,9 +263,13 @@ func (ac *AuthConfig) parseEnvCredentials(envCredsJSON string) (*oauth2.Token, e
 			log.Printf("Extracted project_id from environment credentials: %s", projectID)
 		}
 
-		// Try to refresh if needed
+		// Try to refresh if needed. A revoked refresh token is unusable, so
+		// the caller falls through to the OAuth flow instead.
 		if !token.Valid() && token.RefreshToken != "" {
-			if err := ac.RefreshToken(token); err != nil {
+			if err := ac.RefreshTokenContext(ctx, token); err != nil {
+				if isInvalidGrant(err) {
+					return nil, fmt.Errorf("environment refresh token was revoked: %w", err)
+				}
 				log.Printf("Failed to refresh environment credentials: %v", err)
 			}
 		}
@@ -186,7 +281,7 @@ func (ac *AuthConfig) parseEnvCredentials(envCredsJSON string) (*oauth2.Token, e
 }
 
 // loadFileCredentials loads credentials from file
-func (ac *AuthConfig) loadFileCredentials() (*oauth2.Token, error) {
+func (ac *AuthConfig) loadFileCredentials(ctx context.Context) (*oauth2.Token, error) {
 	data, err := os.ReadFile(ac.Config.CredentialFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read credential file: %w", err)
@@ -197,8 +292,12 @@ func (ac *AuthConfig) loadFileCredentials() (*oauth2.Token, error) {
 		return nil, fmt.Errorf("failed to parse credential file JSON: %w", err)
 	}
 
+	if isServiceAccountJSON(credsData) {
+		return ac.loadServiceAccountCredentials(data)
+	}
+
 	// Check for refresh token
-	if refreshToken, ok := credsData["refresh_token"].(string); ok && refreshToken != "" {
+	if refreshToken, ok := credsData["refresh_token"].(string); ok && refreshToken != "" && !isRevokedRefreshToken(refreshToken) {
 		log.Println("File refresh token found - creating credentials")
 
 		token := &oauth2.Token{
@@ -227,10 +326,13 @@ func (ac *AuthConfig) loadFileCredentials() (*oauth2.Token, error) {
 			credentialsMux.Unlock()
 		}
 
-		// Try to refresh if needed
+		// Try to refresh if needed. A revoked refresh token is unusable, so
+		// the caller falls through to the OAuth flow instead.
 		if !token.Valid() && token.RefreshToken != "" {
-			if err := ac.RefreshToken(token); err == nil {
+			if err := ac.RefreshTokenContext(ctx, token); err == nil {
 				ac.SaveCredentials(token, "")
+			} else if isInvalidGrant(err) {
+				return nil, fmt.Errorf("file refresh token was revoked: %w", err)
 			} else {
 				log.Printf("Failed to refresh file credentials: %v", err)
 			}
@@ -242,20 +344,100 @@ func (ac *AuthConfig) loadFileCredentials() (*oauth2.Token, error) {
 	return nil, fmt.Errorf("no refresh token found in credential file")
 }
 
-// RefreshToken refreshes the OAuth2 token
+// RefreshToken refreshes the OAuth2 token, retrying transient failures with
+// exponential backoff. Permanent failures such as invalid_grant are not retried.
 func (ac *AuthConfig) RefreshToken(token *oauth2.Token) error {
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, ac.HTTPClient)
-	// Use the OAuth2 config to refresh the token
-	newToken, err := ac.OAuth2Config.TokenSource(ctx, token).Token()
-	if err != nil {
-		return err
+	return ac.RefreshTokenContext(context.Background(), token)
+}
+
+// RefreshTokenContext is RefreshToken for a request: it stops retrying, and
+// returns ctx's error, as soon as ctx is done
+func (ac *AuthConfig) RefreshTokenContext(ctx context.Context, token *oauth2.Token) error {
+	oauthCtx := context.WithValue(ctx, oauth2.HTTPClient, ac.HTTPClient)
+
+	attempts := ac.Config.RefreshRetryAttempts
+	if attempts < 1 {
+		attempts = 1
 	}
-	// Update the existing token
-	token.AccessToken = newToken.AccessToken
-	token.RefreshToken = newToken.RefreshToken
-	token.TokenType = newToken.TokenType
-	token.Expiry = newToken.Expiry
-	return nil
+	backoff := 500 * time.Millisecond
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		// Use the OAuth2 config to refresh the token
+		var newToken *oauth2.Token
+		newToken, err = ac.OAuth2Config.TokenSource(oauthCtx, token).Token()
+		if err == nil {
+			// Update the existing token
+			token.AccessToken = newToken.AccessToken
+			token.RefreshToken = newToken.RefreshToken
+			token.TokenType = newToken.TokenType
+			token.Expiry = newToken.Expiry
+			return nil
+		}
+
+		if isInvalidGrant(err) {
+			log.Printf("Refresh token was rejected (invalid_grant) - clearing cached credentials")
+			ac.invalidateCredentials(token.RefreshToken)
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isTransientRefreshError(err) || attempt == attempts {
+			break
+		}
+
+		log.Printf("Token refresh attempt %d/%d failed, retrying in %v: %v", attempt, attempts, backoff, err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// isRevokedRefreshToken reports whether a refresh token was previously rejected
+func isRevokedRefreshToken(refreshToken string) bool {
+	credentialsMux.RLock()
+	defer credentialsMux.RUnlock()
+	return revokedRefreshToken != "" && refreshToken == revokedRefreshToken
+}
+
+// invalidateCredentials drops the cached credentials and remembers the revoked
+// refresh token so it isn't loaded again, allowing a fresh OAuth flow
+func (ac *AuthConfig) invalidateCredentials(refreshToken string) {
+	credentialsMux.Lock()
+	defer credentialsMux.Unlock()
+	credentials = nil
+	onboardingDone = false
+	revokedRefreshToken = refreshToken
+}
+
+// isInvalidGrant reports whether a refresh error means the refresh token is permanently invalid
+func isInvalidGrant(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return retrieveErr.ErrorCode == "invalid_grant" || strings.Contains(string(retrieveErr.Body), "invalid_grant")
+	}
+	return false
+}
+
+// isTransientRefreshError reports whether a refresh error is worth retrying
+func isTransientRefreshError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		if retrieveErr.Response == nil {
+			return true
+		}
+		status := retrieveErr.Response.StatusCode
+		return status >= 500 || status == http.StatusTooManyRequests
+	}
+	// Anything else is a network-level failure
+	return true
 }
 
 // startOAuthFlow starts the OAuth2 flow
@@ -288,6 +470,7 @@ func (ac *AuthConfig) startOAuthFlow() (*oauth2.Token, error) {
 	credentialsMux.Lock()
 	credentials = token
 	credsFromEnv = false
+	credsSource = "oauth"
 	credentialsMux.Unlock()
 
 	ac.SaveCredentials(token, "")
@@ -327,10 +510,15 @@ func (ac *AuthConfig) startCallbackServer() (string, error) {
 	return authCode, nil
 }
 
-// SaveCredentials saves credentials to file
+// SaveCredentials saves credentials to file. Callers must not hold the
+// credentials lock.
 func (ac *AuthConfig) SaveCredentials(token *oauth2.Token, projectID string) {
-	if credsFromEnv {
-		// Don't overwrite environment credentials, but update project ID if needed
+	credentialsMux.RLock()
+	externalCreds := credsFromEnv || serviceAccountCreds
+	credentialsMux.RUnlock()
+
+	if externalCreds {
+		// Don't overwrite environment or service account credentials, but update project ID if needed
 		if projectID != "" {
 			ac.updateProjectIDInFile(projectID)
 		}
@@ -360,7 +548,9 @@ func (ac *AuthConfig) SaveCredentials(token *oauth2.Token, projectID string) {
 	}
 
 	data, _ := json.MarshalIndent(credsData, "", "  ")
-	os.WriteFile(ac.Config.CredentialFile, data, 0600)
+	if err := writeFileAtomic(ac.Config.CredentialFile, data, 0600); err != nil {
+		log.Printf("Failed to save credentials: %v", err)
+	}
 }
 
 // updateProjectIDInFile updates project ID in existing credential file
@@ -371,7 +561,10 @@ func (ac *AuthConfig) updateProjectIDInFile(projectID string) {
 			if _, hasProjectID := existingData["project_id"]; !hasProjectID {
 				existingData["project_id"] = projectID
 				if newData, err := json.MarshalIndent(existingData, "", "  "); err == nil {
-					os.WriteFile(ac.Config.CredentialFile, newData, 0600)
+					if err := writeFileAtomic(ac.Config.CredentialFile, newData, 0600); err != nil {
+						log.Printf("Failed to update credential file: %v", err)
+						return
+					}
 					log.Printf("Added project_id %s to existing credential file", projectID)
 				}
 			}
@@ -379,6 +572,43 @@ func (ac *AuthConfig) updateProjectIDInFile(projectID string) {
 	}
 }
 
+// writeFileAtomic writes data to a temp file in the same directory and renames it
+// into place, so a crash mid-write never leaves a partial file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // No-op once renamed
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
 // getProjectIDFromFile gets project ID from credential file
 func (ac *AuthConfig) getProjectIDFromFile() string {
 	if data, err := os.ReadFile(ac.Config.CredentialFile); err == nil {
@@ -392,33 +622,38 @@ func (ac *AuthConfig) getProjectIDFromFile() string {
 	return ""
 }
 
-// GetUserProjectID gets the user's project ID
+// GetUserProjectID gets the user's project ID. The credentials lock is only
+// held to read and store the cached ID, never across refresh, discovery or
+// file writes, since a rejected refresh takes the lock to clear credentials.
 func (ac *AuthConfig) GetUserProjectID(token *oauth2.Token) (string, error) {
-	credentialsMux.RLock()
-	defer credentialsMux.RUnlock()
-
 	// Priority 1: Check environment variable
 	if envProjectID := os.Getenv("GOOGLE_CLOUD_PROJECT"); envProjectID != "" {
 		log.Printf("Using project ID from GOOGLE_CLOUD_PROJECT environment variable: %s", envProjectID)
-		userProjectID = envProjectID
+		setUserProjectID(envProjectID)
 		ac.SaveCredentials(token, envProjectID)
 		return envProjectID, nil
 	}
 
 	// Priority 2: Use cached project ID
-	if userProjectID != "" {
-		log.Printf("Using cached project ID: %s", userProjectID)
-		return userProjectID, nil
+	credentialsMux.RLock()
+	cachedProjectID := userProjectID
+	credentialsMux.RUnlock()
+	if cachedProjectID != "" {
+		log.Printf("Using cached project ID: %s", cachedProjectID)
+		return cachedProjectID, nil
 	}
 
 	// Priority 3: Check credential file
 	if projectID := ac.getProjectIDFromFile(); projectID != "" {
 		log.Printf("Using cached project ID from credential file: %s", projectID)
-		userProjectID = projectID
+		setUserProjectID(projectID)
 		return projectID, nil
 	}
 
-	// Priority 4: Discover via API call
+	// Priority 4: Discover via API call, unless disabled
+	if ac.Config.SkipProjectDiscovery {
+		return "", fmt.Errorf("no project ID configured and SKIP_PROJECT_DISCOVERY is set; set GOOGLE_CLOUD_PROJECT to your Google Cloud project ID")
+	}
 	return ac.discoverProjectID(token)
 }
 
@@ -446,9 +681,7 @@ func (ac *AuthConfig) discoverProjectID(token *oauth2.Token) (string, error) {
 		return "", fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", getUserAgent())
+	ac.SetRequestHeaders(req, token.AccessToken, "")
 
 	resp, err := ac.HTTPClient.Do(req)
 	if err != nil {
@@ -472,12 +705,19 @@ func (ac *AuthConfig) discoverProjectID(token *oauth2.Token) (string, error) {
 	}
 
 	log.Printf("Discovered project ID via API: %s", projectID)
-	userProjectID = projectID
+	setUserProjectID(projectID)
 	ac.SaveCredentials(token, projectID)
 
 	return projectID, nil
 }
 
+// setUserProjectID caches the project ID used for upstream requests
+func setUserProjectID(projectID string) {
+	credentialsMux.Lock()
+	userProjectID = projectID
+	credentialsMux.Unlock()
+}
+
 // OnboardUser ensures the user is onboarded
 func (ac *AuthConfig) OnboardUser(token *oauth2.Token, projectID string) error {
 	credentialsMux.Lock()
@@ -501,6 +741,12 @@ func (ac *AuthConfig) OnboardUser(token *oauth2.Token, projectID string) error {
 	}
 
 	// If not already onboarded, start onboarding process
+	credentialsMux.RLock()
+	alreadyOnboarded := onboardingDone
+	credentialsMux.RUnlock()
+	if alreadyOnboarded {
+		return nil
+	}
 	if err := ac.startOnboarding(token, projectID); err != nil {
 		return fmt.Errorf("onboarding failed: %w", err)
 	}
@@ -526,9 +772,7 @@ func (ac *AuthConfig) loadCodeAssist(token *oauth2.Token, projectID string) erro
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", getUserAgent())
+	ac.SetRequestHeaders(req, token.AccessToken, projectID)
 
 	resp, err := ac.HTTPClient.Do(req)
 	if err != nil {
@@ -546,9 +790,14 @@ func (ac *AuthConfig) loadCodeAssist(token *oauth2.Token, projectID string) erro
 		return fmt.Errorf("failed to decode loadCodeAssist response: %w", err)
 	}
 
+	credentialsMux.Lock()
+	codeAssistInfo = loadData
+	credentialsMux.Unlock()
+
 	// Check if already onboarded
-	if _, ok := loadData["currentTier"]; ok {
+	if tier, ok := loadData["currentTier"].(map[string]interface{}); ok {
 		credentialsMux.Lock()
+		currentTier = tier
 		onboardingDone = true
 		credentialsMux.Unlock()
 		return nil
@@ -557,9 +806,36 @@ func (ac *AuthConfig) loadCodeAssist(token *oauth2.Token, projectID string) erro
 	return nil
 }
 
+// selectOnboardTier picks the tier to onboard with: the ONBOARD_TIER_ID override,
+// else the tier loadCodeAssist marks as default in allowedTiers, else legacy-tier
+func (ac *AuthConfig) selectOnboardTier() string {
+	if ac.Config.OnboardTierID != "" {
+		return ac.Config.OnboardTierID
+	}
+
+	credentialsMux.RLock()
+	allowedTiers, _ := codeAssistInfo["allowedTiers"].([]interface{})
+	credentialsMux.RUnlock()
+
+	for _, tier := range allowedTiers {
+		tierMap, ok := tier.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if isDefault, _ := tierMap["isDefault"].(bool); isDefault {
+			if id, ok := tierMap["id"].(string); ok && id != "" {
+				return id
+			}
+		}
+	}
+
+	return "legacy-tier"
+}
+
 // startOnboarding starts the onboarding process
 func (ac *AuthConfig) startOnboarding(token *oauth2.Token, projectID string) error {
-	tierID := "legacy-tier" // Default tier
+	tierID := ac.selectOnboardTier()
+	log.Printf("Onboarding with tier: %s", tierID)
 
 	payload := map[string]interface{}{
 		"tierId":                  tierID,
@@ -575,9 +851,7 @@ func (ac *AuthConfig) startOnboarding(token *oauth2.Token, projectID string) err
 			return fmt.Errorf("failed to create onboarding request: %w", err)
 		}
 
-		req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-		req.Header.Set("Content-Type", "application/json")
-		req.Header.Set("User-Agent", getUserAgent())
+		ac.SetRequestHeaders(req, token.AccessToken, projectID)
 
 		resp, err := ac.HTTPClient.Do(req)
 		if err != nil {
@@ -606,16 +880,132 @@ func (ac *AuthConfig) startOnboarding(token *oauth2.Token, projectID string) err
 	return nil
 }
 
-// getClientMetadata returns client metadata for API calls
+// Status returns a snapshot of the current authentication state
+func (ac *AuthConfig) Status() AuthStatus {
+	credentialsMux.RLock()
+	defer credentialsMux.RUnlock()
+
+	status := AuthStatus{
+		CredentialsLoaded: credentials != nil,
+		ProjectID:         userProjectID,
+		OnboardingDone:    onboardingDone,
+		CredentialsSource: credsSource,
+	}
+	if credentials != nil {
+		status.TokenValid = credentials.Valid()
+		if !credentials.Expiry.IsZero() {
+			expiry := credentials.Expiry
+			status.TokenExpiry = &expiry
+		}
+	}
+	return status
+}
+
+// Reauth clears the onboarding state and re-runs project discovery and onboarding
+// with the currently available credentials. Idle upstream connections are
+// closed first, so later requests don't reuse connections from before.
+func (ac *AuthConfig) Reauth() (string, error) {
+	credentialsMux.Lock()
+	onboardingDone = false
+	credentialsMux.Unlock()
+	ac.Transport.CloseIdleConnections()
+
+	token, err := ac.GetCredentials(false)
+	if err != nil {
+		return "", fmt.Errorf("failed to load credentials: %w", err)
+	}
+	if token == nil {
+		return "", fmt.Errorf("no credentials available")
+	}
+
+	projectID, err := ac.GetUserProjectID(token)
+	if err != nil {
+		return "", fmt.Errorf("failed to get user project ID: %w", err)
+	}
+
+	if err := ac.OnboardUser(token, projectID); err != nil {
+		return "", fmt.Errorf("user onboarding failed: %w", err)
+	}
+
+	log.Printf("Re-onboarded with project ID: %s", projectID)
+	return projectID, nil
+}
+
+// QuotaInfo validates the current credentials with a fresh loadCodeAssist call
+// and returns the reported tier
+func (ac *AuthConfig) QuotaInfo() (*QuotaInfo, error) {
+	token, err := ac.GetCredentials(false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load credentials: %w", err)
+	}
+	if token == nil {
+		return nil, fmt.Errorf("no credentials available")
+	}
+
+	if !token.Valid() && token.RefreshToken != "" {
+		if err := ac.RefreshToken(token); err != nil {
+			return nil, fmt.Errorf("failed to refresh credentials: %w", err)
+		}
+	}
+
+	projectID, err := ac.GetUserProjectID(token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user project ID: %w", err)
+	}
+
+	if err := ac.loadCodeAssist(token, projectID); err != nil {
+		return nil, err
+	}
+
+	credentialsMux.RLock()
+	defer credentialsMux.RUnlock()
+
+	info := &QuotaInfo{
+		ProjectID:   projectID,
+		CurrentTier: currentTier,
+		Raw:         codeAssistInfo,
+	}
+	if currentTier != nil {
+		info.TierID, _ = currentTier["id"].(string)
+		info.TierName, _ = currentTier["name"].(string)
+	}
+	return info, nil
+}
+
+// getClientMetadata returns client metadata for API calls. The optional
+// ideType, pluginType and experiments fields are only sent when configured.
 func (ac *AuthConfig) getClientMetadata() map[string]interface{} {
-	return map[string]interface{}{
-		"clientName":    "geminicli2api",
-		"clientVersion": "1.0.0",
+	metadata := map[string]interface{}{
+		"clientName":    ac.Config.Client.Name,
+		"clientVersion": ac.Config.Client.Version,
 		"platform":      "go",
 	}
+	if ac.Config.Client.IDEType != "" {
+		metadata["ideType"] = ac.Config.Client.IDEType
+	}
+	if ac.Config.Client.PluginType != "" {
+		metadata["pluginType"] = ac.Config.Client.PluginType
+	}
+	if len(ac.Config.Client.Experiments) > 0 {
+		metadata["experiments"] = ac.Config.Client.Experiments
+	}
+	return metadata
 }
 
-// getUserAgent returns the user agent string
-func getUserAgent() string {
-	return fmt.Sprintf("geminicli2api/1.0.0 (go)")
+// SetRequestHeaders sets the standard headers for upstream Code Assist requests,
+// plus any configured extra headers. Quota is attributed to projectID when it's
+// known; it isn't yet while the project is being discovered.
+func (ac *AuthConfig) SetRequestHeaders(req *http.Request, accessToken string, projectID string) {
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", ac.Config.UserAgent())
+	req.Header.Set("x-goog-api-client", ac.Config.APIClientHeader())
+	if projectID != "" {
+		req.Header.Set("x-goog-user-project", projectID)
+	}
+
+	// Config validation keeps these from replacing Authorization or Content-Type
+	for name, value := range ac.Config.UpstreamExtraHeaders {
+		req.Header.Set(name, value)
+	}
 }
\ No newline at end of file
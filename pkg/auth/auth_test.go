@@ -0,0 +1,509 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"geminicli2api/pkg/config"
+)
+
+// newTestAuthConfig returns an AuthConfig whose token and Code Assist
+// endpoints point at server, with the package's credential state reset
+func newTestAuthConfig(t *testing.T, server *httptest.Server) *AuthConfig {
+	t.Helper()
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+
+	cfg := config.NewConfig()
+	cfg.CredentialFile = filepath.Join(t.TempDir(), "oauth_creds.json")
+	cfg.RefreshRetryAttempts = 3
+	ac := NewAuthConfig(cfg)
+	if server != nil {
+		cfg.CodeAssistEndpoint = server.URL
+		ac.OAuth2Config.Endpoint.TokenURL = server.URL + "/token"
+	}
+
+	resetCredentialState()
+	t.Cleanup(resetCredentialState)
+	return ac
+}
+
+func resetCredentialState() {
+	credentialsMux.Lock()
+	defer credentialsMux.Unlock()
+	credentials = nil
+	userProjectID = ""
+	onboardingDone = false
+	credsFromEnv = false
+	credsSource = ""
+	currentTier = nil
+	codeAssistInfo = nil
+	revokedRefreshToken = ""
+	serviceAccountCreds = false
+}
+
+func expiredToken() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  "stale",
+		RefreshToken: "refresh-token",
+		TokenType:    "Bearer",
+		Expiry:       time.Now().Add(-time.Hour),
+	}
+}
+
+func TestRefreshTokenRetriesTransientFailure(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			http.Error(w, "backend unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+	}))
+	defer server.Close()
+	ac := newTestAuthConfig(t, server)
+
+	token := expiredToken()
+	if err := ac.RefreshToken(token); err != nil {
+		t.Fatalf("RefreshToken() error = %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("token endpoint called %d times, want 2", got)
+	}
+	if token.AccessToken != "fresh" || !token.Valid() {
+		t.Errorf("token not updated: %+v", token)
+	}
+}
+
+func TestRefreshTokenInvalidGrantClearsCredentials(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			// Discovery goes ahead with the stale access token
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+	}))
+	defer server.Close()
+	ac := newTestAuthConfig(t, server)
+
+	token := expiredToken()
+	credentialsMux.Lock()
+	credentials = token
+	onboardingDone = true
+	credentialsMux.Unlock()
+
+	// Discovery refreshes the token, and the rejected refresh clears the
+	// credentials; this must not deadlock on the credentials lock
+	done := make(chan error, 1)
+	go func() {
+		_, err := ac.GetUserProjectID(token)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("GetUserProjectID() succeeded with a revoked refresh token")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetUserProjectID() deadlocked after invalid_grant")
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("token endpoint called %d times, want 1 (invalid_grant must not be retried)", got)
+	}
+	status := ac.Status()
+	if status.CredentialsLoaded || status.OnboardingDone {
+		t.Errorf("credentials not cleared: %+v", status)
+	}
+	if !isRevokedRefreshToken("refresh-token") {
+		t.Error("revoked refresh token not remembered")
+	}
+}
+
+func TestWriteFileAtomicReplacesByRename(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "oauth_creds.json")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A hard link keeps the old inode reachable: writing in place would
+	// change what it reads, renaming a new file over the path would not
+	link := filepath.Join(dir, "old-link")
+	if err := os.Link(path, link); err != nil {
+		t.Skipf("hard links unsupported: %v", err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("file contains %q, want %q", data, "new")
+	}
+	if data, _ := os.ReadFile(link); string(data) != "old" {
+		t.Errorf("old inode contains %q, want it untouched", data)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory holds %d entries, want no temp file left behind", len(entries))
+	}
+}
+
+func TestWriteFileAtomicFailedRenameLeavesNoTempFile(t *testing.T) {
+	dir := t.TempDir()
+	// A non-empty directory at the target path makes the rename fail
+	path := filepath.Join(dir, "oauth_creds.json")
+	if err := os.MkdirAll(filepath.Join(path, "child"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeFileAtomic(path, []byte("new"), 0600); err == nil {
+		t.Fatal("writeFileAtomic() succeeded over a directory")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want the temp file removed", len(entries))
+	}
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	basic := func(user, password string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+	}
+	tests := []struct {
+		name     string
+		password string
+		query    string
+		header   map[string]string
+		wantUser string // Empty when authentication must fail
+	}{
+		{
+			name:     "empty server password rejects an empty bearer token",
+			password: "",
+			header:   map[string]string{"Authorization": "Bearer "},
+		},
+		{
+			name:     "empty server password rejects any key",
+			password: "",
+			header:   map[string]string{"x-goog-api-key": "anything"},
+		},
+		{
+			name:     "empty Basic password",
+			password: "secret",
+			header:   map[string]string{"Authorization": basic("alice", "")},
+		},
+		{
+			name:     "empty key query parameter alongside a valid token",
+			password: "secret",
+			query:    "?key=",
+			header:   map[string]string{"Authorization": "Bearer secret"},
+			wantUser: "bearer_user",
+		},
+		{
+			name:     "valid bearer token with a wrong key parameter",
+			password: "secret",
+			query:    "?key=wrong",
+			header:   map[string]string{"Authorization": "Bearer secret"},
+		},
+		{
+			name:     "wrong bearer token with a valid x-goog-api-key",
+			password: "secret",
+			header:   map[string]string{"Authorization": "Bearer wrong", "x-goog-api-key": "secret"},
+		},
+		{
+			name:     "matching credentials in every place",
+			password: "secret",
+			query:    "?key=secret",
+			header:   map[string]string{"Authorization": basic("alice", "secret"), "x-goog-api-key": "secret"},
+			wantUser: "alice",
+		},
+		{
+			name:     "no credentials",
+			password: "secret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.GeminiAuthPassword = tt.password
+			cfg.GeminiAuthPasswordPrevious = ""
+			ac := &AuthConfig{Config: cfg}
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/models"+tt.query, nil)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+
+			user, err := ac.AuthenticateUser(r)
+			if tt.wantUser == "" {
+				if err == nil {
+					t.Errorf("AuthenticateUser() = %q, want an error", user)
+				}
+				return
+			}
+			if err != nil || user != tt.wantUser {
+				t.Errorf("AuthenticateUser() = %q, %v, want %q", user, err, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestGetCredentialsHeadlessSkipsOAuthFlow(t *testing.T) {
+	t.Setenv("GEMINI_CREDENTIALS", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	ac := newTestAuthConfig(t, nil)
+	ac.Config.Headless = true
+
+	// Without HEADLESS this would wait for a browser callback
+	done := make(chan error, 1)
+	go func() {
+		token, err := ac.GetCredentials(true)
+		if token != nil {
+			t.Errorf("GetCredentials() returned a token without any credentials")
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrNoCredentials) {
+			t.Errorf("GetCredentials() error = %v, want ErrNoCredentials", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetCredentials() blocked on the OAuth flow while headless")
+	}
+}
+
+func TestAuthenticateUserDuringPasswordRotation(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.GeminiAuthPassword = "new-secret"
+	cfg.GeminiAuthPasswordPrevious = "old-secret"
+	ac := &AuthConfig{Config: cfg}
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		wantOK bool
+	}{
+		{"current password", map[string]string{"Authorization": "Bearer new-secret"}, true},
+		{"previous password", map[string]string{"Authorization": "Bearer old-secret"}, true},
+		{"each password in a different place", map[string]string{"Authorization": "Bearer new-secret", "x-goog-api-key": "old-secret"}, true},
+		{"neither password", map[string]string{"Authorization": "Bearer other-secret"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+
+			_, err := ac.AuthenticateUser(r)
+			if (err == nil) != tt.wantOK {
+				t.Errorf("AuthenticateUser() error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+
+	// Once the rotation is over the old password stops working
+	cfg.GeminiAuthPasswordPrevious = ""
+	r := httptest.NewRequest(http.MethodGet, "/v1/models", nil)
+	r.Header.Set("Authorization", "Bearer old-secret")
+	if _, err := ac.AuthenticateUser(r); err == nil {
+		t.Error("previous password accepted after the rotation ended")
+	}
+}
+
+func TestGetUserProjectIDDiscovery(t *testing.T) {
+	tests := []struct {
+		name          string
+		envProject    string
+		skipDiscovery bool
+		want          string
+		wantDiscovery bool
+	}{
+		{name: "GOOGLE_CLOUD_PROJECT set", envProject: "env-project", want: "env-project"},
+		{name: "GOOGLE_CLOUD_PROJECT set with discovery skipped", envProject: "env-project", skipDiscovery: true, want: "env-project"},
+		{name: "SKIP_PROJECT_DISCOVERY without a project", skipDiscovery: true},
+		{name: "discovered", want: "discovered-project", wantDiscovery: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var discoveries int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&discoveries, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"cloudaicompanionProject": "discovered-project"}`))
+			}))
+			defer server.Close()
+			ac := newTestAuthConfig(t, server)
+			ac.Config.SkipProjectDiscovery = tt.skipDiscovery
+			t.Setenv("GOOGLE_CLOUD_PROJECT", tt.envProject)
+
+			token := &oauth2.Token{AccessToken: "access", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+			projectID, err := ac.GetUserProjectID(token)
+
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "SKIP_PROJECT_DISCOVERY") {
+					t.Errorf("GetUserProjectID() = %q, %v, want an error naming SKIP_PROJECT_DISCOVERY", projectID, err)
+				}
+			} else if err != nil || projectID != tt.want {
+				t.Errorf("GetUserProjectID() = %q, %v, want %q", projectID, err, tt.want)
+			}
+			if called := atomic.LoadInt32(&discoveries) > 0; called != tt.wantDiscovery {
+				t.Errorf("discovery called = %v, want %v", called, tt.wantDiscovery)
+			}
+		})
+	}
+}
+
+func TestGetClientMetadataOptionalFields(t *testing.T) {
+	t.Setenv("CLIENT_IDE_TYPE", "IDE_UNSPECIFIED")
+	t.Setenv("CLIENT_PLUGIN_TYPE", "GEMINI")
+	t.Setenv("CLIENT_EXPERIMENTS", `{"flagA": true, "rollout": "beta"}`)
+	ac := newTestAuthConfig(t, nil)
+
+	metadata := ac.getClientMetadata()
+
+	if metadata["ideType"] != "IDE_UNSPECIFIED" || metadata["pluginType"] != "GEMINI" {
+		t.Errorf("metadata = %v, want ideType and pluginType from the environment", metadata)
+	}
+	want := map[string]interface{}{"flagA": true, "rollout": "beta"}
+	if got := metadata["experiments"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("experiments = %v, want %v", got, want)
+	}
+
+	ac.Config.Client = config.ClientIdentity{Name: "client", Version: "1.0"}
+	metadata = ac.getClientMetadata()
+	for _, key := range []string{"ideType", "pluginType", "experiments"} {
+		if _, ok := metadata[key]; ok {
+			t.Errorf("metadata has %s without configuration: %v", key, metadata)
+		}
+	}
+}
+
+func TestReauthRecreatesUpstreamConnections(t *testing.T) {
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"currentTier": {"id": "free-tier", "name": "Free"}, "cloudaicompanionProject": "test-project"}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+	ac := newTestAuthConfig(t, server)
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "test-project")
+	t.Setenv("GEMINI_CREDENTIALS", `{"refresh_token": "refresh", "token": "access", "expiry": "`+time.Now().Add(time.Hour).Format(time.RFC3339)+`"}`)
+
+	get := func() {
+		t.Helper()
+		resp, err := ac.HTTPClient.Get(server.URL + "/ping")
+		if err != nil {
+			t.Fatalf("GET error = %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	get()
+	get()
+	if got := atomic.LoadInt32(&connections); got != 1 {
+		t.Fatalf("%d connections before Reauth, want 1 reused connection", got)
+	}
+
+	if _, err := ac.Reauth(); err != nil {
+		t.Fatalf("Reauth() error = %v", err)
+	}
+	get()
+
+	if got := atomic.LoadInt32(&connections); got < 2 {
+		t.Errorf("%d connections after Reauth, want the idle connection replaced", got)
+	}
+}
+
+func TestGetCredentialsRevokedRefreshToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+	}))
+	defer server.Close()
+	expired := `{"refresh_token": "refresh-token", "token": "stale", "expiry": "` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `"}`
+
+	for _, source := range []string{"env", "file"} {
+		t.Run(source, func(t *testing.T) {
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+			t.Setenv("GEMINI_CREDENTIALS", "")
+			ac := newTestAuthConfig(t, server)
+			ac.Config.Headless = true
+			if source == "env" {
+				t.Setenv("GEMINI_CREDENTIALS", expired)
+			} else if err := os.WriteFile(ac.Config.CredentialFile, []byte(expired), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			// The revoked token falls through to the (headless) OAuth path
+			token, err := ac.GetCredentials(true)
+
+			if token != nil || !errors.Is(err, ErrNoCredentials) {
+				t.Errorf("GetCredentials() = %+v, %v, want ErrNoCredentials", token, err)
+			}
+			if ac.Status().CredentialsLoaded {
+				t.Error("revoked credentials were stored")
+			}
+		})
+	}
+}
+
+func TestRefreshTokenContextStopsBackoffWhenCancelled(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "backend unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	ac := newTestAuthConfig(t, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := ac.RefreshTokenContext(ctx, expiredToken())
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RefreshTokenContext() error = %v, want the context's error", err)
+	}
+	// Without the context the backoff alone would take 1.5s
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("RefreshTokenContext() took %v after the context expired", elapsed)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("token endpoint called %d times, want 1", got)
+	}
+}
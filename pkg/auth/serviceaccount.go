@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// isServiceAccountJSON reports whether credentials JSON is a service account
+// key rather than OAuth user credentials
+func isServiceAccountJSON(credsData map[string]interface{}) bool {
+	credType, _ := credsData["type"].(string)
+	return credType == "service_account"
+}
+
+// loadServiceAccountCredentials obtains an access token for a service account
+// key through the JWT flow. Service account tokens have no refresh token; once
+// expired, the key is loaded again to obtain a new one.
+func (ac *AuthConfig) loadServiceAccountCredentials(data []byte) (*oauth2.Token, error) {
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, ac.HTTPClient)
+	creds, err := google.CredentialsFromJSON(ctx, data, ac.Config.Scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse service account key: %w", err)
+	}
+
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain service account token: %w", err)
+	}
+
+	var key struct {
+		ClientEmail string `json:"client_email"`
+	}
+	if json.Unmarshal(data, &key) == nil {
+		log.Printf("Using service account credentials for %s", key.ClientEmail)
+	}
+
+	credentialsMux.Lock()
+	serviceAccountCreds = true
+	if creds.ProjectID != "" && userProjectID == "" {
+		userProjectID = creds.ProjectID
+	}
+	credentialsMux.Unlock()
+
+	return token, nil
+}
@@ -0,0 +1,105 @@
+// Package builtintools implements the tools the proxy runs itself when
+// BUILTIN_TOOLS is set, so clients without a tool-calling loop still get
+// tool-augmented answers
+package builtintools
+
+import (
+	"fmt"
+	"time"
+	_ "time/tzdata" // Time zones for current_time, even without system zoneinfo
+)
+
+// tool is a function declared to Gemini and executed by the proxy
+type tool struct {
+	description string
+	parameters  map[string]interface{}
+	run         func(args map[string]interface{}) (interface{}, error)
+}
+
+var tools = map[string]tool{
+	"current_time": {
+		description: "Returns the current date and time, in UTC or the given IANA time zone.",
+		parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"timezone": map[string]interface{}{
+					"type":        "string",
+					"description": "IANA time zone name, e.g. Europe/Paris. Defaults to UTC.",
+				},
+			},
+		},
+		run: currentTime,
+	},
+	"calculator": {
+		description: "Evaluates an arithmetic expression with + - * / % ^, parentheses and sqrt(), abs(), e.g. (2 + 3) * 4 ^ 2.",
+		parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"expression": map[string]interface{}{
+					"type":        "string",
+					"description": "The expression to evaluate.",
+				},
+			},
+			"required": []interface{}{"expression"},
+		},
+		run: calculate,
+	},
+}
+
+// Declarations returns Gemini function declarations for the named tools,
+// skipping unknown names and those in exclude, such as tools the client
+// declares itself
+func Declarations(names []string, exclude map[string]bool) []map[string]interface{} {
+	var declarations []map[string]interface{}
+	for _, name := range names {
+		t, ok := tools[name]
+		if !ok || exclude[name] {
+			continue
+		}
+		declarations = append(declarations, map[string]interface{}{
+			"name":        name,
+			"description": t.description,
+			"parameters":  t.parameters,
+		})
+	}
+	return declarations
+}
+
+// Run executes a built-in tool and returns the response object for its
+// functionResponse: {"result": ...}, or {"error": ...} if it failed
+func Run(name string, args map[string]interface{}) map[string]interface{} {
+	t, ok := tools[name]
+	if !ok {
+		return map[string]interface{}{"error": fmt.Sprintf("unknown tool %q", name)}
+	}
+	result, err := t.run(args)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return map[string]interface{}{"result": result}
+}
+
+func currentTime(args map[string]interface{}) (interface{}, error) {
+	location := time.UTC
+	if name, _ := args["timezone"].(string); name != "" {
+		loaded, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("unknown time zone %q", name)
+		}
+		location = loaded
+	}
+	now := time.Now().In(location)
+	return map[string]interface{}{
+		"time":     now.Format(time.RFC3339),
+		"weekday":  now.Weekday().String(),
+		"timezone": location.String(),
+	}, nil
+}
+
+func calculate(args map[string]interface{}) (interface{}, error) {
+	expression, _ := args["expression"].(string)
+	if expression == "" {
+		return nil, fmt.Errorf("expression is required")
+	}
+	return evaluate(expression)
+}
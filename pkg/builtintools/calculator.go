@@ -0,0 +1,187 @@
+package builtintools
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// evaluate computes an arithmetic expression with the usual precedence:
+// parentheses and functions, then ^ (right associative), unary signs,
+// * / %, and + -
+func evaluate(expression string) (float64, error) {
+	p := &parser{input: expression}
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("result is not a finite number")
+	}
+	return value, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips whitespace and reports whether the next byte is one of ops,
+// returning it
+func (p *parser) consume(ops string) (byte, bool) {
+	p.skipSpace()
+	if p.pos < len(p.input) && strings.IndexByte(ops, p.input[p.pos]) >= 0 {
+		p.pos++
+		return p.input[p.pos-1], true
+	}
+	return 0, false
+}
+
+func (p *parser) sum() (float64, error) {
+	value, err := p.product()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.consume("+-")
+		if !ok {
+			return value, nil
+		}
+		right, err := p.product()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			value += right
+		} else {
+			value -= right
+		}
+	}
+}
+
+func (p *parser) product() (float64, error) {
+	value, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op, ok := p.consume("*/%")
+		if !ok {
+			return value, nil
+		}
+		right, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			value *= right
+		case '/':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			value /= right
+		case '%':
+			if right == 0 {
+				return 0, fmt.Errorf("division by zero")
+			}
+			value = math.Mod(value, right)
+		}
+	}
+}
+
+func (p *parser) unary() (float64, error) {
+	if op, ok := p.consume("+-"); ok {
+		value, err := p.unary()
+		if op == '-' {
+			value = -value
+		}
+		return value, err
+	}
+	return p.power()
+}
+
+func (p *parser) power() (float64, error) {
+	base, err := p.operand()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := p.consume("^"); ok {
+		exponent, err := p.unary()
+		if err != nil {
+			return 0, err
+		}
+		return math.Pow(base, exponent), nil
+	}
+	return base, nil
+}
+
+func (p *parser) operand() (float64, error) {
+	p.skipSpace()
+	if _, ok := p.consume("("); ok {
+		return p.group()
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && unicode.IsLetter(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if name := p.input[start:p.pos]; name != "" {
+		if _, ok := p.consume("("); !ok {
+			return 0, fmt.Errorf("expected ( after %s", name)
+		}
+		arg, err := p.group()
+		if err != nil {
+			return 0, err
+		}
+		switch strings.ToLower(name) {
+		case "sqrt":
+			if arg < 0 {
+				return 0, fmt.Errorf("square root of a negative number")
+			}
+			return math.Sqrt(arg), nil
+		case "abs":
+			return math.Abs(arg), nil
+		}
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+
+	for p.pos < len(p.input) && (p.input[p.pos] >= '0' && p.input[p.pos] <= '9' || p.input[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		if p.pos >= len(p.input) {
+			return 0, fmt.Errorf("unexpected end of expression")
+		}
+		return 0, fmt.Errorf("unexpected %q at position %d", p.input[p.pos], p.pos)
+	}
+	value, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", p.input[start:p.pos])
+	}
+	return value, nil
+}
+
+// group parses the rest of a parenthesised expression
+func (p *parser) group() (float64, error) {
+	value, err := p.sum()
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := p.consume(")"); !ok {
+		return 0, fmt.Errorf("missing )")
+	}
+	return value, nil
+}
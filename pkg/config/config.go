@@ -1,19 +1,64 @@
 package config
 
 import (
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	// API Endpoints
 	CodeAssistEndpoint = "https://cloudcode-pa.googleapis.com"
+	ModelListEndpoint  = "https://generativelanguage.googleapis.com/v1beta/models"
 
 	// Client Configuration
+	ClientName = "gemini-cli"
 	CLIVersion = "0.1.5" // Match current gemini-cli version
+
+	// ProxyVersion is the version of this proxy
+	ProxyVersion = "1.0.0"
+
+	// MaxStopSequences is the maximum number of stop sequences Gemini accepts
+	MaxStopSequences = 5
+
+	// DefaultMaxCandidateCount is the candidateCount limit used when a model doesn't set one
+	DefaultMaxCandidateCount = 8
+
+	// SafetyBlockError and SafetyBlockEmpty are the SAFETY_BLOCK_MODE values:
+	// answer a blocked OpenAI request with an error, or an empty completion
+	// finishing with content_filter
+	SafetyBlockError = "error"
+	SafetyBlockEmpty = "empty"
+
+	// MinPenalty and MaxPenalty bound frequencyPenalty and presencePenalty;
+	// Gemini accepts [-2, 2), excluding 2 itself
+	MinPenalty = -2.0
+	MaxPenalty = 1.99
+
+	// MaxLogprobs is the most alternative tokens Gemini reports per position
+	MaxLogprobs = 20
 )
 
+// SafetyThresholds lists Gemini's block thresholds from least to most strict
+var SafetyThresholds = []string{
+	"OFF",
+	"BLOCK_NONE",
+	"BLOCK_ONLY_HIGH",
+	"BLOCK_MEDIUM_AND_ABOVE",
+	"BLOCK_LOW_AND_ABOVE",
+}
+
+// BuiltinToolNames lists the tools the proxy can run itself for BUILTIN_TOOLS
+var BuiltinToolNames = []string{"current_time", "calculator"}
+
 // OAuth Configuration - use environment variables
 func GetClientID() string {
 	return os.Getenv("GOOGLE_CLIENT_ID")
@@ -29,19 +74,100 @@ var (
 		"https://www.googleapis.com/auth/userinfo.email",
 		"https://www.googleapis.com/auth/userinfo.profile",
 	}
+
+	// protectedUpstreamHeaders can't be overridden by UPSTREAM_EXTRA_HEADERS
+	protectedUpstreamHeaders = []string{"Authorization", "Content-Type"}
 )
 
 // Config holds application configuration
 type Config struct {
 	CredentialFile      string
 	GeminiAuthPassword  string
+	GeminiAuthPasswordPrevious string // Still accepted while clients migrate to a rotated password
 	CodeAssistEndpoint  string
-	CLIVersion          string
+	Client              ClientIdentity
 	ClientID            string
 	ClientSecret        string
 	Scopes              []string
 	SafetySettings      []map[string]interface{}
-	SupportedModels     []Model
+	SupportedModels     []Model // Guarded by modelsMu once MODEL_LIST_REFRESH replaces it
+	MaxConcurrentUpstream int
+	HighPrioritySlots   int
+	MaxCandidateCount   int
+	StreamMaxLineBytes  int
+	AdminToken          string
+	DefaultStopSequences []string
+	UpstreamMaxIdleConnsPerHost int
+	UpstreamIdleConnTimeout     time.Duration
+	UpstreamForceHTTP2          bool
+	DialTimeout                 time.Duration
+	TLSHandshakeTimeout         time.Duration
+	ResponseHeaderTimeout       time.Duration
+	RefreshRetryAttempts        int
+	RoutePrefix                 string
+	AccessLogPath               string
+	AccessLogMaxSizeMB          int
+	AccessLogMaxBackups         int
+	AccessLogMaxAgeDays         int
+	AccessLogCompress           bool
+	LogRequestBodies            bool
+	LogRequestBodyMaxChars      int
+	DisableThinking             bool
+	DefaultMaxOutputTokens      int
+	Headless                    bool
+	TLSCertFile                 string
+	TLSKeyFile                  string
+	TLSClientCA                 string
+	OnboardTierID               string
+	StreamCoalescing            bool
+	UpstreamTLSMinVersion       string
+	UpstreamTLSCipherSuites     []string
+	StreamFlushInterval         time.Duration
+	StreamFlushMaxBytes         int
+	StreamContinuation          bool
+	StreamContinuationTTL       time.Duration
+	UpstreamExtraHeaders        map[string]string
+	StreamHeartbeatInterval     time.Duration
+	MaxStreamDuration           time.Duration
+	EnforceInputLimit           bool
+	TruncateStrategy            string
+	TruncateMaxMessages         int
+	TruncateMaxTokens           int
+	StripThinking               bool
+	CompareMaxConcurrency       int
+	CompareTimeout              time.Duration
+	UpstreamRetries             int
+	RequestTimeout              time.Duration
+	MaxRequestTimeout           time.Duration
+	AllowedModels               []string
+	DeniedModels                []string
+	QuotaHoldMax                time.Duration
+	WarmupModel                 string
+	SafetyBlockMode             string
+	MinSafetyThreshold          string
+	ReportResolvedModel         bool
+	ToolCallValidation          string
+	SkipProjectDiscovery        bool
+	ResponseContentFormat       string
+	TextPartJoin                string
+	ModelListRefresh            time.Duration
+	IncludeGroundingCitations   bool
+	BuiltinTools                []string
+	BuiltinToolMaxIterations    int
+	PromptTemplateDir           string
+
+	modelsMu sync.RWMutex
+}
+
+// ClientIdentity is the client name, version and User-Agent presented to Google,
+// plus optional client metadata fields that are only sent when configured
+type ClientIdentity struct {
+	Name        string
+	Version     string
+	UserAgent   string                 // Overrides the derived User-Agent when set
+	IDEType     string                 // Metadata ideType, e.g. IDE_UNSPECIFIED
+	PluginType  string                 // Metadata pluginType, e.g. GEMINI
+	Experiments map[string]interface{} // Metadata experiments flags
 }
 
 // Model represents a Gemini model configuration
@@ -57,6 +183,10 @@ type Model struct {
 	MaxTemperature           float64  `json:"maxTemperature"`
 	TopP                     float64  `json:"topP"`
 	TopK                     int      `json:"topK"`
+	MaxCandidateCount        int      `json:"-"`
+	SupportsVision           bool     `json:"-"`
+	SupportsAudio            bool     `json:"-"`
+	SupportsPenalties        bool     `json:"-"` // Accepts frequencyPenalty and presencePenalty
 }
 
 // NewConfig creates a new configuration instance
@@ -70,14 +200,410 @@ func NewConfig() *Config {
 	return &Config{
 		CredentialFile:     fmt.Sprintf("%s/%s", scriptDir, credFile),
 		GeminiAuthPassword: getEnvOrDefault("GEMINI_AUTH_PASSWORD", "123456"),
+		GeminiAuthPasswordPrevious: os.Getenv("GEMINI_AUTH_PASSWORD_PREVIOUS"),
 		CodeAssistEndpoint: CodeAssistEndpoint,
-		CLIVersion:         CLIVersion,
+		Client: ClientIdentity{
+			Name:        strings.TrimSpace(getEnvOrDefault("CLIENT_NAME", ClientName)),
+			Version:     strings.TrimSpace(getEnvOrDefault("CLIENT_VERSION", CLIVersion)),
+			UserAgent:   strings.TrimSpace(os.Getenv("USER_AGENT")),
+			IDEType:     strings.TrimSpace(os.Getenv("CLIENT_IDE_TYPE")),
+			PluginType:  strings.TrimSpace(os.Getenv("CLIENT_PLUGIN_TYPE")),
+			Experiments: getEnvObject("CLIENT_EXPERIMENTS"),
+		},
 		ClientID:           GetClientID(),
 		ClientSecret:       GetClientSecret(),
 		Scopes:             Scopes,
 		SafetySettings:     getDefaultSafetySettings(),
 		SupportedModels:    generateSupportedModels(),
+		MaxConcurrentUpstream: getEnvInt("MAX_CONCURRENT_UPSTREAM", 0),
+		HighPrioritySlots:   getEnvInt("HIGH_PRIORITY_SLOTS", 0),
+		MaxCandidateCount:  getEnvInt("MAX_CANDIDATE_COUNT", 0),
+		StreamMaxLineBytes: getEnvInt("STREAM_MAX_LINE_BYTES", 32*1024*1024),
+		AdminToken:         os.Getenv("ADMIN_TOKEN"),
+		DefaultStopSequences: getEnvList("DEFAULT_STOP_SEQUENCES"),
+		UpstreamMaxIdleConnsPerHost: getEnvInt("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 100),
+		UpstreamIdleConnTimeout:     getEnvDuration("UPSTREAM_IDLE_CONN_TIMEOUT", 90*time.Second),
+		UpstreamForceHTTP2:          getEnvBool("UPSTREAM_FORCE_HTTP2", true),
+		DialTimeout:                 getEnvDuration("DIAL_TIMEOUT", 10*time.Second),
+		TLSHandshakeTimeout:         getEnvDuration("TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+		ResponseHeaderTimeout:       getEnvDuration("RESPONSE_HEADER_TIMEOUT", 0),
+		RefreshRetryAttempts:        getEnvInt("REFRESH_RETRY_ATTEMPTS", 3),
+		RoutePrefix:                 normalizeRoutePrefix(os.Getenv("ROUTE_PREFIX")),
+		AccessLogPath:               os.Getenv("ACCESS_LOG_PATH"),
+		AccessLogMaxSizeMB:          getEnvInt("ACCESS_LOG_MAX_SIZE_MB", 100),
+		AccessLogMaxBackups:         getEnvInt("ACCESS_LOG_MAX_BACKUPS", 5),
+		AccessLogMaxAgeDays:         getEnvInt("ACCESS_LOG_MAX_AGE_DAYS", 30),
+		AccessLogCompress:           getEnvBool("ACCESS_LOG_COMPRESS", false),
+		LogRequestBodies:            getEnvBool("LOG_REQUEST_BODIES", false),
+		LogRequestBodyMaxChars:      getEnvInt("LOG_REQUEST_BODY_MAX_CHARS", 200),
+		DisableThinking:             getEnvBool("DISABLE_THINKING", false),
+		DefaultMaxOutputTokens:      getEnvInt("DEFAULT_MAX_OUTPUT_TOKENS", 0),
+		Headless:                    getEnvBool("HEADLESS", false) || getEnvBool("NON_INTERACTIVE", false),
+		TLSCertFile:                 os.Getenv("TLS_CERT_FILE"),
+		TLSKeyFile:                  os.Getenv("TLS_KEY_FILE"),
+		TLSClientCA:                 os.Getenv("TLS_CLIENT_CA"),
+		OnboardTierID:               os.Getenv("ONBOARD_TIER_ID"),
+		StreamCoalescing:            getEnvBool("STREAM_COALESCING", false),
+		UpstreamTLSMinVersion:       getEnvOrDefault("UPSTREAM_TLS_MIN_VERSION", "1.2"),
+		UpstreamTLSCipherSuites:     getEnvList("UPSTREAM_TLS_CIPHER_SUITES"),
+		StreamFlushInterval:         time.Duration(getEnvInt("STREAM_FLUSH_INTERVAL_MS", 0)) * time.Millisecond,
+		StreamFlushMaxBytes:         getEnvInt("STREAM_FLUSH_MAX_BYTES", 16*1024),
+		StreamContinuation:          getEnvBool("STREAM_CONTINUATION", false),
+		StreamContinuationTTL:       getEnvDuration("STREAM_CONTINUATION_TTL", 10*time.Minute),
+		UpstreamExtraHeaders:        getEnvMap("UPSTREAM_EXTRA_HEADERS"),
+		StreamHeartbeatInterval:     getEnvDuration("STREAM_HEARTBEAT_INTERVAL", 0),
+		MaxStreamDuration:           getEnvDuration("MAX_STREAM_DURATION", 0),
+		EnforceInputLimit:           getEnvBool("ENFORCE_INPUT_LIMIT", false),
+		TruncateStrategy:            getEnvOrDefault("TRUNCATE_STRATEGY", "none"),
+		TruncateMaxMessages:         getEnvInt("TRUNCATE_MAX_MESSAGES", 0),
+		TruncateMaxTokens:           getEnvInt("TRUNCATE_MAX_TOKENS", 0),
+		StripThinking:               getEnvBool("STRIP_THINKING_FROM_CONTENT", false),
+		CompareMaxConcurrency:       getEnvInt("COMPARE_MAX_CONCURRENCY", 4),
+		CompareTimeout:              getEnvDuration("COMPARE_TIMEOUT", 60*time.Second),
+		UpstreamRetries:             getEnvInt("UPSTREAM_RETRIES", 1),
+		RequestTimeout:              getEnvDuration("REQUEST_TIMEOUT", 60*time.Second),
+		MaxRequestTimeout:           getEnvDuration("MAX_REQUEST_TIMEOUT", 10*time.Minute),
+		AllowedModels:               getEnvList("ALLOWED_MODELS"),
+		DeniedModels:                getEnvList("DENIED_MODELS"),
+		QuotaHoldMax:                getEnvDuration("QUOTA_HOLD_MAX", 60*time.Second),
+		WarmupModel:                 os.Getenv("WARMUP_MODEL"),
+		SafetyBlockMode:             getEnvOrDefault("SAFETY_BLOCK_MODE", SafetyBlockError),
+		MinSafetyThreshold:          strings.ToUpper(strings.TrimSpace(os.Getenv("MIN_SAFETY_THRESHOLD"))),
+		ReportResolvedModel:         getEnvBool("REPORT_RESOLVED_MODEL", false),
+		ToolCallValidation:          getEnvOrDefault("TOOL_CALL_VALIDATION", "off"),
+		SkipProjectDiscovery:        getEnvBool("SKIP_PROJECT_DISCOVERY", false),
+		ResponseContentFormat:       strings.ToLower(strings.TrimSpace(getEnvOrDefault("RESPONSE_CONTENT_FORMAT", "string"))),
+		TextPartJoin:                strings.ToLower(strings.TrimSpace(getEnvOrDefault("TEXT_PART_JOIN", "concat"))),
+		ModelListRefresh:            getEnvDuration("MODEL_LIST_REFRESH", 0),
+		IncludeGroundingCitations:   getEnvBool("INCLUDE_GROUNDING_CITATIONS", false),
+		BuiltinTools:                getEnvList("BUILTIN_TOOLS"),
+		BuiltinToolMaxIterations:    getEnvInt("BUILTIN_TOOL_MAX_ITERATIONS", 5),
+		PromptTemplateDir:           os.Getenv("PROMPT_TEMPLATE_DIR"),
+	}
+}
+
+// GetModel looks up a supported model by name, with or without the "models/" prefix
+func (c *Config) GetModel(modelName string) *Model {
+	name := strings.TrimPrefix(modelName, "models/")
+	supported := c.supportedModels()
+	for i := range supported {
+		if strings.TrimPrefix(supported[i].Name, "models/") == name {
+			return &supported[i]
+		}
+	}
+	return nil
+}
+
+// supportedModels returns the current model list. The slice is replaced, never
+// modified, so callers may keep using it after a refresh.
+func (c *Config) supportedModels() []Model {
+	c.modelsMu.RLock()
+	defer c.modelsMu.RUnlock()
+	return c.SupportedModels
+}
+
+// SetBaseModels replaces the supported models with base, plus their search and
+// thinking variants. Models missing from the built-in list get capabilities
+// inferred from their name.
+func (c *Config) SetBaseModels(base []Model) {
+	known := make(map[string]Model)
+	for _, model := range getBaseModels() {
+		known[model.Name] = model
+	}
+
+	merged := make([]Model, 0, len(base))
+	for _, model := range base {
+		if builtin, ok := known[model.Name]; ok {
+			model.MaxCandidateCount = builtin.MaxCandidateCount
+			model.SupportsVision = builtin.SupportsVision
+			model.SupportsAudio = builtin.SupportsAudio
+			model.SupportsPenalties = builtin.SupportsPenalties
+		} else {
+			isImage := strings.Contains(model.Name, "-image")
+			model.SupportsVision = true
+			model.SupportsAudio = !isImage
+			model.SupportsPenalties = strings.Contains(model.Name, "flash") && !isImage
+		}
+		merged = append(merged, model)
+	}
+
+	models := buildSupportedModels(merged)
+	c.modelsMu.Lock()
+	defer c.modelsMu.Unlock()
+	c.SupportedModels = models
+}
+
+// IsModelAllowed applies ALLOWED_MODELS and DENIED_MODELS to a model name.
+// Listing a base model covers all of its variants; a denial always wins, so a
+// variant can be denied while its base model is allowed.
+func (c *Config) IsModelAllowed(modelName string) bool {
+	name := strings.TrimPrefix(modelName, "models/")
+	root := GetRootModelName(name)
+
+	if contains(c.DeniedModels, name) || contains(c.DeniedModels, root) {
+		return false
+	}
+	return len(c.AllowedModels) == 0 || contains(c.AllowedModels, name) || contains(c.AllowedModels, root)
+}
+
+// TextPartSeparator returns what TEXT_PART_JOIN puts between consecutive text
+// parts of a response
+func (c *Config) TextPartSeparator() string {
+	switch c.TextPartJoin {
+	case "newline":
+		return "\n"
+	case "blank_line":
+		return "\n\n"
+	}
+	return ""
+}
+
+// AvailableModels returns the supported models exposed by ALLOWED_MODELS and DENIED_MODELS
+func (c *Config) AvailableModels() []Model {
+	supported := c.supportedModels()
+	if len(c.AllowedModels) == 0 && len(c.DeniedModels) == 0 {
+		return supported
+	}
+
+	models := []Model{}
+	for _, model := range supported {
+		if c.IsModelAllowed(model.Name) {
+			models = append(models, model)
+		}
+	}
+	return models
+}
+
+// GetDefaultMaxOutputTokens returns the maxOutputTokens applied when a client
+// doesn't set one, clamped to the model's output limit, or 0 if none is configured
+func (c *Config) GetDefaultMaxOutputTokens(modelName string) int {
+	if c.DefaultMaxOutputTokens <= 0 {
+		return 0
+	}
+	if model := c.GetModel(GetBaseModelName(modelName)); model != nil && model.OutputTokenLimit > 0 && c.DefaultMaxOutputTokens > model.OutputTokenLimit {
+		return model.OutputTokenLimit
+	}
+	return c.DefaultMaxOutputTokens
+}
+
+// SafetyThresholdRank returns a threshold's position in SafetyThresholds, so
+// higher is stricter, or -1 for an unknown threshold
+func SafetyThresholdRank(threshold string) int {
+	for i, t := range SafetyThresholds {
+		if t == threshold {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsSafetyBlockMode reports whether mode is a valid SAFETY_BLOCK_MODE value
+func IsSafetyBlockMode(mode string) bool {
+	return mode == SafetyBlockError || mode == SafetyBlockEmpty
+}
+
+// ApplyModelDefaults fills in the model's configured temperature, topP and topK
+// for any of them the client didn't set. Zero model values are left to Google.
+func (c *Config) ApplyModelDefaults(generationConfig map[string]interface{}, modelName string) {
+	model := c.GetModel(GetBaseModelName(modelName))
+	if model == nil {
+		return
+	}
+	if _, ok := generationConfig["temperature"]; !ok && model.Temperature > 0 {
+		generationConfig["temperature"] = model.Temperature
+	}
+	if _, ok := generationConfig["topP"]; !ok && model.TopP > 0 {
+		generationConfig["topP"] = model.TopP
+	}
+	if _, ok := generationConfig["topK"]; !ok && model.TopK > 0 {
+		generationConfig["topK"] = model.TopK
+	}
+}
+
+// MergeStopSequences merges the configured default stop sequences into the
+// client-provided ones, removing duplicates and dropping any beyond Gemini's limit
+func (c *Config) MergeStopSequences(clientStops []string) []string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, stop := range append(append([]string{}, clientStops...), c.DefaultStopSequences...) {
+		if stop == "" || seen[stop] {
+			continue
+		}
+		seen[stop] = true
+		merged = append(merged, stop)
+	}
+
+	if len(merged) > MaxStopSequences {
+		log.Printf("Dropping %d stop sequences over the limit of %d: %q", len(merged)-MaxStopSequences, MaxStopSequences, merged[MaxStopSequences:])
+		merged = merged[:MaxStopSequences]
+	}
+	return merged
+}
+
+// Validate checks that the configuration is usable
+func (c *Config) Validate() error {
+	if c.Client.Name == "" {
+		return fmt.Errorf("CLIENT_NAME must not be empty")
+	}
+	if c.Client.Version == "" {
+		return fmt.Errorf("CLIENT_VERSION must not be empty")
+	}
+	if _, err := c.UpstreamTLSVersion(); err != nil {
+		return err
+	}
+	if _, err := c.UpstreamTLSCiphers(); err != nil {
+		return err
+	}
+	if c.RequestTimeout <= 0 || c.MaxRequestTimeout < c.RequestTimeout {
+		return fmt.Errorf("REQUEST_TIMEOUT must be positive and no greater than MAX_REQUEST_TIMEOUT")
+	}
+	if c.CompareMaxConcurrency < 1 {
+		return fmt.Errorf("COMPARE_MAX_CONCURRENCY must be at least 1")
+	}
+	switch c.TruncateStrategy {
+	case "none", "drop-oldest":
+	case "summarize-oldest":
+		return fmt.Errorf("TRUNCATE_STRATEGY summarize-oldest is not supported yet; use drop-oldest")
+	default:
+		return fmt.Errorf("TRUNCATE_STRATEGY must be none or drop-oldest, got %q", c.TruncateStrategy)
+	}
+	for name := range c.UpstreamExtraHeaders {
+		if contains(protectedUpstreamHeaders, http.CanonicalHeaderKey(name)) {
+			return fmt.Errorf("UPSTREAM_EXTRA_HEADERS must not set %s", name)
+		}
+	}
+	if !IsSafetyBlockMode(c.SafetyBlockMode) {
+		return fmt.Errorf("SAFETY_BLOCK_MODE must be %s or %s, got %q", SafetyBlockError, SafetyBlockEmpty, c.SafetyBlockMode)
+	}
+	if c.MinSafetyThreshold != "" && SafetyThresholdRank(c.MinSafetyThreshold) < 0 {
+		return fmt.Errorf("MIN_SAFETY_THRESHOLD must be one of %s, got %q", strings.Join(SafetyThresholds, ", "), c.MinSafetyThreshold)
+	}
+	switch c.ResponseContentFormat {
+	case "string", "array":
+	default:
+		return fmt.Errorf("RESPONSE_CONTENT_FORMAT must be string or array, got %q", c.ResponseContentFormat)
+	}
+	switch c.TextPartJoin {
+	case "concat", "newline", "blank_line":
+	default:
+		return fmt.Errorf("TEXT_PART_JOIN must be concat, newline or blank_line, got %q", c.TextPartJoin)
+	}
+	switch c.ToolCallValidation {
+	case "off", "finish", "retry":
+	default:
+		return fmt.Errorf("TOOL_CALL_VALIDATION must be off, finish or retry, got %q", c.ToolCallValidation)
+	}
+	if c.HighPrioritySlots < 0 || (c.HighPrioritySlots > 0 && c.HighPrioritySlots >= c.MaxConcurrentUpstream) {
+		return fmt.Errorf("HIGH_PRIORITY_SLOTS must be less than MAX_CONCURRENT_UPSTREAM, got %d", c.HighPrioritySlots)
+	}
+	if c.MaxStreamDuration < 0 {
+		return fmt.Errorf("MAX_STREAM_DURATION must not be negative, got %v", c.MaxStreamDuration)
+	}
+	for _, name := range c.BuiltinTools {
+		known := false
+		for _, builtin := range BuiltinToolNames {
+			known = known || name == builtin
+		}
+		if !known {
+			return fmt.Errorf("BUILTIN_TOOLS: unknown tool %q, must be one of %s", name, strings.Join(BuiltinToolNames, ", "))
+		}
+	}
+	if c.BuiltinToolMaxIterations < 1 {
+		return fmt.Errorf("BUILTIN_TOOL_MAX_ITERATIONS must be at least 1, got %d", c.BuiltinToolMaxIterations)
+	}
+	if c.ModelListRefresh < 0 {
+		return fmt.Errorf("MODEL_LIST_REFRESH must not be negative, got %v", c.ModelListRefresh)
+	}
+	if c.WarmupModel != "" && c.GetModel(c.WarmupModel) == nil {
+		return fmt.Errorf("WARMUP_MODEL %q is not a supported model", c.WarmupModel)
 	}
+	return nil
+}
+
+// UpstreamTLSVersion returns the minimum TLS version for upstream connections
+func (c *Config) UpstreamTLSVersion() (uint16, error) {
+	switch c.UpstreamTLSMinVersion {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("UPSTREAM_TLS_MIN_VERSION must be 1.2 or 1.3, got %q", c.UpstreamTLSMinVersion)
+}
+
+// UpstreamTLSCiphers resolves the configured cipher suite names for upstream
+// connections, or nil to use Go's defaults. Suites only apply up to TLS 1.2;
+// TLS 1.3 suites are not configurable.
+func (c *Config) UpstreamTLSCiphers() ([]uint16, error) {
+	if len(c.UpstreamTLSCipherSuites) == 0 {
+		return nil, nil
+	}
+
+	available := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		available[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(c.UpstreamTLSCipherSuites))
+	for _, name := range c.UpstreamTLSCipherSuites {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("UPSTREAM_TLS_CIPHER_SUITES: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// UserAgent returns the User-Agent header sent on upstream requests
+func (c *Config) UserAgent() string {
+	if c.Client.UserAgent != "" {
+		return c.Client.UserAgent
+	}
+	return fmt.Sprintf("GeminiCLI/%s (%s; %s)", c.Client.Version, runtime.GOOS, runtime.GOARCH)
+}
+
+// APIClientHeader returns the x-goog-api-client header sent on upstream requests
+func (c *Config) APIClientHeader() string {
+	return fmt.Sprintf("gl-go/%s %s/%s", strings.TrimPrefix(runtime.Version(), "go"), c.Client.Name, c.Client.Version)
+}
+
+// ModelCapabilities lists the capability names accepted by Model.HasCapability
+var ModelCapabilities = []string{"vision", "audio", "penalties"}
+
+// HasCapability reports whether the model supports a named input capability
+func (m *Model) HasCapability(capability string) bool {
+	switch capability {
+	case "vision":
+		return m.SupportsVision
+	case "audio":
+		return m.SupportsAudio
+	case "penalties":
+		return m.SupportsPenalties
+	}
+	return false
+}
+
+// SupportsMethod reports whether the model supports a generation method
+func (m *Model) SupportsMethod(method string) bool {
+	for _, supported := range m.SupportedGenerationMethods {
+		if supported == method {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaxCandidateCount returns the candidateCount limit for a model. The
+// MAX_CANDIDATE_COUNT override wins over the model's own limit.
+func (c *Config) GetMaxCandidateCount(modelName string) int {
+	if c.MaxCandidateCount > 0 {
+		return c.MaxCandidateCount
+	}
+	if model := c.GetModel(modelName); model != nil && model.MaxCandidateCount > 0 {
+		return model.MaxCandidateCount
+	}
+	return DefaultMaxCandidateCount
 }
 
 // getDefaultSafetySettings returns the default safety settings for Google API
@@ -111,6 +637,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         false,
 		},
 		{
 			Name:                      "models/gemini-2.5-pro-preview-05-06",
@@ -124,6 +653,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         false,
 		},
 		{
 			Name:                      "models/gemini-2.5-pro-preview-06-05",
@@ -137,6 +669,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         false,
 		},
 		{
 			Name:                      "models/gemini-2.5-pro",
@@ -150,6 +685,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         false,
 		},
 		{
 			Name:                      "models/gemini-2.5-flash-preview-05-20",
@@ -163,6 +701,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         true,
 		},
 		{
 			Name:                      "models/gemini-2.5-flash-preview-04-17",
@@ -176,6 +717,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         true,
 		},
 		{
 			Name:                      "models/gemini-2.5-flash",
@@ -189,6 +733,9 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             true,
+			SupportsPenalties:         true,
 		},
 		{
 			Name:                      "models/gemini-2.5-flash-image-preview",
@@ -202,13 +749,20 @@ func getBaseModels() []Model {
 			MaxTemperature:            2.0,
 			TopP:                      0.95,
 			TopK:                      64,
+			SupportsVision:            true,
+			SupportsAudio:             false,
+			SupportsPenalties:         false,
 		},
 	}
 }
 
 // generateSupportedModels generates all supported models including variants
 func generateSupportedModels() []Model {
-	baseModels := getBaseModels()
+	return buildSupportedModels(getBaseModels())
+}
+
+// buildSupportedModels adds the search and thinking variants of baseModels
+func buildSupportedModels(baseModels []Model) []Model {
 	var allModels []Model
 
 	// Add base models
@@ -282,6 +836,18 @@ func GetBaseModelName(modelName string) string {
 	return modelName
 }
 
+// GetRootModelName strips every variant suffix, so combined variants such as
+// "-search-nothinking" map to their base model
+func GetRootModelName(modelName string) string {
+	for {
+		base := GetBaseModelName(modelName)
+		if base == modelName {
+			return base
+		}
+		modelName = base
+	}
+}
+
 func IsSearchModel(modelName string) bool {
 	return strings.Contains(modelName, "-search")
 }
@@ -315,6 +881,31 @@ func GetThinkingBudget(modelName string) int {
 	return -1
 }
 
+// MinThinkingBudget returns the lowest thinking budget a model accepts. Pro
+// models can't turn thinking off, so they get their minimum of 128.
+func MinThinkingBudget(modelName string) int {
+	if strings.Contains(GetBaseModelName(modelName), "gemini-2.5-pro") {
+		return 128
+	}
+	return 0
+}
+
+// MaxThinkingBudget returns the largest thinking budget the model accepts
+func MaxThinkingBudget(modelName string) int {
+	if strings.Contains(GetBaseModelName(modelName), "gemini-2.5-pro") {
+		return 32768
+	}
+	return 24576
+}
+
+// DisabledThinkingConfig returns the thinkingConfig used when DISABLE_THINKING is set
+func DisabledThinkingConfig(modelName string) map[string]interface{} {
+	return map[string]interface{}{
+		"thinkingBudget":  MinThinkingBudget(modelName),
+		"includeThoughts": false,
+	}
+}
+
 func ShouldIncludeThoughts(modelName string) bool {
 	if IsNothinkingModel(modelName) {
 		baseModel := GetBaseModelName(modelName)
@@ -331,6 +922,102 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value := os.Getenv(key); value != "" {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	return defaultValue
+}
+
+// normalizeRoutePrefix ensures a prefix like "gemini/" becomes "/gemini"
+func normalizeRoutePrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return defaultValue
+}
+
+// getEnvDuration parses a duration such as "30s", or a plain number of seconds
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	return defaultValue
+}
+
+// getEnvList parses a list from an env var, given either as a JSON array or comma-separated
+func getEnvList(key string) []string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return nil
+	}
+
+	var list []string
+	if strings.HasPrefix(value, "[") {
+		if err := json.Unmarshal([]byte(value), &list); err != nil {
+			log.Printf("Invalid JSON list in %s: %v", key, err)
+			return nil
+		}
+		return list
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getEnvMap parses a JSON object of strings, such as {"x-goog-user-project": "my-project"}
+func getEnvMap(key string) map[string]string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return nil
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		log.Printf("Invalid JSON object in %s: %v", key, err)
+		return nil
+	}
+	return values
+}
+
+// getEnvObject parses a JSON object of arbitrary values from an environment variable
+func getEnvObject(key string) map[string]interface{} {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &values); err != nil {
+		log.Printf("Invalid JSON object in %s: %v", key, err)
+		return nil
+	}
+	return values
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
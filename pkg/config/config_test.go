@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestIsModelAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		model   string
+		want    bool
+	}{
+		{"no lists", nil, nil, "gemini-2.5-pro", true},
+		{"allowed", []string{"gemini-2.5-flash"}, nil, "gemini-2.5-flash", true},
+		{"not in the allow list", []string{"gemini-2.5-flash"}, nil, "gemini-2.5-pro", false},
+		{"allowed base covers variants", []string{"gemini-2.5-flash"}, nil, "gemini-2.5-flash-search-nothinking", true},
+		{"models/ prefix", []string{"gemini-2.5-flash"}, nil, "models/gemini-2.5-flash", true},
+		{"denied", nil, []string{"gemini-2.5-pro"}, "gemini-2.5-pro", false},
+		{"denied base covers variants", nil, []string{"gemini-2.5-pro"}, "gemini-2.5-pro-maxthinking", false},
+		{"deny wins over allow", []string{"gemini-2.5-pro"}, []string{"gemini-2.5-pro"}, "gemini-2.5-pro", false},
+		{"denied variant of an allowed base", []string{"gemini-2.5-pro"}, []string{"gemini-2.5-pro-maxthinking"}, "gemini-2.5-pro-maxthinking", false},
+		{"other variants of that base stay allowed", []string{"gemini-2.5-pro"}, []string{"gemini-2.5-pro-maxthinking"}, "gemini-2.5-pro-nothinking", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{AllowedModels: tt.allowed, DeniedModels: tt.denied}
+			if got := c.IsModelAllowed(tt.model); got != tt.want {
+				t.Errorf("IsModelAllowed(%q) = %v, want %v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
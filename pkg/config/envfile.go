@@ -0,0 +1,42 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// LoadEnvFiles loads the env files listed in ENV_FILE (comma-separated or a
+// JSON list), or ./.env when it is unset. Later files override earlier ones,
+// but variables already set in the environment always win. Missing files are
+// skipped silently.
+func LoadEnvFiles() {
+	paths := getEnvList("ENV_FILE")
+	if len(paths) == 0 {
+		paths = []string{".env"}
+	}
+
+	values := make(map[string]string)
+	for _, path := range paths {
+		fileValues, err := godotenv.Read(path)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("Failed to load env file %s: %v", path, err)
+			}
+			continue
+		}
+		for key, value := range fileValues {
+			values[key] = value
+		}
+		log.Printf("Loaded env file %s", path)
+	}
+
+	for key, value := range values {
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+}
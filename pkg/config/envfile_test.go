@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadEnvFilesFromCustomPath(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "base.env")
+	local := filepath.Join(dir, "local.env")
+	if err := os.WriteFile(base, []byte("ENVFILE_TEST_BASE=base\nENVFILE_TEST_SHARED=base\nENVFILE_TEST_PRESET=file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("ENVFILE_TEST_SHARED=local\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ENV_FILE", base+","+filepath.Join(dir, "missing.env")+","+local)
+	t.Setenv("ENVFILE_TEST_PRESET", "environment")
+	for _, key := range []string{"ENVFILE_TEST_BASE", "ENVFILE_TEST_SHARED"} {
+		key := key
+		t.Cleanup(func() { os.Unsetenv(key) })
+	}
+
+	LoadEnvFiles()
+
+	want := map[string]string{
+		"ENVFILE_TEST_BASE":   "base",
+		"ENVFILE_TEST_SHARED": "local",       // Later files override earlier ones
+		"ENVFILE_TEST_PRESET": "environment", // The environment always wins
+	}
+	for key, value := range want {
+		if got := os.Getenv(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package continuation
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const tokenPrefix = "ct_"
+
+// ErrUnknownToken is returned for malformed, expired or foreign tokens
+var ErrUnknownToken = errors.New("unknown or expired continuation token")
+
+// Store keeps the text streamed so far for resumable streams. A token names a
+// stream and a position in its text, so resuming from a token continues from
+// exactly the text the client had been sent when it received the token.
+type Store struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	streams map[string]*Stream
+}
+
+// Stream accumulates the text of one resumable stream
+type Stream struct {
+	id       string
+	model    string
+	identity string
+	store    *Store
+
+	mu      sync.Mutex
+	text    strings.Builder
+	expires time.Time
+}
+
+// NewStore creates a store whose streams expire ttl after their last update
+func NewStore(ttl time.Duration) *Store {
+	return &Store{
+		ttl:     ttl,
+		streams: make(map[string]*Stream),
+	}
+}
+
+// Start registers a new stream whose text begins with prefix, which is the text
+// already delivered when resuming an earlier stream
+func (s *Store) Start(model, identity, prefix string) *Stream {
+	stream := &Stream{
+		id:       strings.ReplaceAll(uuid.New().String(), "-", ""),
+		model:    model,
+		identity: identity,
+		store:    s,
+		expires:  time.Now().Add(s.ttl),
+	}
+	stream.text.WriteString(prefix)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.prune()
+	s.streams[stream.id] = stream
+	return stream
+}
+
+// Resume returns the text delivered up to token, which must belong to the
+// same model and identity
+func (s *Store) Resume(token, model, identity string) (string, error) {
+	id, offset, ok := parseToken(token)
+	if !ok {
+		return "", ErrUnknownToken
+	}
+
+	s.mu.Lock()
+	stream, ok := s.streams[id]
+	s.mu.Unlock()
+	if !ok || stream.model != model || stream.identity != identity {
+		return "", ErrUnknownToken
+	}
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if time.Now().After(stream.expires) {
+		return "", ErrUnknownToken
+	}
+	text := stream.text.String()
+	if offset > len(text) {
+		return "", ErrUnknownToken
+	}
+	return text[:offset], nil
+}
+
+// prune drops expired streams; the caller holds s.mu
+func (s *Store) prune() {
+	now := time.Now()
+	for id, stream := range s.streams {
+		stream.mu.Lock()
+		expired := now.After(stream.expires)
+		stream.mu.Unlock()
+		if expired {
+			delete(s.streams, id)
+		}
+	}
+}
+
+// Append adds streamed text
+func (st *Stream) Append(text string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.text.WriteString(text)
+	st.expires = time.Now().Add(st.store.ttl)
+}
+
+// Token returns a token for the text streamed so far
+func (st *Stream) Token() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return fmt.Sprintf("%s%s_%d", tokenPrefix, st.id, st.text.Len())
+}
+
+// parseToken splits a token into its stream ID and text offset
+func parseToken(token string) (string, int, bool) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return "", 0, false
+	}
+	id, offsetText, ok := strings.Cut(rest, "_")
+	if !ok {
+		return "", 0, false
+	}
+	offset, err := strconv.Atoi(offsetText)
+	if err != nil || offset < 0 {
+		return "", 0, false
+	}
+	return id, offset, true
+}
@@ -0,0 +1,132 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI error types
+const (
+	TypeInvalidRequest = "invalid_request_error"
+	TypeAuthentication = "authentication_error"
+	TypePermission     = "permission_error"
+	TypeRateLimit      = "rate_limit_error"
+	TypeAPI            = "api_error"
+)
+
+// OpenAI error codes for invalid request parameters
+const (
+	CodeInvalidValue     = "invalid_value"
+	CodeInvalidType      = "invalid_type"
+	CodeMissingParameter = "missing_required_parameter"
+	CodeUnsupportedValue = "unsupported_value"
+)
+
+// APIError is an OpenAI-compatible error object
+type APIError struct {
+	Message string        `json:"message"`
+	Type    string        `json:"type"`
+	Param   *string       `json:"param"`
+	Code    interface{}   `json:"code"`
+	Block   *BlockDetails `json:"block,omitempty"` // Set when Gemini blocked the prompt or response
+}
+
+// BlockDetails explains why Gemini blocked a prompt or response
+type BlockDetails struct {
+	BlockReason      string   `json:"block_reason,omitempty"`   // Prompt block reason
+	FinishReason     string   `json:"finish_reason,omitempty"`  // Candidate finish reason
+	FinishMessage    string   `json:"finish_message,omitempty"` // Google's explanation, when given
+	SafetyCategories []string `json:"safety_categories,omitempty"`
+}
+
+// Response is the OpenAI-compatible error envelope
+type Response struct {
+	Error APIError `json:"error"`
+}
+
+// TypeForStatus returns the OpenAI error type for an HTTP status code
+func TypeForStatus(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return TypeAuthentication
+	case status == http.StatusForbidden:
+		return TypePermission
+	case status == http.StatusTooManyRequests:
+		return TypeRateLimit
+	case status >= 400 && status < 500:
+		return TypeInvalidRequest
+	default:
+		return TypeAPI
+	}
+}
+
+// New creates an error envelope with the type derived from the status code
+func New(status int, message string) Response {
+	return Response{
+		Error: APIError{
+			Message: message,
+			Type:    TypeForStatus(status),
+			Code:    status,
+		},
+	}
+}
+
+// NewWithParam creates an error envelope that names the offending request parameter
+func NewWithParam(status int, message string, param string) Response {
+	resp := New(status, message)
+	if param != "" {
+		resp.Error.Param = &param
+	}
+	return resp
+}
+
+// ParamError is a validation error for a single request parameter. It
+// survives wrapping, so the envelope built by FromError names the parameter
+// even when callers add context to the message.
+type ParamError struct {
+	Param   string
+	Code    string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	return e.Message
+}
+
+// InvalidParam returns a ParamError for param with a formatted message
+func InvalidParam(param string, code string, format string, args ...interface{}) error {
+	return &ParamError{Param: param, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// FromError creates an error envelope from err, naming the parameter and
+// using its code when err wraps a ParamError
+func FromError(status int, err error) Response {
+	var paramErr *ParamError
+	if errors.As(err, &paramErr) {
+		resp := NewWithParam(status, err.Error(), paramErr.Param)
+		if paramErr.Code != "" {
+			resp.Error.Code = paramErr.Code
+		}
+		return resp
+	}
+	return New(status, err.Error())
+}
+
+// JSON writes an error envelope as the response
+func JSON(c *gin.Context, status int, message string) {
+	c.JSON(status, New(status, message))
+}
+
+// JSONError writes an error envelope for err as the response, including the
+// parameter and code of a ParamError
+func JSONError(c *gin.Context, status int, err error) {
+	c.JSON(status, FromError(status, err))
+}
+
+// AbortWithJSON writes an error envelope and aborts the handler chain
+func AbortWithJSON(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, New(status, message))
+}
@@ -0,0 +1,52 @@
+package errors
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJSONErrorShape(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		status   int
+		wantType string
+	}{
+		{http.StatusBadRequest, TypeInvalidRequest},
+		{http.StatusUnauthorized, TypeAuthentication},
+		{http.StatusTooManyRequests, TypeRateLimit},
+		{http.StatusInternalServerError, TypeAPI},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+
+			JSON(c, tt.status, "something went wrong")
+
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("body is not JSON: %v\n%s", err, w.Body)
+			}
+			want := map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": "something went wrong",
+					"type":    tt.wantType,
+					"param":   nil,
+					"code":    float64(tt.status),
+				},
+			}
+			if !reflect.DeepEqual(body, want) {
+				t.Errorf("body = %v, want %v", body, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package errors
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID that ties a client's error to the server log
+const RequestIDHeader = "X-Request-ID"
+
+// Recovery recovers from handler panics, logging them with a request ID and
+// answering with a 500 error envelope instead of gin's bare response. Streams
+// that have already started get a final SSE error event and [DONE]; the event
+// is shaped so both OpenAI and Anthropic clients recognize it.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// The client went away; let net/http handle it quietly
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+
+			requestID := c.GetHeader(RequestIDHeader)
+			if requestID == "" {
+				requestID = uuid.New().String()
+			}
+			log.Printf("Panic serving %s %s (request %s): %v\n%s", c.Request.Method, c.Request.URL.Path, requestID, recovered, debug.Stack())
+
+			message := fmt.Sprintf("Internal server error (request %s)", requestID)
+			if !c.Writer.Written() {
+				c.Header(RequestIDHeader, requestID)
+				AbortWithJSON(c, http.StatusInternalServerError, message)
+				return
+			}
+
+			if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+				event := map[string]interface{}{
+					"type":  "error",
+					"error": New(http.StatusInternalServerError, message).Error,
+				}
+				if data, err := json.Marshal(event); err == nil {
+					fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+					c.Writer.Write([]byte("data: [DONE]\n\n"))
+					c.Writer.Flush()
+				}
+			}
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,110 @@
+package google
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	apierrors "geminicli2api/pkg/errors"
+)
+
+// blockingFinishReasons are candidate finish reasons that mean output was withheld
+var blockingFinishReasons = map[string]bool{
+	"SAFETY":             true,
+	"RECITATION":         true,
+	"PROHIBITED_CONTENT": true,
+	"BLOCKLIST":          true,
+	"SPII":               true,
+	"IMAGE_SAFETY":       true,
+}
+
+// PromptBlockDetails returns why Gemini rejected the prompt, or nil if it didn't
+func PromptBlockDetails(response map[string]interface{}) *apierrors.BlockDetails {
+	feedback, _ := response["promptFeedback"].(map[string]interface{})
+	blockReason, _ := feedback["blockReason"].(string)
+	if blockReason == "" {
+		return nil
+	}
+
+	details := &apierrors.BlockDetails{
+		BlockReason:      blockReason,
+		SafetyCategories: blockedCategories(feedback["safetyRatings"]),
+	}
+	details.FinishMessage, _ = feedback["blockReasonMessage"].(string)
+	return details
+}
+
+// BlockDetails returns why a complete Gemini response was blocked, or nil if it
+// wasn't: either the prompt was rejected, or no candidate produced any content
+// and one stopped for a blocking reason such as SAFETY
+func BlockDetails(response map[string]interface{}) *apierrors.BlockDetails {
+	if details := PromptBlockDetails(response); details != nil {
+		return details
+	}
+
+	var blocked map[string]interface{}
+	for _, candidate := range toMapSlice(response["candidates"]) {
+		content, _ := candidate["content"].(map[string]interface{})
+		if len(toMapSlice(content["parts"])) > 0 {
+			return nil
+		}
+		if reason, _ := candidate["finishReason"].(string); blockingFinishReasons[reason] && blocked == nil {
+			blocked = candidate
+		}
+	}
+	if blocked == nil {
+		return nil
+	}
+
+	details := &apierrors.BlockDetails{
+		SafetyCategories: blockedCategories(blocked["safetyRatings"]),
+	}
+	details.FinishReason, _ = blocked["finishReason"].(string)
+	details.FinishMessage, _ = blocked["finishMessage"].(string)
+	return details
+}
+
+// BlockError builds the error envelope for a blocked prompt or response
+func BlockError(details *apierrors.BlockDetails) apierrors.Response {
+	resp := apierrors.New(http.StatusBadRequest, BlockMessage(details))
+	resp.Error.Block = details
+	return resp
+}
+
+// BlockMessage describes a block in one line, for error messages
+func BlockMessage(details *apierrors.BlockDetails) string {
+	subject, reason := "Response", details.FinishReason
+	if details.BlockReason != "" {
+		subject, reason = "Prompt", details.BlockReason
+	}
+
+	message := fmt.Sprintf("%s blocked by Gemini (%s)", subject, reason)
+	if len(details.SafetyCategories) > 0 {
+		message += " for " + strings.Join(details.SafetyCategories, ", ")
+	}
+	if details.FinishMessage != "" {
+		message += ": " + details.FinishMessage
+	}
+	return message
+}
+
+// blockedCategories returns the categories of safety ratings that caused a block,
+// falling back to those rated HIGH when none is marked blocked
+func blockedCategories(ratings interface{}) []string {
+	var blocked, high []string
+	for _, rating := range toMapSlice(ratings) {
+		category, _ := rating["category"].(string)
+		if category == "" {
+			continue
+		}
+		if isBlocked, _ := rating["blocked"].(bool); isBlocked {
+			blocked = append(blocked, category)
+		} else if probability, _ := rating["probability"].(string); probability == "HIGH" {
+			high = append(high, category)
+		}
+	}
+	if len(blocked) > 0 {
+		return blocked
+	}
+	return high
+}
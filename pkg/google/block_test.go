@@ -0,0 +1,69 @@
+package google
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	apierrors "geminicli2api/pkg/errors"
+)
+
+func TestBlockDetails(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    string
+		want        *apierrors.BlockDetails
+		wantMessage string
+	}{
+		{
+			name: "blocked prompt",
+			response: `{"promptFeedback": {"blockReason": "SAFETY", "safetyRatings": [
+				{"category": "HARM_CATEGORY_HARASSMENT", "probability": "NEGLIGIBLE"},
+				{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true}]}}`,
+			want:        &apierrors.BlockDetails{BlockReason: "SAFETY", SafetyCategories: []string{"HARM_CATEGORY_DANGEROUS_CONTENT"}},
+			wantMessage: "Prompt blocked by Gemini (SAFETY) for HARM_CATEGORY_DANGEROUS_CONTENT",
+		},
+		{
+			name: "blocked response",
+			response: `{"candidates": [{"finishReason": "SAFETY", "finishMessage": "Unsafe content", "safetyRatings": [
+				{"category": "HARM_CATEGORY_HATE_SPEECH", "probability": "HIGH"},
+				{"category": "HARM_CATEGORY_HARASSMENT", "probability": "LOW"}]}]}`,
+			want:        &apierrors.BlockDetails{FinishReason: "SAFETY", FinishMessage: "Unsafe content", SafetyCategories: []string{"HARM_CATEGORY_HATE_SPEECH"}},
+			wantMessage: "Response blocked by Gemini (SAFETY) for HARM_CATEGORY_HATE_SPEECH: Unsafe content",
+		},
+		{
+			name:     "partial content is not a block",
+			response: `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Partial"}]}, "finishReason": "SAFETY"}]}`,
+		},
+		{
+			name:     "normal stop",
+			response: `{"candidates": [{"finishReason": "STOP"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.response), &response); err != nil {
+				t.Fatalf("invalid test response: %v", err)
+			}
+
+			details := BlockDetails(response)
+			if !reflect.DeepEqual(details, tt.want) {
+				t.Fatalf("BlockDetails() = %+v, want %+v", details, tt.want)
+			}
+			if details == nil {
+				return
+			}
+
+			blockError := BlockError(details)
+			if blockError.Error.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", blockError.Error.Message, tt.wantMessage)
+			}
+			if blockError.Error.Code != http.StatusBadRequest || blockError.Error.Block != details {
+				t.Errorf("error = %+v, want a 400 carrying the block details", blockError.Error)
+			}
+		})
+	}
+}
@@ -5,15 +5,25 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/oauth2"
+
 	"geminicli2api/pkg/auth"
 	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/metrics"
+	"geminicli2api/pkg/timing"
+	"geminicli2api/pkg/transport"
 )
 
 // Client handles communication with Google's Gemini API
@@ -21,50 +31,87 @@ type Client struct {
 	authConfig   *auth.AuthConfig
 	httpClient   *http.Client
 	config       *config.Config
+	upstreamSem  *slotPool
+	coalescer    *streamCoalescer
+	quotaHolds   *quotaHolds
 }
 
 // NewClient creates a new Google API client
 func NewClient(authConfig *auth.AuthConfig, cfg *config.Config) *Client {
-	return &Client{
+	client := &Client{
 		authConfig: authConfig,
 		httpClient: &http.Client{
-			Timeout: 60 * time.Second,
+			// A hard cap; requests are normally bounded by their context deadline
+			Timeout:   cfg.MaxRequestTimeout,
+			Transport: authConfig.Transport,
 		},
 		config: cfg,
 	}
+
+	// Limit concurrent upstream generate calls when configured, keeping some
+	// slots for high priority requests
+	if cfg.MaxConcurrentUpstream > 0 {
+		client.upstreamSem = newSlotPool(cfg.MaxConcurrentUpstream, cfg.HighPrioritySlots)
+	}
+
+	// Share upstream streams among identical deterministic requests when enabled
+	if cfg.StreamCoalescing {
+		client.coalescer = newStreamCoalescer()
+	}
+
+	// Hold requests for a model after a 429 until its retry delay passes
+	if cfg.QuotaHoldMax > 0 {
+		client.quotaHolds = newQuotaHolds(cfg.QuotaHoldMax)
+	}
+
+	return client
 }
 
-// SendGeminiRequest sends a request to Google's Gemini API
+// SendGeminiRequest sends a request to Google's Gemini API. Non-streaming
+// requests that fail with a retryable 5xx are sent again from scratch, up to
+// UPSTREAM_RETRIES times, so credentials and onboarding are re-checked.
 func (c *Client) SendGeminiRequest(ctx context.Context, payload map[string]interface{}, isStreaming bool) (*http.Response, error) {
-	// Get and validate credentials
-	token, err := c.authConfig.GetCredentials(true)
-	if err != nil {
-		return nil, fmt.Errorf("authentication failed: %w", err)
-	}
-	if token == nil {
-		return nil, fmt.Errorf("no credentials available")
+	if isStreaming {
+		return c.sendGeminiRequestOnce(ctx, payload, true)
 	}
 
-	// Refresh token if needed
-	if !token.Valid() && token.RefreshToken != "" {
-		if err := c.authConfig.RefreshToken(token); err != nil {
-			return nil, fmt.Errorf("token refresh failed: %w", err)
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		resp, err := c.sendGeminiRequestOnce(ctx, payload, false)
+		if err != nil || !isRetryableStatus(resp.StatusCode) || attempt >= c.config.UpstreamRetries {
+			return resp, err
 		}
-		// Save refreshed credentials
-		c.authConfig.SaveCredentials(token, "")
-	} else if token.AccessToken == "" {
-		return nil, fmt.Errorf("no access token available")
+		resp.Body.Close()
+
+		log.Printf("Google API returned status %d, retrying in %v (attempt %d/%d)", resp.StatusCode, backoff, attempt+1, c.config.UpstreamRetries)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
 	}
+}
 
-	// Get project ID and onboard user
-	projectID, err := c.authConfig.GetUserProjectID(token)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user project ID: %w", err)
+// isRetryableStatus reports whether an upstream status is a transient server error
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
 	}
+	return false
+}
 
-	if err := c.authConfig.OnboardUser(token, projectID); err != nil {
-		return nil, fmt.Errorf("user onboarding failed: %w", err)
+// sendGeminiRequestOnce makes a single attempt at a Gemini API request
+func (c *Client) sendGeminiRequestOnce(ctx context.Context, payload map[string]interface{}, isStreaming bool) (*http.Response, error) {
+	timings := timing.FromContext(ctx)
+	authStart := time.Now()
+
+	token, projectID, err := c.authenticate(ctx)
+	if err != nil {
+		return nil, err
 	}
+	timings.Track("auth", authStart)
 
 	// Build the final payload
 	finalPayload := map[string]interface{}{
@@ -96,22 +143,137 @@ func (c *Client) SendGeminiRequest(ctx context.Context, payload map[string]inter
 	}
 
 	// Set headers
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("User-Agent", getUserAgent())
+	c.authConfig.SetRequestHeaders(req, token.AccessToken, projectID)
+
+	// Wait out any quota hold on the model, then for an upstream slot,
+	// queueing until the context is done
+	queueStart := time.Now()
+	model, _ := payload["model"].(string)
+	if c.quotaHolds != nil {
+		if err := c.quotaHolds.Wait(ctx, model); err != nil {
+			return nil, err
+		}
+	}
+	release, err := c.acquireUpstreamSlot(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("waiting for upstream slot: %w", err)
+	}
+	timings.Track("queue", queueStart)
 
 	// Send request
+	upstreamStart := time.Now()
+	defer timings.Track("upstream", upstreamStart)
 	if isStreaming {
-		return c.sendStreamingRequest(req)
+		resp, err := c.sendStreamingRequest(req)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		c.recordQuotaHold(model, resp)
+		resp = c.explainModelNotFound(model, resp)
+		// Hold the slot until the stream body is closed
+		resp.Body = &releasingReadCloser{ReadCloser: resp.Body, release: release}
+		return resp, nil
+	}
+	defer release()
+	resp, err := c.sendNonStreamingRequest(req)
+	if err != nil {
+		return nil, err
 	}
-	return c.sendNonStreamingRequest(req)
+	c.recordQuotaHold(model, resp)
+	return c.explainModelNotFound(model, resp), nil
+}
+
+// recordQuotaHold starts a quota hold on model when Google answered 429 with a
+// retry delay
+func (c *Client) recordQuotaHold(model string, resp *http.Response) {
+	if c.quotaHolds == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		log.Printf("Google rate limited %s, holding its requests for %ds", model, seconds)
+		c.quotaHolds.Record(model, time.Duration(seconds)*time.Second)
+	}
+}
+
+// authenticate returns a valid access token and the onboarded project ID.
+// Refreshing credentials is abandoned once ctx is done.
+func (c *Client) authenticate(ctx context.Context) (*oauth2.Token, string, error) {
+	// Get and validate credentials
+	token, err := c.authConfig.GetCredentialsContext(ctx, true)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrAuthentication, err)
+	}
+	if token == nil {
+		return nil, "", auth.ErrNoCredentials
+	}
+
+	// Refresh token if needed
+	if !token.Valid() && token.RefreshToken != "" {
+		if err := c.authConfig.RefreshTokenContext(ctx, token); err != nil {
+			if ctx.Err() != nil {
+				// The client gave up, which says nothing about the credentials
+				return nil, "", err
+			}
+			return nil, "", fmt.Errorf("%w: token refresh failed: %w", ErrAuthentication, err)
+		}
+		// Save refreshed credentials
+		c.authConfig.SaveCredentials(token, "")
+	} else if token.AccessToken == "" {
+		return nil, "", fmt.Errorf("%w: no access token available", ErrAuthentication)
+	}
+
+	// Get project ID and onboard user
+	projectID, err := c.authConfig.GetUserProjectID(token)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get user project ID: %w", err)
+	}
+
+	if err := c.authConfig.OnboardUser(token, projectID); err != nil {
+		return nil, "", fmt.Errorf("user onboarding failed: %w", err)
+	}
+
+	return token, projectID, nil
+}
+
+// acquireUpstreamSlot reserves a slot for an in-flight upstream call, scheduled
+// by the context's priority, and returns a function that releases it. Release
+// is idempotent.
+func (c *Client) acquireUpstreamSlot(ctx context.Context) (func(), error) {
+	if c.upstreamSem != nil {
+		if err := c.upstreamSem.Acquire(ctx, priorityFromContext(ctx)); err != nil {
+			return nil, err
+		}
+	}
+	metrics.UpstreamInFlight.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			metrics.UpstreamInFlight.Dec()
+			if c.upstreamSem != nil {
+				c.upstreamSem.Release()
+			}
+		})
+	}, nil
+}
+
+// releasingReadCloser releases an upstream slot when the body is closed
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
 }
 
 // sendStreamingRequest sends a streaming request
 func (c *Client) sendStreamingRequest(req *http.Request) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, upstreamRequestError(err)
 	}
 
 	// Check for HTTP errors
@@ -119,7 +281,7 @@ func (c *Client) sendStreamingRequest(req *http.Request) (*http.Response, error)
 		log.Printf("Google API returned status %d", resp.StatusCode)
 		defer resp.Body.Close()
 		body, _ := io.ReadAll(resp.Body)
-		return createErrorResponse(resp.StatusCode, string(body)), nil
+		return upstreamErrorResponse(resp, body), nil
 	}
 
 	return resp, nil
@@ -129,7 +291,7 @@ func (c *Client) sendStreamingRequest(req *http.Request) (*http.Response, error)
 func (c *Client) sendNonStreamingRequest(req *http.Request) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, upstreamRequestError(err)
 	}
 
 	if resp.StatusCode == http.StatusOK {
@@ -139,44 +301,35 @@ func (c *Client) sendNonStreamingRequest(req *http.Request) (*http.Response, err
 			return nil, fmt.Errorf("failed to read response body: %w", err)
 		}
 
-		// Parse response
-		var googleAPIResponse map[string]interface{}
-		bodyStr := string(body)
-		if strings.HasPrefix(bodyStr, "data: ") {
-			bodyStr = bodyStr[6:]
-		}
-
-		if err := json.Unmarshal([]byte(bodyStr), &googleAPIResponse); err != nil {
-			// If parsing fails, return original response
-			return createRawResponse(resp.StatusCode, body, resp.Header.Get("Content-Type")), nil
-		}
-
-		if response, ok := googleAPIResponse["response"].(map[string]interface{}); ok {
-			responseData, _ := json.Marshal(response)
+		// Parse the response, which may be a single object or several chunks
+		googleAPIResponse, ok := parseGenerateResponse(body)
+		if ok {
+			responseData, _ := json.Marshal(googleAPIResponse)
 			return createRawResponse(http.StatusOK, responseData, "application/json; charset=utf-8"), nil
 		}
 
-		// Fallback to original response
+		// If parsing fails, return original response
 		return createRawResponse(resp.StatusCode, body, resp.Header.Get("Content-Type")), nil
 	}
 
 	// Handle error responses
 	defer resp.Body.Close()
 	body, _ := io.ReadAll(resp.Body)
-	return createErrorResponse(resp.StatusCode, string(body)), nil
+	return upstreamErrorResponse(resp, body), nil
 }
 
 // BuildGeminiPayloadFromOpenAI builds a Gemini API payload from an OpenAI-transformed request
 func (c *Client) BuildGeminiPayloadFromOpenAI(openaiPayload map[string]interface{}) map[string]interface{} {
 	model := openaiPayload["model"]
 
-	// Get safety settings or use defaults
-	safetySettings := c.config.SafetySettings
-	if ss, ok := openaiPayload["safetySettings"]; ok {
-		if ssSlice, ok := ss.([]map[string]interface{}); ok {
-			safetySettings = ssSlice
-		}
+	// Use per-request safety settings if given, otherwise the configured
+	// defaults, never looser than MIN_SAFETY_THRESHOLD
+	var safetySettings interface{} = c.config.SafetySettings
+	switch ss := openaiPayload["safetySettings"].(type) {
+	case []map[string]interface{}, []interface{}:
+		safetySettings = ss
 	}
+	safetySettings = c.applySafetyFloor(safetySettings)
 
 	// Build the request portion
 	requestData := map[string]interface{}{
@@ -203,6 +356,39 @@ func (c *Client) BuildGeminiPayloadFromOpenAI(openaiPayload map[string]interface
 	}
 }
 
+// normalizeSystemInstruction moves a snake_case system_instruction to
+// systemInstruction and converts a bare string, a single part or a list of
+// parts to Gemini's {"parts": [...]} content object. The camelCase key wins if
+// both are sent.
+func normalizeSystemInstruction(request map[string]interface{}) {
+	instruction, ok := request["systemInstruction"]
+	if snake, hasSnake := request["system_instruction"]; hasSnake {
+		delete(request, "system_instruction")
+		if !ok {
+			instruction, ok = snake, true
+		}
+	}
+	if !ok || instruction == nil {
+		return
+	}
+
+	switch value := instruction.(type) {
+	case string:
+		instruction = map[string]interface{}{
+			"parts": []interface{}{map[string]interface{}{"text": value}},
+		}
+	case []interface{}:
+		instruction = map[string]interface{}{"parts": value}
+	case map[string]interface{}:
+		if _, hasParts := value["parts"]; !hasParts {
+			if _, isPart := value["text"]; isPart {
+				instruction = map[string]interface{}{"parts": []interface{}{value}}
+			}
+		}
+	}
+	request["systemInstruction"] = instruction
+}
+
 // BuildGeminiPayloadFromNative builds a Gemini API payload from a native Gemini request
 func (c *Client) BuildGeminiPayloadFromNative(nativeRequest map[string]interface{}, modelFromPath string) map[string]interface{} {
 	// Create a copy to avoid modifying the original
@@ -211,8 +397,11 @@ func (c *Client) BuildGeminiPayloadFromNative(nativeRequest map[string]interface
 		request[k] = v
 	}
 
-	// Set safety settings
-	request["safetySettings"] = c.config.SafetySettings
+	// Accept the system instruction in any of the forms clients send
+	normalizeSystemInstruction(request)
+
+	// Set safety settings, never looser than MIN_SAFETY_THRESHOLD
+	request["safetySettings"] = c.applySafetyFloor(c.config.SafetySettings)
 
 	// Ensure generationConfig exists
 	if _, ok := request["generationConfig"]; !ok {
@@ -220,13 +409,41 @@ func (c *Client) BuildGeminiPayloadFromNative(nativeRequest map[string]interface
 	}
 	genConfig := request["generationConfig"].(map[string]interface{})
 
+	// Cap output length when the client doesn't set a limit
+	if _, ok := genConfig["maxOutputTokens"]; !ok {
+		if maxTokens := c.config.GetDefaultMaxOutputTokens(modelFromPath); maxTokens > 0 {
+			genConfig["maxOutputTokens"] = maxTokens
+		}
+	}
+
+	// Fall back to the model's sampling defaults for anything the client omitted
+	c.config.ApplyModelDefaults(genConfig, modelFromPath)
+
+	// Merge configured default stop sequences with the client's
+	var stopSequences []string
+	if stops, ok := genConfig["stopSequences"].([]interface{}); ok {
+		for _, stop := range stops {
+			if stopStr, ok := stop.(string); ok {
+				stopSequences = append(stopSequences, stopStr)
+			}
+		}
+	}
+	if stopSequences = c.config.MergeStopSequences(stopSequences); len(stopSequences) > 0 {
+		genConfig["stopSequences"] = stopSequences
+	}
+
+	// The global switch overrides model variants and client-provided values
+	if c.config.DisableThinking && !strings.Contains(modelFromPath, "gemini-2.5-flash-image") {
+		genConfig["thinkingConfig"] = config.DisabledThinkingConfig(modelFromPath)
+	}
+
 	// Ensure thinkingConfig exists
 	if _, ok := genConfig["thinkingConfig"]; !ok {
 		genConfig["thinkingConfig"] = make(map[string]interface{})
 	}
 	thinkingConfig := genConfig["thinkingConfig"].(map[string]interface{})
 
-	if !strings.Contains(modelFromPath, "gemini-2.5-flash-image") {
+	if !strings.Contains(modelFromPath, "gemini-2.5-flash-image") && !c.config.DisableThinking {
 		// Configure thinking based on model variant
 		thinkingBudget := config.GetThinkingBudget(modelFromPath)
 		includeThoughts := config.ShouldIncludeThoughts(modelFromPath)
@@ -269,67 +486,135 @@ func (c *Client) BuildGeminiPayloadFromNative(nativeRequest map[string]interface
 	}
 }
 
-// StreamResponse handles streaming response
-func (c *Client) StreamResponse(resp *http.Response) <-chan []byte {
-	ch := make(chan []byte)
+// StreamChunk is a single parsed chunk of an upstream stream. Err is set when
+// the stream failed part way through.
+type StreamChunk struct {
+	Data map[string]interface{}
+	Err  error
+}
+
+// StreamResponse parses a streaming response into chunks, unwrapping the
+// internal "response" envelope. It stops early when ctx is done.
+func (c *Client) StreamResponse(ctx context.Context, resp *http.Response) <-chan StreamChunk {
+	ch := make(chan StreamChunk)
 
 	go func() {
 		defer close(ch)
 		defer resp.Body.Close()
 
+		send := func(chunk StreamChunk) bool {
+			select {
+			case ch <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
 		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, 64*1024), c.config.StreamMaxLineBytes)
+
+		// pending holds data that didn't parse yet, in case a JSON object
+		// was split across several lines of the same event
+		var pending strings.Builder
+
 		for scanner.Scan() {
 			line := scanner.Text()
-			if strings.HasPrefix(line, "data: ") {
-				data := line[6:] // Remove "data: " prefix
-				if data != "" && data != "[DONE]" {
-					if obj := c.parseChunk(data); obj != nil {
-						if response, ok := obj["response"].(map[string]interface{}); ok {
-							if responseJSON, err := json.Marshal(response); err == nil {
-								ch <- []byte(fmt.Sprintf("data: %s\n\n", string(responseJSON)))
-							}
-						} else {
-							if objJSON, err := json.Marshal(obj); err == nil {
-								ch <- []byte(fmt.Sprintf("data: %s\n\n", string(objJSON)))
-							}
-						}
+
+			var data string
+			switch {
+			case strings.HasPrefix(line, "data: "):
+				data = line[6:] // Remove "data: " prefix
+			case line == "":
+				// Event boundary: anything still pending is unrecoverable
+				if pending.Len() > 0 {
+					log.Printf("Dropping unparseable stream chunk (%d bytes)", pending.Len())
+					pending.Reset()
+				}
+				continue
+			case pending.Len() > 0:
+				data = line // Continuation of a split chunk
+			default:
+				continue
+			}
+
+			if pending.Len() == 0 && (data == "" || data == "[DONE]") {
+				continue
+			}
+
+			if pending.Len() > 0 {
+				pending.WriteString("\n")
+			}
+			pending.WriteString(data)
+
+			if objects, ok := c.parseChunk(pending.String()); ok {
+				pending.Reset()
+				for _, obj := range objects {
+					if !send(StreamChunk{Data: obj}) {
+						return
 					}
 				}
 			}
 		}
 
+		if pending.Len() > 0 {
+			log.Printf("Dropping incomplete stream chunk at end of response (%d bytes)", pending.Len())
+		}
+
 		if err := scanner.Err(); err != nil {
 			log.Printf("Error reading streaming response: %v", err)
-			errorResponse := map[string]interface{}{
-				"error": map[string]interface{}{
-					"message": fmt.Sprintf("Streaming error: %v", err),
-					"type":    "api_error",
-					"code":    500,
-				},
-			}
-			if errorJSON, err := json.Marshal(errorResponse); err == nil {
-				ch <- []byte(fmt.Sprintf("data: %s\n\n", string(errorJSON)))
-			}
+			send(StreamChunk{Err: err})
 		}
 	}()
 
 	return ch
 }
 
-// parseChunk parses a JSON chunk from the streaming response
-func (c *Client) parseChunk(chunk string) map[string]interface{} {
-	var obj map[string]interface{}
-	if err := json.Unmarshal([]byte(chunk), &obj); err != nil {
-		log.Printf("Failed to parse chunk: %v", err)
-		return nil
+// parseChunk parses a JSON chunk from the streaming response into unwrapped
+// responses, the same way non-streaming bodies are parsed. ok is false if the
+// chunk is not (yet) a complete JSON object or array.
+func (c *Client) parseChunk(chunk string) ([]map[string]interface{}, bool) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(chunk), &value); err != nil {
+		return nil, false
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return unwrapGeminiValue(value), true
 	}
-	return obj
+	return nil, false
 }
 
 // Helper functions
 
-func getUserAgent() string {
-	return "geminicli2api/1.0.0 (go)"
+// ErrAuthentication marks requests that failed because the stored Google
+// credentials couldn't be loaded or refreshed
+var ErrAuthentication = errors.New("authentication failed")
+
+// ErrUpstreamConnection marks requests that failed before a connection to Google
+// was established or before it answered, as opposed to a slow generation
+var ErrUpstreamConnection = errors.New("connecting to Google failed")
+
+// upstreamRequestError describes a failed upstream round trip, naming the
+// connection phase when that is where it failed
+func upstreamRequestError(err error) error {
+	if phase := transport.FailedPhase(err); phase != "" {
+		log.Printf("Upstream connection failed during %s: %v", phase, err)
+		return fmt.Errorf("%w during %s: %w", ErrUpstreamConnection, phase, err)
+	}
+	return fmt.Errorf("request failed: %w", err)
+}
+
+// upstreamErrorResponse converts an upstream error into the proxy's error
+// envelope, carrying a 429's retry delay as a Retry-After header in seconds
+func upstreamErrorResponse(resp *http.Response, body []byte) *http.Response {
+	errorResp := createErrorResponse(resp.StatusCode, string(body))
+	if resp.StatusCode == http.StatusTooManyRequests {
+		if delay := retryDelay(resp.Header, body); delay > 0 {
+			errorResp.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		}
+	}
+	return errorResp
 }
 
 func createErrorResponse(statusCode int, body string) *http.Response {
@@ -348,15 +633,7 @@ func createErrorResponse(statusCode int, body string) *http.Response {
 		}
 	}
 
-	errorResponse := map[string]interface{}{
-		"error": map[string]interface{}{
-			"message": errorMessage,
-			"type":    "invalid_request_error",
-			"code":    statusCode,
-		},
-	}
-
-	errorBody, _ := json.Marshal(errorResponse)
+	errorBody, _ := json.Marshal(apierrors.New(statusCode, errorMessage))
 
 	return &http.Response{
 		StatusCode: statusCode,
@@ -0,0 +1,246 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"geminicli2api/pkg/auth"
+	"geminicli2api/pkg/config"
+)
+
+// streamChunks runs body through StreamResponse and collects the chunks
+func streamChunks(t *testing.T, body string) []StreamChunk {
+	t.Helper()
+	c := &Client{config: config.NewConfig()}
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}
+
+	var chunks []StreamChunk
+	for chunk := range c.StreamResponse(context.Background(), resp) {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// chunkText returns the text of a chunk's first part
+func chunkText(chunk StreamChunk) string {
+	candidates, _ := chunk.Data["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		return ""
+	}
+	candidate, _ := candidates[0].(map[string]interface{})
+	content, _ := candidate["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+	if len(parts) == 0 {
+		return ""
+	}
+	part, _ := parts[0].(map[string]interface{})
+	text, _ := part["text"].(string)
+	return text
+}
+
+func TestStreamResponseLargeChunk(t *testing.T) {
+	text := strings.Repeat("a", 100*1024)
+	body := `data: {"response": {"candidates": [{"content": {"parts": [{"text": "` + text + `"}]}}]}}` + "\n\n"
+
+	chunks := streamChunks(t, body)
+
+	if len(chunks) != 1 || chunks[0].Err != nil {
+		t.Fatalf("got %d chunks (%+v), want one chunk without error", len(chunks), chunks)
+	}
+	if got := chunkText(chunks[0]); got != text {
+		t.Errorf("text has %d bytes, want %d", len(got), len(text))
+	}
+}
+
+func TestStreamResponseSplitChunk(t *testing.T) {
+	body := "data: {\"response\": {\"candidates\": [\n" +
+		"data: {\"content\": {\"parts\": [{\"text\": \"split\"}]}}\n" +
+		"data: ]}}\n\n" +
+		"data: {\"response\": {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"whole\"}]}}]}}\n\n"
+
+	chunks := streamChunks(t, body)
+
+	if len(chunks) != 2 {
+		t.Fatalf("got %d chunks, want 2", len(chunks))
+	}
+	if got := chunkText(chunks[0]); got != "split" {
+		t.Errorf("first chunk text = %q, want the reassembled %q", got, "split")
+	}
+	if got := chunkText(chunks[1]); got != "whole" {
+		t.Errorf("second chunk text = %q, want %q", got, "whole")
+	}
+}
+
+func TestStreamResponseDropsUnparseableChunk(t *testing.T) {
+	body := "data: {\"response\": {\"candidates\": [\n\n" +
+		"data: {\"response\": {\"candidates\": [{\"content\": {\"parts\": [{\"text\": \"next\"}]}}]}}\n\n"
+
+	chunks := streamChunks(t, body)
+
+	if len(chunks) != 1 || chunkText(chunks[0]) != "next" {
+		t.Errorf("got %+v, want only the chunk after the broken event", chunks)
+	}
+}
+
+// buildNative runs a native request given as JSON through
+// BuildGeminiPayloadFromNative and returns its generationConfig
+func buildNative(t *testing.T, cfg *config.Config, literal string, model string) map[string]interface{} {
+	t.Helper()
+	var request map[string]interface{}
+	if err := json.Unmarshal([]byte(literal), &request); err != nil {
+		t.Fatalf("invalid test request %s: %v", literal, err)
+	}
+	c := &Client{config: cfg}
+	payload := c.BuildGeminiPayloadFromNative(request, model)
+	return payload["request"].(map[string]interface{})["generationConfig"].(map[string]interface{})
+}
+
+func TestNativeDisableThinkingOverridesClientConfig(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DisableThinking = true
+	request := `{"contents": [{"role": "user", "parts": [{"text": "Hi"}]}],
+		"generationConfig": {"thinkingConfig": {"thinkingBudget": 1024, "includeThoughts": true}}}`
+
+	for _, model := range []string{"gemini-2.5-flash", "gemini-2.5-flash-maxthinking", "gemini-2.5-flash-nothinking"} {
+		t.Run(model, func(t *testing.T) {
+			generationConfig := buildNative(t, cfg, request, model)
+
+			want := map[string]interface{}{"thinkingBudget": 0, "includeThoughts": false}
+			if got := generationConfig["thinkingConfig"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("thinkingConfig = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNativeDefaultMaxOutputTokens(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DefaultMaxOutputTokens = 1000
+
+	absent := buildNative(t, cfg, `{"contents": [{"role": "user", "parts": [{"text": "Hi"}]}]}`, "gemini-2.5-flash")
+	if got := absent["maxOutputTokens"]; got != 1000 {
+		t.Errorf("maxOutputTokens without a client value = %v, want 1000", got)
+	}
+
+	present := buildNative(t, cfg, `{"contents": [{"role": "user", "parts": [{"text": "Hi"}]}], "generationConfig": {"maxOutputTokens": 50}}`, "gemini-2.5-flash")
+	if got := present["maxOutputTokens"]; got != float64(50) {
+		t.Errorf("maxOutputTokens with a client value = %v, want the client's 50", got)
+	}
+}
+
+// newTestClient returns a client whose Code Assist endpoint is a fake server.
+// loadCodeAssist reports an onboarded user; other requests go to handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":loadCodeAssist") {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"currentTier": {"id": "free-tier", "name": "Free"}, "cloudaicompanionProject": "test-project"}`)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "test-project")
+	t.Setenv("GEMINI_CREDENTIALS", fmt.Sprintf(`{"refresh_token": "refresh", "token": "access", "expiry": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339)))
+	cfg := config.NewConfig()
+	cfg.CredentialFile = filepath.Join(t.TempDir(), "oauth_creds.json")
+	cfg.CodeAssistEndpoint = server.URL
+	authConfig := auth.NewAuthConfig(cfg)
+	return NewClient(authConfig, cfg)
+}
+
+func TestSendGeminiRequestRetriesServerError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		stream     bool
+		wantStatus int
+		wantCalls  int32
+	}{
+		{"503 then 200", []int{http.StatusServiceUnavailable, http.StatusOK}, false, http.StatusOK, 2},
+		{"503 twice", []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK}, false, http.StatusServiceUnavailable, 2},
+		{"429 is not retried", []int{http.StatusTooManyRequests, http.StatusOK}, false, http.StatusTooManyRequests, 1},
+		{"streams are not retried", []int{http.StatusServiceUnavailable, http.StatusOK}, true, http.StatusServiceUnavailable, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[atomic.AddInt32(&calls, 1)-1]
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					fmt.Fprint(w, `{"response": {"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}}]}}`)
+				} else {
+					fmt.Fprintf(w, `{"error": {"code": %d, "message": "failed"}}`, status)
+				}
+			})
+			c.config.UpstreamRetries = 1
+			payload := c.BuildGeminiPayloadFromNative(map[string]interface{}{
+				"contents": []interface{}{map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": "Hi"}}}},
+			}, "gemini-2.5-flash")
+
+			resp, err := c.SendGeminiRequest(context.Background(), payload, tt.stream)
+			if err != nil {
+				t.Fatalf("SendGeminiRequest() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("upstream called %d times, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestNormalizeSystemInstruction(t *testing.T) {
+	want := map[string]interface{}{"parts": []interface{}{map[string]interface{}{"text": "Be brief"}}}
+	tests := []struct {
+		name    string
+		request string
+		want    interface{} // nil when no systemInstruction is expected
+	}{
+		{"string", `{"systemInstruction": "Be brief"}`, want},
+		{"single part", `{"systemInstruction": {"text": "Be brief"}}`, want},
+		{"list of parts", `{"systemInstruction": [{"text": "Be brief"}]}`, want},
+		{"content object", `{"systemInstruction": {"role": "system", "parts": [{"text": "Be brief"}]}}`,
+			map[string]interface{}{"role": "system", "parts": []interface{}{map[string]interface{}{"text": "Be brief"}}}},
+		{"snake case", `{"system_instruction": "Be brief"}`, want},
+		{"camel case wins", `{"systemInstruction": "Be brief", "system_instruction": "Ignored"}`, want},
+		{"absent", `{}`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.request), &request); err != nil {
+				t.Fatalf("invalid test request: %v", err)
+			}
+
+			normalizeSystemInstruction(request)
+
+			if got := request["systemInstruction"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("systemInstruction = %v, want %v", got, tt.want)
+			}
+			if _, ok := request["system_instruction"]; ok {
+				t.Error("system_instruction was left in the request")
+			}
+		})
+	}
+}
@@ -0,0 +1,294 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// streamCoalescer shares one upstream stream among identical concurrent requests.
+//
+// Correctness constraints:
+//   - Only deterministic requests (temperature 0, a single candidate) are
+//     coalesced, since everyone receives the same output.
+//   - The key covers the entire upstream payload, so any difference in model,
+//     contents or generation settings yields a separate upstream call.
+//   - Late joiners replay the stream from the start, so every client sees a
+//     complete response. Chunks are buffered until the stream ends.
+//   - Upstream errors are shared by every waiter of the same stream.
+//   - The upstream call is cancelled only once every waiter has gone away.
+//   - The shared call runs with the first requester's priority and deadline.
+//     Priority is part of the key, so requests of different classes never
+//     share a call, and a request only joins when its own deadline is no
+//     later than the first requester's; otherwise the shared call could time
+//     out before the joiner's deadline, so the joiner makes its own call.
+type streamCoalescer struct {
+	mu       sync.Mutex
+	inFlight map[string]*sharedStream
+}
+
+// sharedStream is an upstream stream fanned out to several subscribers
+type sharedStream struct {
+	mu          sync.Mutex
+	ready       chan struct{} // Closed once the upstream status is known
+	changed     chan struct{} // Closed and replaced whenever chunks are appended or the stream ends
+	statusCode  int
+	header      http.Header
+	errorBody   []byte
+	err         error
+	chunks      []StreamChunk
+	done        bool
+	subscribers int
+	cancel      context.CancelFunc
+	deadline    time.Time // The first requester's deadline, zero if none
+}
+
+func newStreamCoalescer() *streamCoalescer {
+	return &streamCoalescer{inFlight: make(map[string]*sharedStream)}
+}
+
+// isDeterministic reports whether a payload always asks for the same single output
+func isDeterministic(payload map[string]interface{}) bool {
+	request, _ := payload["request"].(map[string]interface{})
+	genConfig, _ := request["generationConfig"].(map[string]interface{})
+	if genConfig == nil {
+		return false
+	}
+
+	switch temperature := genConfig["temperature"].(type) {
+	case float64:
+		if temperature != 0 {
+			return false
+		}
+	case int:
+		if temperature != 0 {
+			return false
+		}
+	default:
+		return false
+	}
+
+	switch candidates := genConfig["candidateCount"].(type) {
+	case nil:
+	case float64:
+		return candidates == 1
+	case int:
+		return candidates == 1
+	default:
+		return false
+	}
+	return true
+}
+
+// payloadKey hashes a payload and its priority; encoding/json sorts map keys,
+// so equal payloads hash equally
+func payloadKey(payload map[string]interface{}, priority Priority) (string, bool) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%d:%s", priority, hex.EncodeToString(sum[:])), true
+}
+
+// SendStreamRequest opens a streaming request and returns the upstream response
+// and its parsed chunks. On a non-200 status the chunk channel is nil and the
+// response body holds the error. With STREAM_COALESCING enabled, identical
+// deterministic requests share a single upstream call.
+func (c *Client) SendStreamRequest(ctx context.Context, payload map[string]interface{}) (*http.Response, <-chan StreamChunk, error) {
+	var shared *sharedStream
+	var leader bool
+	if key, ok := payloadKey(payload, priorityFromContext(ctx)); c.coalescer != nil && ok && isDeterministic(payload) {
+		deadline, _ := ctx.Deadline()
+		shared, leader = c.coalescer.join(key, deadline)
+		if leader {
+			go c.runSharedStream(ctx, key, shared, payload)
+		}
+	}
+	if shared == nil {
+		resp, err := c.SendGeminiRequest(ctx, payload, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return resp, nil, nil
+		}
+		return resp, c.StreamResponse(ctx, resp), nil
+	}
+	if !leader {
+		log.Printf("Coalescing streaming request onto in-flight upstream call")
+	}
+
+	select {
+	case <-shared.ready:
+	case <-ctx.Done():
+		c.coalescer.leave(shared)
+		return nil, nil, ctx.Err()
+	}
+
+	if shared.err != nil {
+		c.coalescer.leave(shared)
+		return nil, nil, shared.err
+	}
+
+	resp := &http.Response{
+		StatusCode: shared.statusCode,
+		Header:     shared.header.Clone(),
+		Body:       io.NopCloser(bytes.NewReader(shared.errorBody)),
+	}
+	if shared.statusCode != http.StatusOK {
+		c.coalescer.leave(shared)
+		return resp, nil, nil
+	}
+	return resp, c.coalescer.subscribe(ctx, shared), nil
+}
+
+// join returns the in-flight stream for key, creating it if needed. The caller
+// is the leader, responsible for running the upstream call, if it created it.
+// It returns nil when the in-flight stream ends before deadline, a zero
+// deadline meaning none, so the caller cannot share it.
+func (sc *streamCoalescer) join(key string, deadline time.Time) (*sharedStream, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if shared, ok := sc.inFlight[key]; ok {
+		shared.mu.Lock()
+		// A stream every subscriber abandoned has been cancelled; start afresh
+		abandoned := shared.subscribers == 0
+		outlived := !shared.deadline.IsZero() && (deadline.IsZero() || deadline.After(shared.deadline))
+		if !abandoned && !outlived {
+			shared.subscribers++
+		}
+		shared.mu.Unlock()
+		switch {
+		case abandoned:
+		case outlived:
+			return nil, false
+		default:
+			return shared, false
+		}
+	}
+
+	shared := &sharedStream{
+		ready:       make(chan struct{}),
+		changed:     make(chan struct{}),
+		subscribers: 1,
+		deadline:    deadline,
+	}
+	sc.inFlight[key] = shared
+	return shared, true
+}
+
+// leave drops a subscriber, cancelling the upstream call when none remain
+func (sc *streamCoalescer) leave(shared *sharedStream) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	shared.subscribers--
+	if shared.subscribers == 0 && shared.cancel != nil {
+		shared.cancel()
+	}
+}
+
+// subscribe replays the shared stream from the start into a new channel
+func (sc *streamCoalescer) subscribe(ctx context.Context, shared *sharedStream) <-chan StreamChunk {
+	ch := make(chan StreamChunk)
+
+	go func() {
+		defer close(ch)
+		defer sc.leave(shared)
+
+		for next := 0; ; {
+			shared.mu.Lock()
+			if next < len(shared.chunks) {
+				chunk := shared.chunks[next]
+				shared.mu.Unlock()
+				next++
+
+				select {
+				case ch <- chunk:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			if shared.done {
+				shared.mu.Unlock()
+				return
+			}
+			changed := shared.changed
+			shared.mu.Unlock()
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// runSharedStream performs the upstream call for a shared stream and buffers its chunks
+func (c *Client) runSharedStream(leaderCtx context.Context, key string, shared *sharedStream, payload map[string]interface{}) {
+	// Not cancelled with the leader, only once every subscriber has left, but
+	// keeping the leader's values (priority, timing) and request deadline
+	ctx, cancel := context.WithCancel(context.WithoutCancel(leaderCtx))
+	if deadline, ok := leaderCtx.Deadline(); ok {
+		cancel()
+		ctx, cancel = context.WithDeadline(context.WithoutCancel(leaderCtx), deadline)
+	}
+	shared.mu.Lock()
+	shared.cancel = cancel
+	if shared.subscribers == 0 {
+		cancel()
+	}
+	shared.mu.Unlock()
+
+	defer func() {
+		cancel()
+		// Stop new requests from joining a finished stream
+		c.coalescer.mu.Lock()
+		if c.coalescer.inFlight[key] == shared {
+			delete(c.coalescer.inFlight, key)
+		}
+		c.coalescer.mu.Unlock()
+
+		shared.mu.Lock()
+		shared.done = true
+		close(shared.changed)
+		shared.mu.Unlock()
+	}()
+
+	resp, err := c.SendGeminiRequest(ctx, payload, true)
+	if err != nil {
+		shared.err = err
+		close(shared.ready)
+		return
+	}
+	shared.statusCode = resp.StatusCode
+	shared.header = resp.Header
+	if resp.StatusCode != http.StatusOK {
+		shared.errorBody, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		close(shared.ready)
+		return
+	}
+	close(shared.ready)
+
+	for chunk := range c.StreamResponse(ctx, resp) {
+		shared.mu.Lock()
+		shared.chunks = append(shared.chunks, chunk)
+		close(shared.changed)
+		shared.changed = make(chan struct{})
+		shared.mu.Unlock()
+	}
+}
@@ -0,0 +1,252 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// deterministicPayload is a streaming payload eligible for coalescing
+func deterministicPayload() map[string]interface{} {
+	return map[string]interface{}{
+		"model": "gemini-2.5-flash",
+		"request": map[string]interface{}{
+			"contents":         []interface{}{map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": "Hi"}}}},
+			"generationConfig": map[string]interface{}{"temperature": 0},
+		},
+	}
+}
+
+// newCoalescingClient returns a test client with stream coalescing enabled
+func newCoalescingClient(t *testing.T, handler http.HandlerFunc) *Client {
+	t.Helper()
+	c := newTestClient(t, handler)
+	c.coalescer = newStreamCoalescer()
+	return c
+}
+
+// writeChunk writes one SSE chunk with the given text and flushes it
+func writeChunk(w http.ResponseWriter, text string) {
+	fmt.Fprintf(w, "data: {\"response\": {\"candidates\": [{\"content\": {\"parts\": [{\"text\": %q}]}}]}}\n\n", text)
+	w.(http.Flusher).Flush()
+}
+
+// waitSubscribers waits until the in-flight streams have n subscribers in total
+func waitSubscribers(t *testing.T, sc *streamCoalescer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		subscribers := 0
+		sc.mu.Lock()
+		for _, shared := range sc.inFlight {
+			shared.mu.Lock()
+			subscribers += shared.subscribers
+			shared.mu.Unlock()
+		}
+		sc.mu.Unlock()
+		if subscribers == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers, want %d", subscribers, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// collectText drains a chunk channel into the chunks' texts
+func collectText(t *testing.T, chunks <-chan StreamChunk) []string {
+	t.Helper()
+	var texts []string
+	for chunk := range chunks {
+		if chunk.Err != nil {
+			t.Errorf("chunk error: %v", chunk.Err)
+			continue
+		}
+		texts = append(texts, chunkText(chunk))
+	}
+	return texts
+}
+
+func TestCoalescedStreamLateJoinerReplays(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := newCoalescingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		writeChunk(w, "one")
+		<-release
+		writeChunk(w, "two")
+	})
+
+	_, first, err := c.SendStreamRequest(context.Background(), deterministicPayload())
+	if err != nil || first == nil {
+		t.Fatalf("first SendStreamRequest() = %v, %v", first, err)
+	}
+	if chunk := <-first; chunkText(chunk) != "one" {
+		t.Fatalf("first chunk = %+v, want one", chunk)
+	}
+
+	_, late, err := c.SendStreamRequest(context.Background(), deterministicPayload())
+	if err != nil || late == nil {
+		t.Fatalf("late SendStreamRequest() = %v, %v", late, err)
+	}
+	close(release)
+
+	if got := collectText(t, late); fmt.Sprint(got) != "[one two]" {
+		t.Errorf("late joiner got %q, want the whole stream", got)
+	}
+	if got := collectText(t, first); fmt.Sprint(got) != "[two]" {
+		t.Errorf("first requester got %q after its first chunk, want [two]", got)
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestCoalescedStreamSharesUpstreamError(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	c := newCoalescingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"message": "quota"}}`)
+	})
+
+	var wg sync.WaitGroup
+	results := make([]string, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, chunks, err := c.SendStreamRequest(context.Background(), deterministicPayload())
+			if err != nil || chunks != nil {
+				results[i] = fmt.Sprintf("error %v, chunks %v", err, chunks)
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			results[i] = fmt.Sprintf("%d %s", resp.StatusCode, body)
+		}(i)
+	}
+	waitSubscribers(t, c.coalescer, 2)
+	close(release)
+	wg.Wait()
+
+	if !strings.HasPrefix(results[0], "429 ") || !strings.Contains(results[0], "quota") {
+		t.Errorf("first waiter got %q, want the upstream 429", results[0])
+	}
+	if results[1] != results[0] {
+		t.Errorf("second waiter got %q, want %q", results[1], results[0])
+	}
+	if got := calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestCoalescedStreamCancelledAfterLastSubscriber(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	c := newCoalescingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		writeChunk(w, "one")
+		<-r.Context().Done()
+		close(upstreamCancelled)
+	})
+
+	var cancels []context.CancelFunc
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		cancels = append(cancels, cancel)
+		_, chunks, err := c.SendStreamRequest(ctx, deterministicPayload())
+		if err != nil || chunks == nil {
+			t.Fatalf("SendStreamRequest() = %v, %v", chunks, err)
+		}
+		if chunk := <-chunks; chunkText(chunk) != "one" {
+			t.Fatalf("first chunk = %+v, want one", chunk)
+		}
+	}
+
+	cancels[0]()
+	waitSubscribers(t, c.coalescer, 1)
+	select {
+	case <-upstreamCancelled:
+		t.Fatal("upstream call cancelled while a subscriber remains")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancels[1]()
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(time.Second):
+		t.Fatal("upstream call not cancelled after the last subscriber left")
+	}
+}
+
+func TestStreamCoalescerJoin(t *testing.T) {
+	now := time.Now()
+
+	t.Run("abandoned stream restarts", func(t *testing.T) {
+		sc := newStreamCoalescer()
+		first, leader := sc.join("key", time.Time{})
+		if !leader {
+			t.Fatal("first join is not the leader")
+		}
+		sc.leave(first)
+
+		second, leader := sc.join("key", time.Time{})
+		if !leader || second == first {
+			t.Errorf("join after every subscriber left = (%p, %v), want a new stream led by the caller", second, leader)
+		}
+	})
+
+	tests := []struct {
+		name           string
+		leaderDeadline time.Time
+		joinerDeadline time.Time
+		wantJoin       bool
+	}{
+		{"no deadlines", time.Time{}, time.Time{}, true},
+		{"leader without deadline", time.Time{}, now.Add(time.Minute), true},
+		{"earlier deadline", now.Add(time.Minute), now.Add(time.Second), true},
+		{"same deadline", now.Add(time.Minute), now.Add(time.Minute), true},
+		{"later deadline", now.Add(time.Second), now.Add(time.Minute), false},
+		{"joiner without deadline", now.Add(time.Minute), time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := newStreamCoalescer()
+			first, _ := sc.join("key", tt.leaderDeadline)
+
+			shared, leader := sc.join("key", tt.joinerDeadline)
+
+			if leader {
+				t.Fatal("joiner became the leader of a live stream")
+			}
+			if joined := shared == first; joined != tt.wantJoin {
+				t.Errorf("joined = %v, want %v", joined, tt.wantJoin)
+			}
+			if tt.wantJoin == (shared == nil) {
+				t.Errorf("join() = %p, want nil only when not joining", shared)
+			}
+		})
+	}
+}
+
+func TestPayloadKeyIncludesPriority(t *testing.T) {
+	high, _ := payloadKey(deterministicPayload(), PriorityHigh)
+	low, _ := payloadKey(deterministicPayload(), PriorityLow)
+	again, _ := payloadKey(deterministicPayload(), PriorityHigh)
+
+	if high == low {
+		t.Error("payloads of different priorities share a key")
+	}
+	if high != again {
+		t.Error("equal payloads of the same priority have different keys")
+	}
+}
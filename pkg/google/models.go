@@ -0,0 +1,113 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"geminicli2api/pkg/config"
+)
+
+// modelListPage is one page of Google's model list
+type modelListPage struct {
+	Models        []config.Model `json:"models"`
+	NextPageToken string         `json:"nextPageToken"`
+}
+
+// FetchModels lists the Gemini models Google currently serves for
+// generateContent. Non-Gemini models, such as embeddings, are left out.
+func (c *Client) FetchModels(ctx context.Context) ([]config.Model, error) {
+	token, projectID, err := c.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var models []config.Model
+	pageToken := ""
+	for {
+		query := url.Values{"pageSize": {"1000"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", config.ModelListEndpoint+"?"+query.Encode(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		c.authConfig.SetRequestHeaders(req, token.AccessToken, projectID)
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, upstreamRequestError(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read model list: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("model list returned status %d: %s", resp.StatusCode, body)
+		}
+
+		var page modelListPage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse model list: %w", err)
+		}
+		for _, model := range page.Models {
+			if strings.HasPrefix(model.Name, "models/gemini-") && supportsGenerateContent(model) {
+				models = append(models, model)
+			}
+		}
+
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+
+	if len(models) == 0 {
+		return nil, fmt.Errorf("model list contained no Gemini models")
+	}
+	return models, nil
+}
+
+// RefreshModels replaces the built-in model list with the one Google reports,
+// now and then every interval, until ctx is done. A failed fetch is logged
+// and the current list is kept, so the built-in list stays in use until the
+// first successful fetch.
+func (c *Client) RefreshModels(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, c.config.RequestTimeout)
+		models, err := c.FetchModels(fetchCtx)
+		cancel()
+		if err != nil {
+			log.Printf("Model list refresh failed, keeping the current list: %v", err)
+		} else {
+			c.config.SetBaseModels(models)
+			log.Printf("Model list refreshed: %d models", len(models))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func supportsGenerateContent(model config.Model) bool {
+	for _, method := range model.SupportedGenerationMethods {
+		if method == "generateContent" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+)
+
+// explainModelNotFound replaces a 404 from a generate request, which Google
+// returns when it no longer serves a model the proxy still lists (such as a
+// deprecated preview), with an error that names the model, suggests the
+// closest available one and lists the rest
+func (c *Client) explainModelNotFound(model string, resp *http.Response) *http.Response {
+	if resp.StatusCode != http.StatusNotFound || model == "" {
+		return resp
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	var upstream apierrors.Response
+	json.Unmarshal(body, &upstream)
+	message := modelNotFoundMessage(model, upstream.Error.Message, c.config.AvailableModels())
+	log.Printf("Google returned 404 for model %s", model)
+
+	errorBody, _ := json.Marshal(apierrors.New(http.StatusNotFound, message))
+	notFound := createRawResponse(http.StatusNotFound, errorBody, "application/json")
+	for key, values := range resp.Header {
+		if key != "Content-Type" && key != "Content-Length" {
+			notFound.Header[key] = values
+		}
+	}
+	return notFound
+}
+
+// modelNotFoundMessage builds the error message for a model Google doesn't
+// serve: the closest other available model by edit distance, then all of them
+func modelNotFoundMessage(model string, upstreamMessage string, available []config.Model) string {
+	model = config.GetRootModelName(strings.TrimPrefix(model, "models/"))
+
+	var names []string
+	seen := map[string]bool{model: true}
+	for _, m := range available {
+		name := config.GetRootModelName(strings.TrimPrefix(m.Name, "models/"))
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	message := fmt.Sprintf("Model %s is not available from Google; it may have been deprecated or renamed", model)
+	if upstreamMessage != "" {
+		message += fmt.Sprintf(" (Google: %s)", upstreamMessage)
+	}
+	if len(names) == 0 {
+		return message
+	}
+
+	closest, best := "", -1
+	for _, name := range names {
+		if distance := editDistance(model, name); best < 0 || distance < best {
+			closest, best = name, distance
+		}
+	}
+	return fmt.Sprintf("%s. Did you mean %s? Available models: %s", message, closest, strings.Join(names, ", "))
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
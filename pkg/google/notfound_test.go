@@ -0,0 +1,61 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	apierrors "geminicli2api/pkg/errors"
+)
+
+func TestSendGeminiRequestExplainsModelNotFound(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error": {"code": 404, "message": "Requested entity was not found."}}`)
+	})
+	payload := c.BuildGeminiPayloadFromNative(map[string]interface{}{
+		"contents": []interface{}{map[string]interface{}{"role": "user", "parts": []interface{}{map[string]interface{}{"text": "Hi"}}}},
+	}, "gemini-2.5-flsh")
+
+	resp, err := c.SendGeminiRequest(context.Background(), payload, false)
+	if err != nil {
+		t.Fatalf("SendGeminiRequest() error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", resp.StatusCode)
+	}
+	var errorResponse apierrors.Response
+	if err := json.NewDecoder(resp.Body).Decode(&errorResponse); err != nil {
+		t.Fatalf("body is not a JSON error: %v", err)
+	}
+	message := errorResponse.Error.Message
+	for _, want := range []string{"Model gemini-2.5-flsh is not available", "Google: Requested entity was not found.", "Did you mean gemini-2.5-flash?", "Available models: "} {
+		if !strings.Contains(message, want) {
+			t.Errorf("message = %q, want it to contain %q", message, want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "abc", 3},
+		{"gemini-2.5-flash", "gemini-2.5-flash", 0},
+		{"gemini-2.5-flsh", "gemini-2.5-flash", 1},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
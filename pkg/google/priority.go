@@ -0,0 +1,143 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Priority is a request's scheduling class for upstream slots
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+type priorityKey struct{}
+
+// ParsePriority parses an X-Priority value: high, normal or low
+func ParsePriority(value string) (Priority, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "high":
+		return PriorityHigh, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	}
+	return PriorityNormal, fmt.Errorf("priority must be high, normal or low, got %q", value)
+}
+
+// WithPriority returns a context whose upstream requests are scheduled with p
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFromContext returns the context's priority, normal by default
+func priorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+	return PriorityNormal
+}
+
+// slotPool limits concurrent upstream calls with priority scheduling. The
+// reserved slots are only granted to high priority requests, and when a slot
+// frees it goes to the longest-waiting request of the highest waiting class,
+// so low priority requests only proceed when nothing else is queued.
+type slotPool struct {
+	mu       sync.Mutex
+	capacity int
+	reserved int
+	inUse    int
+	waiters  [PriorityHigh + 1][]chan struct{}
+}
+
+func newSlotPool(capacity, reserved int) *slotPool {
+	return &slotPool{capacity: capacity, reserved: reserved}
+}
+
+// limit is how many slots may be in use for a request of class p to get one
+func (s *slotPool) limit(p Priority) int {
+	if p == PriorityHigh {
+		return s.capacity
+	}
+	return s.capacity - s.reserved
+}
+
+// Acquire waits for a slot until ctx is done
+func (s *slotPool) Acquire(ctx context.Context, p Priority) error {
+	s.mu.Lock()
+	if s.inUse < s.limit(p) && !s.queuedAtOrAbove(p) {
+		s.inUse++
+		s.mu.Unlock()
+		return nil
+	}
+	ready := make(chan struct{})
+	s.waiters[p] = append(s.waiters[p], ready)
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		select {
+		case <-ready:
+			// Granted while giving up; hand the slot on
+			s.inUse--
+			s.dispatch()
+		default:
+			// Requests queued behind this one may now be eligible
+			s.remove(p, ready)
+			s.dispatch()
+		}
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot and grants it to the next waiter
+func (s *slotPool) Release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inUse--
+	s.dispatch()
+}
+
+// queuedAtOrAbove reports whether requests of class p or higher are waiting
+func (s *slotPool) queuedAtOrAbove(p Priority) bool {
+	for class := p; class <= PriorityHigh; class++ {
+		if len(s.waiters[class]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch grants free slots to waiters, highest class first. A class that
+// can't be served blocks the classes below it.
+func (s *slotPool) dispatch() {
+	for class := PriorityHigh; class >= PriorityLow; class-- {
+		for len(s.waiters[class]) > 0 {
+			if s.inUse >= s.limit(class) {
+				return
+			}
+			s.inUse++
+			close(s.waiters[class][0])
+			s.waiters[class] = s.waiters[class][1:]
+		}
+	}
+}
+
+func (s *slotPool) remove(p Priority, ready chan struct{}) {
+	for i, waiter := range s.waiters[p] {
+		if waiter == ready {
+			s.waiters[p] = append(s.waiters[p][:i], s.waiters[p][i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package google
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// acquireAsync acquires a slot in the background and reports the result
+func acquireAsync(ctx context.Context, pool *slotPool, p Priority) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- pool.Acquire(ctx, p) }()
+	return done
+}
+
+// waitQueued waits until n requests of class p are queued
+func waitQueued(t *testing.T, pool *slotPool, p Priority, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		pool.mu.Lock()
+		queued := len(pool.waiters[p])
+		pool.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests of priority %d queued, want %d", queued, p, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlotPoolHighPriorityProceedsWhileLowQueues(t *testing.T) {
+	pool := newSlotPool(2, 1)
+	ctx := context.Background()
+
+	if err := pool.Acquire(ctx, PriorityLow); err != nil {
+		t.Fatalf("first low Acquire() error = %v", err)
+	}
+	low := acquireAsync(ctx, pool, PriorityLow)
+	waitQueued(t, pool, PriorityLow, 1)
+
+	if err := pool.Acquire(ctx, PriorityHigh); err != nil {
+		t.Fatalf("high Acquire() error = %v", err)
+	}
+
+	// Freeing the high priority slot leaves only the reserved one free
+	pool.Release()
+	select {
+	case <-low:
+		t.Fatal("low priority request got the reserved slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	pool.Release()
+	if err := <-low; err != nil {
+		t.Errorf("queued low Acquire() error = %v", err)
+	}
+}
+
+func TestSlotPoolGrantsHighestClassFirst(t *testing.T) {
+	pool := newSlotPool(1, 0)
+	ctx := context.Background()
+	if err := pool.Acquire(ctx, PriorityNormal); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	low := acquireAsync(ctx, pool, PriorityLow)
+	waitQueued(t, pool, PriorityLow, 1)
+	high := acquireAsync(ctx, pool, PriorityHigh)
+	waitQueued(t, pool, PriorityHigh, 1)
+
+	pool.Release()
+	if err := <-high; err != nil {
+		t.Fatalf("high Acquire() error = %v", err)
+	}
+	select {
+	case <-low:
+		t.Fatal("low priority request was granted before the high priority one released")
+	default:
+	}
+	pool.Release()
+	if err := <-low; err != nil {
+		t.Errorf("low Acquire() error = %v", err)
+	}
+}
+
+func TestSlotPoolGrantedWhileCancelling(t *testing.T) {
+	pool := newSlotPool(1, 0)
+	if err := pool.Acquire(context.Background(), PriorityNormal); err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		waiter := acquireAsync(ctx, pool, PriorityNormal)
+		waitQueued(t, pool, PriorityNormal, 1)
+
+		// Cancel and hand over the held slot before the waiter can react
+		pool.mu.Lock()
+		cancel()
+		pool.inUse--
+		pool.dispatch()
+		pool.mu.Unlock()
+
+		// Whichever way the waiter resolves, it holds the slot or handed it on
+		err := <-waiter
+		pool.mu.Lock()
+		inUse := pool.inUse
+		pool.mu.Unlock()
+		if err == nil && inUse != 1 || err != nil && inUse != 0 {
+			t.Fatalf("Acquire() error = %v with %d slots in use, want the slot held only on success", err, inUse)
+		}
+		if err != nil {
+			if err := pool.Acquire(context.Background(), PriorityNormal); err != nil {
+				t.Fatalf("Acquire() after cancelling error = %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"geminicli2api/pkg/metrics"
+)
+
+// ErrQuotaHold is returned when a request's deadline would pass before a
+// model's quota hold ends, so waiting for it is pointless
+var ErrQuotaHold = errors.New("model is rate limited by Google")
+
+// quotaHolds holds requests for a model after Google answers 429 with a retry
+// delay, until the delay passes. This keeps a burst of requests from hitting
+// the exhausted quota again and extending the rate limit.
+type quotaHolds struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+	max   time.Duration
+}
+
+func newQuotaHolds(max time.Duration) *quotaHolds {
+	return &quotaHolds{until: make(map[string]time.Time), max: max}
+}
+
+// Wait blocks until any hold on model has passed. It fails immediately with
+// ErrQuotaHold if ctx's deadline falls before the hold ends.
+func (q *quotaHolds) Wait(ctx context.Context, model string) error {
+	q.mu.Lock()
+	until := q.until[model]
+	q.mu.Unlock()
+
+	wait := time.Until(until)
+	if wait <= 0 {
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(until) {
+		return fmt.Errorf("%w, retry in %v", ErrQuotaHold, wait.Round(time.Second))
+	}
+
+	metrics.QuotaQueueDepth.Inc()
+	defer metrics.QuotaQueueDepth.Dec()
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Record holds model for delay, capped at QUOTA_HOLD_MAX. A hold is only ever
+// extended, never shortened.
+func (q *quotaHolds) Record(model string, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	if delay > q.max {
+		delay = q.max
+	}
+	until := time.Now().Add(delay)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if until.After(q.until[model]) {
+		q.until[model] = until
+	}
+}
+
+// retryDelay reads how long to wait after a 429 from the Retry-After header,
+// in seconds or as an HTTP date, falling back to the retryDelay of a RetryInfo
+// detail in Google's error body
+func retryDelay(header http.Header, body []byte) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil {
+			return time.Until(date)
+		}
+	}
+
+	var errorBody struct {
+		Error struct {
+			Details []struct {
+				Type       string `json:"@type"`
+				RetryDelay string `json:"retryDelay"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &errorBody); err != nil {
+		return 0
+	}
+	for _, detail := range errorBody.Error.Details {
+		if detail.Type != "type.googleapis.com/google.rpc.RetryInfo" {
+			continue
+		}
+		if delay, err := time.ParseDuration(detail.RetryDelay); err == nil {
+			return delay
+		}
+	}
+	return 0
+}
@@ -0,0 +1,192 @@
+package google
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// parseGenerateResponse extracts the Gemini response from a non-streaming
+// upstream body. The body is normally a single object, optionally wrapped in a
+// {"response": ...} envelope and prefixed with "data: ", but may also be an
+// array of objects or newline-delimited (SSE) objects; those are chunks of one
+// response and are merged. Returns false if nothing could be parsed.
+func parseGenerateResponse(body []byte) (map[string]interface{}, bool) {
+	// Strip SSE framing so only the JSON values remain
+	var cleaned bytes.Buffer
+	for _, line := range strings.Split(string(body), "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if line == "" || line == "[DONE]" {
+			continue
+		}
+		cleaned.WriteString(line)
+		cleaned.WriteByte('\n')
+	}
+
+	var chunks []map[string]interface{}
+	decoder := json.NewDecoder(&cleaned)
+	for {
+		var value interface{}
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, false
+		}
+
+		chunks = append(chunks, unwrapGeminiValue(value)...)
+	}
+
+	switch len(chunks) {
+	case 0:
+		return nil, false
+	case 1:
+		return chunks[0], true
+	}
+	return mergeResponseChunks(chunks), true
+}
+
+// unwrapGeminiResponse removes the Code Assist {"response": ...} envelope from
+// a response or stream chunk. Objects without the envelope, or that already
+// carry candidates, are returned unchanged. The streaming and non-streaming
+// paths both unwrap through here.
+func unwrapGeminiResponse(object map[string]interface{}) map[string]interface{} {
+	if _, ok := object["candidates"]; ok {
+		return object
+	}
+	if response, ok := object["response"].(map[string]interface{}); ok {
+		return response
+	}
+	return object
+}
+
+// unwrapGeminiValue returns the unwrapped responses in a decoded JSON value,
+// which may be a single object or an array of them
+func unwrapGeminiValue(value interface{}) []map[string]interface{} {
+	if object, ok := value.(map[string]interface{}); ok {
+		return []map[string]interface{}{unwrapGeminiResponse(object)}
+	}
+	objects := toMapSlice(value)
+	for i, object := range objects {
+		objects[i] = unwrapGeminiResponse(object)
+	}
+	return objects
+}
+
+// mergeResponseChunks assembles streamed response chunks into one response.
+// Candidate parts are concatenated by candidate index; every other field,
+// such as finishReason and usageMetadata, takes its last value.
+func mergeResponseChunks(chunks []map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	candidates := make(map[int]map[string]interface{})
+	var order []int
+
+	for _, chunk := range chunks {
+		for key, value := range chunk {
+			if key != "candidates" {
+				merged[key] = value
+			}
+		}
+
+		for position, candidate := range toMapSlice(chunk["candidates"]) {
+			index := position
+			if i, ok := candidate["index"].(float64); ok {
+				index = int(i)
+			}
+			target, ok := candidates[index]
+			if !ok {
+				target = map[string]interface{}{"index": index}
+				candidates[index] = target
+				order = append(order, index)
+			}
+			mergeCandidate(target, candidate)
+		}
+	}
+
+	if len(order) > 0 {
+		merged["candidates"] = make([]interface{}, 0, len(order))
+		for _, index := range order {
+			merged["candidates"] = append(merged["candidates"].([]interface{}), candidates[index])
+		}
+	}
+	return merged
+}
+
+// mergeCandidate appends a candidate chunk's parts to target, joining adjacent
+// text parts of the same kind and appending token log probabilities, and
+// copies its other fields over
+func mergeCandidate(target map[string]interface{}, candidate map[string]interface{}) {
+	for key, value := range candidate {
+		switch key {
+		case "content":
+		case "logprobsResult":
+			target[key] = mergeLogprobs(target[key], value)
+		default:
+			target[key] = value
+		}
+	}
+
+	content, ok := candidate["content"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	targetContent, _ := target["content"].(map[string]interface{})
+	if targetContent == nil {
+		targetContent = map[string]interface{}{"parts": []interface{}{}}
+		target["content"] = targetContent
+	}
+	if role, ok := content["role"]; ok {
+		targetContent["role"] = role
+	}
+
+	parts, _ := targetContent["parts"].([]interface{})
+	for _, part := range toMapSlice(content["parts"]) {
+		if last := len(parts) - 1; last >= 0 {
+			if previous, ok := parts[last].(map[string]interface{}); ok && joinableText(previous, part) {
+				previous["text"] = previous["text"].(string) + part["text"].(string)
+				continue
+			}
+		}
+		copied := make(map[string]interface{}, len(part))
+		for key, value := range part {
+			copied[key] = value
+		}
+		parts = append(parts, copied)
+	}
+	targetContent["parts"] = parts
+}
+
+// mergeLogprobs appends a chunk's token log probabilities to those of the
+// chunks before it
+func mergeLogprobs(existing, chunk interface{}) interface{} {
+	merged, ok := existing.(map[string]interface{})
+	if !ok {
+		return chunk
+	}
+	next, _ := chunk.(map[string]interface{})
+	for _, key := range []string{"topCandidates", "chosenCandidates"} {
+		previous, _ := merged[key].([]interface{})
+		added, _ := next[key].([]interface{})
+		merged[key] = append(previous, added...)
+	}
+	return merged
+}
+
+// joinableText reports whether two parts are plain text of the same kind
+// (thought or answer), with no signatures or other fields, that can be joined
+func joinableText(a, b map[string]interface{}) bool {
+	for _, part := range []map[string]interface{}{a, b} {
+		if _, ok := part["text"].(string); !ok {
+			return false
+		}
+		for key := range part {
+			if key != "text" && key != "thought" {
+				return false
+			}
+		}
+	}
+	aThought, _ := a["thought"].(bool)
+	bThought, _ := b["thought"].(bool)
+	return aThought == bThought
+}
@@ -0,0 +1,117 @@
+package google
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGenerateResponseBodyShapes(t *testing.T) {
+	const first = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}}]}`
+	const last = `{"candidates": [{"content": {"role": "model", "parts": [{"text": " world"}]}, "finishReason": "STOP"}], "usageMetadata": {"totalTokenCount": 9}}`
+	const whole = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello world"}]}, "finishReason": "STOP"}], "usageMetadata": {"totalTokenCount": 9}}`
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"single object", whole},
+		{"wrapped object", `{"response": ` + whole + `}`},
+		{"data-prefixed wrapped object", `data: {"response": ` + whole + `}`},
+		{"array of chunks", `[{"response": ` + first + `}, {"response": ` + last + `}]`},
+		{"SSE chunks", "data: {\"response\": " + first + "}\n\ndata: {\"response\": " + last + "}\n\ndata: [DONE]\n"},
+		{"newline-delimited chunks", first + "\n" + last + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, ok := parseGenerateResponse([]byte(tt.body))
+			if !ok {
+				t.Fatal("parseGenerateResponse() failed")
+			}
+
+			candidates, _ := response["candidates"].([]interface{})
+			if len(candidates) != 1 {
+				t.Fatalf("got %d candidates, want 1: %v", len(candidates), response)
+			}
+			candidate := candidates[0].(map[string]interface{})
+			parts := candidate["content"].(map[string]interface{})["parts"]
+			wantParts := []interface{}{map[string]interface{}{"text": "Hello world"}}
+			if !reflect.DeepEqual(parts, wantParts) {
+				t.Errorf("parts = %v, want the text joined into one part", parts)
+			}
+			if candidate["finishReason"] != "STOP" {
+				t.Errorf("finishReason = %v, want STOP", candidate["finishReason"])
+			}
+			usage, _ := response["usageMetadata"].(map[string]interface{})
+			if usage["totalTokenCount"] != float64(9) {
+				t.Errorf("usageMetadata = %v, want the final chunk's", usage)
+			}
+		})
+	}
+}
+
+func TestParseGenerateResponseRejectsInvalidBody(t *testing.T) {
+	for _, body := range []string{"", "data: [DONE]", "<html>Bad Gateway</html>", `{"candidates": [`} {
+		if response, ok := parseGenerateResponse([]byte(body)); ok {
+			t.Errorf("parseGenerateResponse(%q) = %v, want failure", body, response)
+		}
+	}
+}
+
+func TestParseGenerateResponseCandidatesWithoutIndex(t *testing.T) {
+	body := `data: {"response": {"candidates": [{"content": {"parts": [{"text": "A"}]}}, {"content": {"parts": [{"text": "B"}]}}]}}
+
+data: {"response": {"candidates": [{"content": {"parts": [{"text": "a"}]}}, {"content": {"parts": [{"text": "b"}]}}]}}
+`
+
+	response, ok := parseGenerateResponse([]byte(body))
+	if !ok {
+		t.Fatal("parseGenerateResponse() failed")
+	}
+
+	candidates, _ := response["candidates"].([]interface{})
+	if len(candidates) != 2 {
+		t.Fatalf("got %d candidates, want 2: %v", len(candidates), response)
+	}
+	for i, want := range []string{"Aa", "Bb"} {
+		candidate := candidates[i].(map[string]interface{})
+		parts := candidate["content"].(map[string]interface{})["parts"].([]interface{})
+		if text := parts[0].(map[string]interface{})["text"]; candidate["index"] != i || text != want {
+			t.Errorf("candidate %d = index %v %v, want index %d %q", i, candidate["index"], text, i, want)
+		}
+	}
+}
+
+func TestUnwrapStreamingAndNonStreaming(t *testing.T) {
+	const response = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"wrapped", `{"response": ` + response + `}`},
+		{"unwrapped", response},
+		{"wrapped array", `[{"response": ` + response + `}]`},
+		{"unwrapped with a response field", `{"response": {"other": true}, "candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, ok := parseGenerateResponse([]byte(tt.body))
+			if !ok {
+				t.Fatal("parseGenerateResponse() failed")
+			}
+			if got := chunkText(StreamChunk{Data: parsed}); got != "Hi" {
+				t.Errorf("non-streaming text = %q, want Hi: %v", got, parsed)
+			}
+
+			chunks := streamChunks(t, "data: "+tt.body+"\n\n")
+			if len(chunks) != 1 || chunks[0].Err != nil {
+				t.Fatalf("got %d chunks (%+v), want one chunk without error", len(chunks), chunks)
+			}
+			if got := chunkText(chunks[0]); got != "Hi" {
+				t.Errorf("streaming text = %q, want Hi: %v", got, chunks[0].Data)
+			}
+		})
+	}
+}
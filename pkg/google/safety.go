@@ -0,0 +1,112 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+)
+
+// safetyEvaluationCategories are the text harm categories rated for moderation
+var safetyEvaluationCategories = []string{
+	"HARM_CATEGORY_HARASSMENT",
+	"HARM_CATEGORY_HATE_SPEECH",
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT",
+	"HARM_CATEGORY_DANGEROUS_CONTENT",
+	"HARM_CATEGORY_CIVIC_INTEGRITY",
+}
+
+// applySafetyFloor raises every threshold in settings that is looser than
+// MIN_SAFETY_THRESHOLD, or unknown, to the floor. Categories of the default
+// settings that the request leaves out are added at the floor, so omitting
+// one can't bypass it. Settings are returned unchanged without a floor.
+func (c *Client) applySafetyFloor(settings interface{}) interface{} {
+	floor := c.config.MinSafetyThreshold
+	if floor == "" {
+		return settings
+	}
+	floorRank := config.SafetyThresholdRank(floor)
+
+	var entries []map[string]interface{}
+	switch s := settings.(type) {
+	case []map[string]interface{}:
+		entries = s
+	case []interface{}:
+		for _, item := range s {
+			if entry, ok := item.(map[string]interface{}); ok {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	floored := make([]map[string]interface{}, 0, len(entries)+len(c.config.SafetySettings))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		raised := make(map[string]interface{}, len(entry))
+		for k, v := range entry {
+			raised[k] = v
+		}
+		threshold, _ := entry["threshold"].(string)
+		if config.SafetyThresholdRank(threshold) < floorRank {
+			raised["threshold"] = floor
+		}
+		if category, ok := entry["category"].(string); ok {
+			seen[category] = true
+		}
+		floored = append(floored, raised)
+	}
+	for _, entry := range c.config.SafetySettings {
+		if category, ok := entry["category"].(string); ok && !seen[category] {
+			floored = append(floored, map[string]interface{}{"category": category, "threshold": floor})
+		}
+	}
+	return floored
+}
+
+// EvaluateSafety runs text through Gemini's safety evaluation and returns the
+// raw Gemini response. Every category blocks at the lowest threshold so that
+// any rating above negligible is reported, and output is kept to a single
+// token since only the ratings matter.
+func (c *Client) EvaluateSafety(ctx context.Context, model string, text string) (map[string]interface{}, error) {
+	safetySettings := make([]map[string]interface{}, 0, len(safetyEvaluationCategories))
+	for _, category := range safetyEvaluationCategories {
+		safetySettings = append(safetySettings, map[string]interface{}{"category": category, "threshold": "BLOCK_LOW_AND_ABOVE"})
+	}
+
+	payload := map[string]interface{}{
+		"model": model,
+		"request": map[string]interface{}{
+			"contents": []map[string]interface{}{
+				{"role": "user", "parts": []map[string]interface{}{{"text": text}}},
+			},
+			"safetySettings": safetySettings,
+			"generationConfig": map[string]interface{}{
+				"maxOutputTokens": 1,
+				"thinkingConfig":  config.DisabledThinkingConfig(model),
+			},
+		},
+	}
+
+	resp, err := c.SendGeminiRequest(ctx, payload, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errorData apierrors.Response
+		if err := json.NewDecoder(resp.Body).Decode(&errorData); err == nil && errorData.Error.Message != "" {
+			return nil, fmt.Errorf("safety evaluation failed (%d): %s", resp.StatusCode, errorData.Error.Message)
+		}
+		return nil, fmt.Errorf("safety evaluation failed with status %d", resp.StatusCode)
+	}
+
+	var geminiResponse map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse safety evaluation: %w", err)
+	}
+	return geminiResponse, nil
+}
@@ -0,0 +1,44 @@
+package google
+
+import (
+	"reflect"
+	"testing"
+
+	"geminicli2api/pkg/config"
+)
+
+func TestApplySafetyFloor(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.SafetySettings = []map[string]interface{}{
+		{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"},
+		{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"},
+	}
+	cfg.MinSafetyThreshold = "BLOCK_ONLY_HIGH"
+	c := &Client{config: cfg}
+
+	requested := []interface{}{
+		map[string]interface{}{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"},
+		map[string]interface{}{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_LOW_AND_ABOVE"},
+		map[string]interface{}{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "threshold": "UNKNOWN"},
+	}
+
+	got := c.applySafetyFloor(requested)
+
+	want := []map[string]interface{}{
+		{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_ONLY_HIGH"},
+		{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_LOW_AND_ABOVE"},
+		{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "threshold": "BLOCK_ONLY_HIGH"},
+		{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_ONLY_HIGH"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("applySafetyFloor() = %v, want %v", got, want)
+	}
+	if threshold := requested[0].(map[string]interface{})["threshold"]; threshold != "BLOCK_NONE" {
+		t.Errorf("requested settings were modified: threshold = %v", threshold)
+	}
+
+	cfg.MinSafetyThreshold = ""
+	if got := c.applySafetyFloor(requested); !reflect.DeepEqual(got, requested) {
+		t.Errorf("applySafetyFloor() without a floor = %v, want the settings unchanged", got)
+	}
+}
@@ -0,0 +1,94 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/timing"
+)
+
+// CountTokens counts the prompt tokens of a built Gemini payload using the
+// upstream countTokens endpoint. The system instruction is counted as an extra
+// user turn, since countTokens only accepts contents.
+func (c *Client) CountTokens(ctx context.Context, payload map[string]interface{}) (int, error) {
+	token, projectID, err := c.authenticate(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	request, _ := payload["request"].(map[string]interface{})
+	contents := toMapSlice(request["contents"])
+	if systemInstruction, ok := request["systemInstruction"].(map[string]interface{}); ok {
+		systemContent := map[string]interface{}{"role": "user", "parts": systemInstruction["parts"]}
+		contents = append([]map[string]interface{}{systemContent}, contents...)
+	}
+
+	model, _ := payload["model"].(string)
+	data, err := json.Marshal(map[string]interface{}{
+		"request": map[string]interface{}{
+			"model":    "models/" + model,
+			"contents": contents,
+		},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.config.CodeAssistEndpoint+"/v1internal:countTokens", bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	c.authConfig.SetRequestHeaders(req, token.AccessToken, projectID)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("countTokens returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result struct {
+		TotalTokens int `json:"totalTokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, fmt.Errorf("failed to parse countTokens response: %w", err)
+	}
+	return result.TotalTokens, nil
+}
+
+// CheckInputTokenLimit rejects payloads whose prompt exceeds the model's input
+// token limit, when ENFORCE_INPUT_LIMIT is enabled. Counting is best effort: if
+// the count fails, the request is let through for Google to judge.
+func (c *Client) CheckInputTokenLimit(ctx context.Context, modelName string, payload map[string]interface{}) error {
+	if !c.config.EnforceInputLimit {
+		return nil
+	}
+	model := c.config.GetModel(config.GetBaseModelName(modelName))
+	if model == nil || model.InputTokenLimit <= 0 {
+		return nil
+	}
+
+	countStart := time.Now()
+	tokens, err := c.CountTokens(ctx, payload)
+	timing.FromContext(ctx).Track("count", countStart)
+	if err != nil {
+		log.Printf("Skipping input limit check for %s: %v", modelName, err)
+		return nil
+	}
+
+	if tokens > model.InputTokenLimit {
+		return fmt.Errorf("prompt is %d tokens, which exceeds the %d token input limit of model %s", tokens, model.InputTokenLimit, modelName)
+	}
+	return nil
+}
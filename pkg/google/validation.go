@@ -0,0 +1,144 @@
+package google
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+
+	"geminicli2api/pkg/config"
+)
+
+// ValidateNativeRequest performs lightweight validation of a native Gemini request
+// before it is forwarded upstream. It currently checks inlineData parts for a
+// mimeType and well-formed base64 data.
+func ValidateNativeRequest(request map[string]interface{}) error {
+	if contents, ok := request["contents"].([]interface{}); ok {
+		for i, content := range contents {
+			contentMap, ok := content.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := validateParts(contentMap["parts"], fmt.Sprintf("contents[%d]", i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if systemInstruction, ok := request["systemInstruction"].(map[string]interface{}); ok {
+		if err := validateParts(systemInstruction["parts"], "systemInstruction"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateParts validates the inlineData entries of a parts array
+func validateParts(parts interface{}, path string) error {
+	partsSlice, ok := parts.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	for i, part := range partsSlice {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		inlineData, ok := partMap["inlineData"]
+		if !ok {
+			continue
+		}
+
+		fieldPath := fmt.Sprintf("%s.parts[%d].inlineData", path, i)
+		inlineDataMap, ok := inlineData.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be an object", fieldPath)
+		}
+
+		if mimeType, _ := inlineDataMap["mimeType"].(string); strings.TrimSpace(mimeType) == "" {
+			return fmt.Errorf("%s.mimeType is required", fieldPath)
+		}
+
+		data, ok := inlineDataMap["data"].(string)
+		if !ok || data == "" {
+			return fmt.Errorf("%s.data is required", fieldPath)
+		}
+		if !isValidBase64(data) {
+			return fmt.Errorf("%s.data is not valid base64", fieldPath)
+		}
+	}
+
+	return nil
+}
+
+// isValidBase64 checks that data is valid standard base64 by streaming it through
+// a decoder into io.Discard, so large payloads are never buffered in decoded form.
+func isValidBase64(data string) bool {
+	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(data))
+	_, err := io.Copy(io.Discard, decoder)
+	return err == nil
+}
+
+// CheckModelCapabilities verifies that a model supports the requested generation
+// method and any image or audio input in the request contents. Unknown models are
+// not checked here.
+func CheckModelCapabilities(cfg *config.Config, modelName string, method string, request map[string]interface{}) error {
+	model := cfg.GetModel(modelName)
+	if model == nil {
+		return nil
+	}
+
+	if !model.SupportsMethod(method) {
+		return fmt.Errorf("model %s does not support %s (supported: %s)", modelName, method, strings.Join(model.SupportedGenerationMethods, ", "))
+	}
+
+	hasImage, hasAudio := inputModalities(request["contents"])
+	if hasImage && !model.SupportsVision {
+		return fmt.Errorf("model %s does not support image input", modelName)
+	}
+	if hasAudio && !model.SupportsAudio {
+		return fmt.Errorf("model %s does not support audio input", modelName)
+	}
+
+	return nil
+}
+
+// inputModalities reports whether contents include image or audio inlineData parts
+func inputModalities(contents interface{}) (hasImage bool, hasAudio bool) {
+	for _, content := range toMapSlice(contents) {
+		for _, part := range toMapSlice(content["parts"]) {
+			inlineData, ok := part["inlineData"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			mimeType, _ := inlineData["mimeType"].(string)
+			switch {
+			case strings.HasPrefix(mimeType, "image/"):
+				hasImage = true
+			case strings.HasPrefix(mimeType, "audio/"):
+				hasAudio = true
+			}
+		}
+	}
+	return hasImage, hasAudio
+}
+
+// toMapSlice normalizes []interface{} and []map[string]interface{} into the latter
+func toMapSlice(value interface{}) []map[string]interface{} {
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		return v
+	case []interface{}:
+		result := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			if m, ok := item.(map[string]interface{}); ok {
+				result = append(result, m)
+			}
+		}
+		return result
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Warmup sends a tiny generate request to model, priming the upstream
+// connection pool and checking that authentication, onboarding and generation
+// work end to end
+func (c *Client) Warmup(ctx context.Context, model string) error {
+	nativeRequest := map[string]interface{}{
+		"contents": []interface{}{
+			map[string]interface{}{
+				"role":  "user",
+				"parts": []interface{}{map[string]interface{}{"text": "Hi"}},
+			},
+		},
+		"generationConfig": map[string]interface{}{"maxOutputTokens": 16},
+	}
+
+	resp, err := c.SendGeminiRequest(ctx, c.BuildGeminiPayloadFromNative(nativeRequest, model), false)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWarmup(t *testing.T) {
+	var payload struct {
+		Model   string `json:"model"`
+		Request struct {
+			GenerationConfig map[string]interface{} `json:"generationConfig"`
+		} `json:"request"`
+	}
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"response": {"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}}]}}`)
+	})
+
+	if err := c.Warmup(context.Background(), "gemini-2.5-flash"); err != nil {
+		t.Fatalf("Warmup() error = %v", err)
+	}
+	if payload.Model != "gemini-2.5-flash" {
+		t.Errorf("model = %q, want gemini-2.5-flash", payload.Model)
+	}
+	if got := payload.Request.GenerationConfig["maxOutputTokens"]; got != float64(16) {
+		t.Errorf("maxOutputTokens = %v, want a tiny request of 16", got)
+	}
+}
+
+func TestWarmupReportsUpstreamError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error": {"code": 403, "message": "Permission denied"}}`)
+	})
+
+	err := c.Warmup(context.Background(), "gemini-2.5-flash")
+	if err == nil || !strings.Contains(err.Error(), "status 403") {
+		t.Errorf("Warmup() error = %v, want the upstream status", err)
+	}
+}
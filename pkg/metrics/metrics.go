@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	registry    = make(map[string]func() interface{})
+	registryMux sync.RWMutex
+)
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value int64
+}
+
+// NewGauge creates and registers a new gauge
+func NewGauge(name string) *Gauge {
+	g := &Gauge{}
+	register(name, func() interface{} { return g.Value() })
+	return g
+}
+
+// Inc increments the gauge by one
+func (g *Gauge) Inc() {
+	atomic.AddInt64(&g.value, 1)
+}
+
+// Dec decrements the gauge by one
+func (g *Gauge) Dec() {
+	atomic.AddInt64(&g.value, -1)
+}
+
+// Value returns the current gauge value
+func (g *Gauge) Value() int64 {
+	return atomic.LoadInt64(&g.value)
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// NewHistogram creates and registers a histogram with the given upper bucket bounds
+func NewHistogram(name string, bounds []float64) *Histogram {
+	h := &Histogram{bounds: bounds, buckets: make([]int64, len(bounds))}
+	register(name, func() interface{} { return h.Value() })
+	return h
+}
+
+// Observe records a value
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.sum += value
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+}
+
+// Value returns the count, sum and cumulative bucket counts, keyed "le_<bound>"
+func (h *Histogram) Value() map[string]interface{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buckets := make(map[string]int64, len(h.bounds)+1)
+	for i, bound := range h.bounds {
+		buckets[fmt.Sprintf("le_%g", bound)] = h.buckets[i]
+	}
+	buckets["le_inf"] = h.count
+	return map[string]interface{}{
+		"count":   h.count,
+		"sum":     h.sum,
+		"buckets": buckets,
+	}
+}
+
+// latencyBucketsMs are histogram bounds for latencies in milliseconds
+var latencyBucketsMs = []float64{50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+// Metrics exposed by the proxy
+var (
+	UpstreamInFlight = NewGauge("upstream_in_flight")
+	QuotaQueueDepth  = NewGauge("quota_queue_depth") // Requests waiting out a 429 retry delay
+
+	// Streaming latency, in milliseconds
+	StreamTimeToFirstToken = NewHistogram("stream_time_to_first_token_ms", latencyBucketsMs)
+	StreamThinkingTime     = NewHistogram("stream_thinking_ms", latencyBucketsMs)
+	StreamInterChunkTime   = NewHistogram("stream_inter_chunk_ms", latencyBucketsMs)
+)
+
+// register adds a named metric to the registry
+func register(name string, fn func() interface{}) {
+	registryMux.Lock()
+	defer registryMux.Unlock()
+	registry[name] = fn
+}
+
+// Snapshot returns the current value of every registered metric
+func Snapshot() map[string]interface{} {
+	registryMux.RLock()
+	defer registryMux.RUnlock()
+
+	snapshot := make(map[string]interface{}, len(registry))
+	for name, fn := range registry {
+		snapshot[name] = fn()
+	}
+	return snapshot
+}
+
+// Handler serves the metrics snapshot as JSON
+func Handler(c *gin.Context) {
+	c.JSON(http.StatusOK, Snapshot())
+}
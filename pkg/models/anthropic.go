@@ -0,0 +1,66 @@
+package models
+
+// Anthropic Models
+
+// AnthropicMessagesRequest represents an Anthropic Messages API request
+type AnthropicMessagesRequest struct {
+	Model         string                 `json:"model"`
+	Messages      []AnthropicMessage     `json:"messages"`
+	System        interface{}            `json:"system,omitempty"` // Can be string or []content block
+	MaxTokens     int                    `json:"max_tokens"`
+	Temperature   *float64               `json:"temperature,omitempty"`
+	TopP          *float64               `json:"top_p,omitempty"`
+	TopK          *int                   `json:"top_k,omitempty"`
+	StopSequences []string               `json:"stop_sequences,omitempty"`
+	Stream        bool                   `json:"stream"`
+	Tools         []AnthropicTool        `json:"tools,omitempty"`
+	ToolChoice    map[string]interface{} `json:"tool_choice,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// AnthropicMessage represents a message in Anthropic format
+type AnthropicMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"` // Can be string or []content block
+}
+
+// AnthropicTool represents a tool definition in Anthropic format
+type AnthropicTool struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"input_schema,omitempty"`
+}
+
+// AnthropicContentBlock represents a response content block
+type AnthropicContentBlock struct {
+	Type     string      `json:"type"`
+	Text     string      `json:"text,omitempty"`
+	Thinking string      `json:"thinking,omitempty"`
+	ID       string      `json:"id,omitempty"`
+	Name     string      `json:"name,omitempty"`
+	Input    interface{} `json:"input,omitempty"` // Only set on tool_use blocks
+}
+
+// AnthropicUsage represents token usage in Anthropic format
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// AnthropicMessagesResponse represents an Anthropic Messages API response
+type AnthropicMessagesResponse struct {
+	ID           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+// AnthropicStreamEvent is a single Server-Sent Event in an Anthropic stream
+type AnthropicStreamEvent struct {
+	Type string
+	Data map[string]interface{}
+}
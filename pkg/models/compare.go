@@ -0,0 +1,51 @@
+package models
+
+// Comparison Models
+
+// CompareRequest is a chat completion request fanned out to several models.
+// Prompt is a shorthand for a single user message.
+type CompareRequest struct {
+	OpenAIChatCompletionRequest
+	Models []string `json:"models"`
+	Prompt string   `json:"prompt,omitempty"`
+}
+
+// OpenAIUsage represents token usage in OpenAI format
+type OpenAIUsage struct {
+	PromptTokens            int                            `json:"prompt_tokens"`
+	CompletionTokens        int                            `json:"completion_tokens"`
+	TotalTokens             int                            `json:"total_tokens"`
+	PromptTokensDetails     *OpenAIPromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
+	CompletionTokensDetails *OpenAICompletionTokensDetails `json:"completion_tokens_details,omitempty"`
+}
+
+// OpenAIPromptTokensDetails breaks down prompt tokens
+type OpenAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// OpenAICompletionTokensDetails breaks down completion tokens
+type OpenAICompletionTokensDetails struct {
+	ReasoningTokens int `json:"reasoning_tokens"`
+}
+
+// CompareError describes why one model of a comparison failed
+type CompareError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// CompareResult is one model's outcome in a comparison
+type CompareResult struct {
+	Model     string                        `json:"model"`
+	LatencyMs int64                         `json:"latency_ms"`
+	Usage     *OpenAIUsage                  `json:"usage,omitempty"`
+	Response  *OpenAIChatCompletionResponse `json:"response,omitempty"`
+	Error     *CompareError                 `json:"error,omitempty"`
+}
+
+// CompareResponse holds each model's result, in request order
+type CompareResponse struct {
+	Object  string          `json:"object"`
+	Results []CompareResult `json:"results"`
+}
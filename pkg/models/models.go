@@ -9,8 +9,64 @@ import (
 // OpenAIChatMessage represents a message in OpenAI chat format
 type OpenAIChatMessage struct {
 	Role             string      `json:"role"`
-	Content          interface{} `json:"content"` // Can be string or []interface{}
+	Content          interface{} `json:"content"` // Can be string, []interface{} or nil (serialized as null)
 	ReasoningContent *string     `json:"reasoning_content,omitempty"`
+	Audio            *OpenAIAudio `json:"audio,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string      `json:"tool_call_id,omitempty"` // Set on "tool" role messages
+	Name             string      `json:"name,omitempty"`
+	ContentBlocks    []interface{} `json:"-"` // Typed text and image_url blocks behind a response's flattened content
+	Annotations      []OpenAIAnnotation `json:"annotations,omitempty"` // Grounding citations, only with INCLUDE_GROUNDING_CITATIONS
+}
+
+// OpenAIAnnotation represents a citation attached to message content
+type OpenAIAnnotation struct {
+	Type        string            `json:"type"`
+	URLCitation OpenAIURLCitation `json:"url_citation"`
+}
+
+// OpenAIURLCitation cites the web source for a range of characters in the content
+type OpenAIURLCitation struct {
+	URL        string `json:"url"`
+	Title      string `json:"title,omitempty"`
+	StartIndex int    `json:"start_index"`
+	EndIndex   int    `json:"end_index"`
+}
+
+// OpenAITool represents a tool the model may call
+type OpenAITool struct {
+	Type     string                   `json:"type"`
+	Function OpenAIFunctionDefinition `json:"function"`
+}
+
+// OpenAIFunctionDefinition describes a callable function
+type OpenAIFunctionDefinition struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// OpenAIToolCall represents a tool call in OpenAI format
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"` // Only set in streaming deltas
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+// OpenAIFunctionCall represents the function invoked by a tool call
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+// OpenAIAudio represents audio output in an OpenAI message
+type OpenAIAudio struct {
+	ID         string `json:"id"`
+	Data       string `json:"data"`
+	ExpiresAt  int64  `json:"expires_at,omitempty"`
+	Transcript string `json:"transcript,omitempty"`
+	MimeType   string `json:"mime_type,omitempty"` // Format of data; in a stream, only on the choice's first audio delta
 }
 
 // OpenAIChatCompletionRequest represents an OpenAI chat completion request
@@ -27,44 +83,91 @@ type OpenAIChatCompletionRequest struct {
 	N                *int                   `json:"n,omitempty"`
 	Seed             *int                   `json:"seed,omitempty"`
 	ResponseFormat   map[string]interface{} `json:"response_format,omitempty"`
+	Modalities       []string               `json:"modalities,omitempty"`
+	ExtraBody        map[string]interface{} `json:"extra_body,omitempty"`
+	Tools            []OpenAITool           `json:"tools,omitempty"`
+	ToolChoice       interface{}            `json:"tool_choice,omitempty"` // Can be string or object
+	ParallelToolCalls *bool                 `json:"parallel_tool_calls,omitempty"`
+	Store            *bool                  `json:"store,omitempty"`       // Acknowledged only; nothing is stored
+	Metadata         map[string]string      `json:"metadata,omitempty"`    // Client tags, recorded in access logs
+	Prediction       map[string]interface{} `json:"prediction,omitempty"`  // Predicted outputs; Gemini has no equivalent
+	Timeout          *float64               `json:"timeout,omitempty"`     // Seconds; the X-Request-Timeout-Seconds header takes precedence
+	ServiceTier      string                 `json:"service_tier,omitempty"` // auto, default or flex; echoed back only
+	Logprobs         *bool                  `json:"logprobs,omitempty"`
+	TopLogprobs      *int                   `json:"top_logprobs,omitempty"` // Implies logprobs when above 0
+	Template         string                 `json:"template,omitempty"`  // Server-side prompt template, expanded before messages
+	Variables        map[string]interface{} `json:"variables,omitempty"` // Values for the template's {{placeholders}}
+}
+
+// OpenAILogprobs holds the log probabilities of a choice's content tokens
+type OpenAILogprobs struct {
+	Content []OpenAITokenLogprob `json:"content"`
+}
+
+// OpenAITokenLogprob is a sampled token with its most likely alternatives
+type OpenAITokenLogprob struct {
+	Token       string             `json:"token"`
+	Logprob     float64            `json:"logprob"`
+	Bytes       []int              `json:"bytes"`
+	TopLogprobs []OpenAITopLogprob `json:"top_logprobs"`
+}
+
+// OpenAITopLogprob is one of the most likely tokens at a position
+type OpenAITopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
 }
 
 // OpenAIChatCompletionChoice represents a choice in OpenAI chat completion response
 type OpenAIChatCompletionChoice struct {
 	Index        int                  `json:"index"`
 	Message      OpenAIChatMessage    `json:"message"`
+	Logprobs     *OpenAILogprobs      `json:"logprobs,omitempty"`
 	FinishReason *string              `json:"finish_reason,omitempty"`
 }
 
 // OpenAIChatCompletionResponse represents an OpenAI chat completion response
 type OpenAIChatCompletionResponse struct {
-	ID      string                          `json:"id"`
-	Object  string                          `json:"object"`
-	Created int64                           `json:"created"`
-	Model   string                          `json:"model"`
-	Choices []*OpenAIChatCompletionChoice    `json:"choices"`
+	ID                string                          `json:"id"`
+	Object            string                          `json:"object"`
+	Created           int64                           `json:"created"`
+	Model             string                          `json:"model"`
+	SystemFingerprint string                          `json:"system_fingerprint,omitempty"`
+	Choices           []*OpenAIChatCompletionChoice    `json:"choices"`
+	RawGemini         map[string]interface{}          `json:"_gemini,omitempty"` // Untranslated response, only when requested
+	ServiceTier       string                          `json:"service_tier,omitempty"`
+	ResolvedModel     string                          `json:"_resolved_model,omitempty"` // Underlying model that served the request
 }
 
 // OpenAIDelta represents a delta in streaming OpenAI response
 type OpenAIDelta struct {
-	Content          *string `json:"content,omitempty"`
-	ReasoningContent *string `json:"reasoning_content,omitempty"`
+	Content          *string      `json:"content,omitempty"`
+	ReasoningContent *string      `json:"reasoning_content,omitempty"`
+	Audio            *OpenAIAudio `json:"audio,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+	Annotations      []OpenAIAnnotation `json:"annotations,omitempty"`
 }
 
 // OpenAIChatCompletionStreamChoice represents a streaming choice in OpenAI response
 type OpenAIChatCompletionStreamChoice struct {
 	Index        int       `json:"index"`
 	Delta        OpenAIDelta `json:"delta"`
+	Logprobs     *OpenAILogprobs `json:"logprobs,omitempty"`
 	FinishReason *string   `json:"finish_reason,omitempty"`
 }
 
 // OpenAIChatCompletionStreamResponse represents a streaming OpenAI chat completion response
 type OpenAIChatCompletionStreamResponse struct {
-	ID      string                               `json:"id"`
-	Object  string                               `json:"object"`
-	Created int64                                `json:"created"`
-	Model   string                               `json:"model"`
-	Choices []*OpenAIChatCompletionStreamChoice   `json:"choices"`
+	ID                string                               `json:"id"`
+	Object            string                               `json:"object"`
+	Created           int64                                `json:"created"`
+	Model             string                               `json:"model"`
+	SystemFingerprint string                               `json:"system_fingerprint,omitempty"`
+	Choices           []*OpenAIChatCompletionStreamChoice   `json:"choices"`
+	ServiceTier       string                               `json:"service_tier,omitempty"`
+	ContinuationToken string                               `json:"continuation_token,omitempty"` // Set periodically on resumable streams
+	ResolvedModel     string                               `json:"_resolved_model,omitempty"` // Underlying model that served the request
 }
 
 // Gemini Models
@@ -102,24 +205,26 @@ type GeminiResponse struct {
 // Helper functions
 
 // NewOpenAIChatCompletionResponse creates a new OpenAI chat completion response
-func NewOpenAIChatCompletionResponse(id, model string, choices []*OpenAIChatCompletionChoice) *OpenAIChatCompletionResponse {
+func NewOpenAIChatCompletionResponse(id, model, systemFingerprint string, choices []*OpenAIChatCompletionChoice) *OpenAIChatCompletionResponse {
 	return &OpenAIChatCompletionResponse{
-		ID:      id,
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: choices,
+		ID:                id,
+		Object:            "chat.completion",
+		Created:           time.Now().Unix(),
+		Model:             model,
+		SystemFingerprint: systemFingerprint,
+		Choices:           choices,
 	}
 }
 
 // NewOpenAIChatCompletionStreamResponse creates a new OpenAI chat completion stream response
-func NewOpenAIChatCompletionStreamResponse(id, model string, choices []*OpenAIChatCompletionStreamChoice) *OpenAIChatCompletionStreamResponse {
+func NewOpenAIChatCompletionStreamResponse(id, model, systemFingerprint string, choices []*OpenAIChatCompletionStreamChoice) *OpenAIChatCompletionStreamResponse {
 	return &OpenAIChatCompletionStreamResponse{
-		ID:      id,
-		Object:  "chat.completion.chunk",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: choices,
+		ID:                id,
+		Object:            "chat.completion.chunk",
+		Created:           time.Now().Unix(),
+		Model:             model,
+		SystemFingerprint: systemFingerprint,
+		Choices:           choices,
 	}
 }
 
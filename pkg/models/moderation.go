@@ -0,0 +1,24 @@
+package models
+
+// Moderation Models
+
+// OpenAIModerationRequest represents an OpenAI moderation request. Input is a
+// string, a list of strings or a list of {"type": "text", "text": ...} objects.
+type OpenAIModerationRequest struct {
+	Input interface{} `json:"input"`
+	Model string      `json:"model,omitempty"`
+}
+
+// OpenAIModerationResult is the moderation verdict for one input
+type OpenAIModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`
+	CategoryScores map[string]float64 `json:"category_scores"`
+}
+
+// OpenAIModerationResponse represents an OpenAI moderation response
+type OpenAIModerationResponse struct {
+	ID      string                   `json:"id"`
+	Model   string                   `json:"model"`
+	Results []OpenAIModerationResult `json:"results"`
+}
@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/auth"
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+)
+
+// AdminHandler handles administrative endpoints
+type AdminHandler struct {
+	authConfig *auth.AuthConfig
+	config     *config.Config
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(authConfig *auth.AuthConfig, cfg *config.Config) *AdminHandler {
+	return &AdminHandler{
+		authConfig: authConfig,
+		config:     cfg,
+	}
+}
+
+// RegisterRoutes registers admin routes. They are only enabled when ADMIN_TOKEN is set.
+func (h *AdminHandler) RegisterRoutes(router gin.IRouter) {
+	if h.config.AdminToken == "" {
+		log.Println("ADMIN_TOKEN not set - admin endpoints disabled")
+		return
+	}
+
+	admin := router.Group("/admin", h.AuthMiddleware())
+	{
+		admin.GET("/auth/status", h.AuthStatus)
+		admin.POST("/auth/reauth", h.Reauth)
+		admin.GET("/quota", h.Quota)
+	}
+}
+
+// AuthMiddleware checks the admin token from the Authorization or X-Admin-Token header
+func (h *AdminHandler) AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Admin-Token")
+		if authHeader := c.GetHeader("Authorization"); token == "" && strings.HasPrefix(authHeader, "Bearer ") {
+			token = strings.TrimPrefix(authHeader, "Bearer ")
+		}
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.config.AdminToken)) != 1 {
+			apierrors.AbortWithJSON(c, http.StatusUnauthorized, "Invalid admin token")
+			return
+		}
+		c.Next()
+	}
+}
+
+// AuthStatus reports the current authentication state
+func (h *AdminHandler) AuthStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.authConfig.Status())
+}
+
+// Reauth re-runs project discovery and onboarding
+func (h *AdminHandler) Reauth(c *gin.Context) {
+	log.Println("Admin requested re-onboarding")
+
+	projectID, err := h.authConfig.Reauth()
+	if err != nil {
+		log.Printf("Re-onboarding failed: %v", err)
+		apierrors.JSON(c, http.StatusBadGateway, "Re-onboarding failed: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "onboarded",
+		"project_id": projectID,
+		"auth":       h.authConfig.Status(),
+	})
+}
+
+// Quota validates credentials and reports the Code Assist tier
+func (h *AdminHandler) Quota(c *gin.Context) {
+	info, err := h.authConfig.QuotaInfo()
+	if err != nil {
+		log.Printf("Quota lookup failed: %v", err)
+		apierrors.JSON(c, http.StatusBadGateway, "Quota lookup failed: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
@@ -0,0 +1,291 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/accesslog"
+	"geminicli2api/pkg/auth"
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/timing"
+	"geminicli2api/pkg/transformers"
+)
+
+// AnthropicHandler handles Anthropic Messages API compatible endpoints
+type AnthropicHandler struct {
+	authConfig   *auth.AuthConfig
+	googleClient *google.Client
+	config       *config.Config
+}
+
+// NewAnthropicHandler creates a new Anthropic handler
+func NewAnthropicHandler(authConfig *auth.AuthConfig, googleClient *google.Client, cfg *config.Config) *AnthropicHandler {
+	return &AnthropicHandler{
+		authConfig:   authConfig,
+		googleClient: googleClient,
+		config:       cfg,
+	}
+}
+
+// RegisterRoutes registers Anthropic-compatible routes
+func (h *AnthropicHandler) RegisterRoutes(router gin.IRouter) {
+	router.POST("/v1/messages", h.AuthMiddleware(), h.Messages)
+}
+
+// AuthMiddleware handles authentication for Anthropic routes
+func (h *AnthropicHandler) AuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, err := h.authConfig.AuthenticateUser(c.Request)
+		if err != nil {
+			abortWithAnthropicError(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		c.Set("username", username)
+		c.Next()
+	}
+}
+
+// Messages handles Anthropic Messages API requests
+func (h *AnthropicHandler) Messages(c *gin.Context) {
+	var request models.AnthropicMessagesRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		anthropicError(c, http.StatusBadRequest, "Invalid request format: "+err.Error())
+		return
+	}
+
+	// The override header takes precedence over the body's model
+	override, err := modelOverride(c, h.config)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if override != "" {
+		request.Model = override
+	}
+	if err := checkModelAllowed(h.config, request.Model); err != nil {
+		anthropicError(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	log.Printf("Anthropic messages request: model=%s, stream=%v", request.Model, request.Stream)
+	accesslog.SetModel(c, request.Model)
+
+	// Bound the whole request by the client's or the default timeout
+	cancel, err := applyRequestTimeout(c, h.config, nil)
+	if err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+
+	// Collect per-phase timings for the Server-Timing header
+	ctx, timings := timing.WithTimings(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	transformStart := time.Now()
+
+	geminiRequestData, err := transformers.AnthropicRequestToGemini(&request, h.config)
+	if err != nil {
+		log.Printf("Error processing Anthropic request: %v", err)
+		anthropicError(c, http.StatusBadRequest, "Request processing failed: "+err.Error())
+		return
+	}
+
+	// Reject requests the model can't serve before hitting the upstream
+	method := "generateContent"
+	if request.Stream {
+		method = "streamGenerateContent"
+	}
+	if err := google.CheckModelCapabilities(h.config, request.Model, method, geminiRequestData); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The transformed request has the same shape as the OpenAI one
+	geminiPayload := h.googleClient.BuildGeminiPayloadFromOpenAI(geminiRequestData)
+	timings.Track("transform", transformStart)
+
+	// Optionally reject prompts over the model's input limit up front
+	if err := h.googleClient.CheckInputTokenLimit(c.Request.Context(), request.Model, geminiPayload); err != nil {
+		anthropicError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	messageID := "msg_" + strings.ReplaceAll(responseUUID(c).String(), "-", "")
+	if request.Stream {
+		h.handleStreamingResponse(c, &request, messageID, geminiPayload)
+	} else {
+		h.handleNonStreamingResponse(c, &request, messageID, geminiPayload)
+	}
+}
+
+// handleStreamingResponse streams Anthropic message events
+func (h *AnthropicHandler) handleStreamingResponse(c *gin.Context, request *models.AnthropicMessagesRequest, messageID string, geminiPayload map[string]interface{}) {
+	// Cancellable if the stream runs past MAX_STREAM_DURATION
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	resp, chunks, err := h.googleClient.SendStreamRequest(ctx, geminiPayload)
+	if err != nil {
+		log.Printf("Anthropic streaming request failed: %v", err)
+		anthropicError(c, upstreamErrorStatus(err), "Streaming request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+	setTimingHeaders(c, resp)
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Google API returned status %d", resp.StatusCode)
+		h.handleErrorResponse(c, resp)
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Status(http.StatusOK)
+
+	// Optionally batch flushes of small chunks
+	defer batchStreamFlushes(c, h.config)()
+
+	transformer := transformers.NewAnthropicStreamTransformer(request.Model, messageID)
+	latency := newStreamLatency(c)
+	defer latency.Finish(messageID)
+	keepAlive := newKeepAlive(h.config)
+	defer keepAlive.Stop()
+	for chunk, ok := keepAlive.Next(c, chunks); ok; chunk, ok = keepAlive.Next(c, chunks) {
+		if chunk.Err != nil {
+			writeAnthropicEvent(c, "error", newAnthropicError(http.StatusInternalServerError, "Streaming error: "+chunk.Err.Error()))
+			return
+		}
+		if details := google.PromptBlockDetails(chunk.Data); details != nil {
+			log.Printf("Gemini blocked the prompt: %s", google.BlockMessage(details))
+			writeAnthropicEvent(c, "error", newAnthropicError(http.StatusBadRequest, google.BlockMessage(details)))
+			return
+		}
+		latency.Observe(chunk.Data)
+
+		accesslog.RecordUsage(c, chunk.Data)
+		for _, event := range transformer.Transform(chunk.Data) {
+			if err := writeAnthropicEvent(c, event.Type, event.Data); err != nil {
+				log.Printf("Error writing event: %v", err)
+				return
+			}
+		}
+	}
+
+	if c.Request.Context().Err() != nil {
+		return
+	}
+	if keepAlive.Expired() {
+		cancel()
+		log.Printf("Stream %s reached MAX_STREAM_DURATION of %v, ending it", messageID, h.config.MaxStreamDuration)
+		transformer.Truncate()
+	}
+	if comment := latency.Comment(); comment != "" {
+		writeStreamComment(c, comment)
+	}
+	for _, event := range transformer.Finish() {
+		if err := writeAnthropicEvent(c, event.Type, event.Data); err != nil {
+			log.Printf("Error writing event: %v", err)
+			return
+		}
+	}
+
+	log.Printf("Completed Anthropic streaming response: %s", messageID)
+}
+
+// handleNonStreamingResponse returns a complete Anthropic message
+func (h *AnthropicHandler) handleNonStreamingResponse(c *gin.Context, request *models.AnthropicMessagesRequest, messageID string, geminiPayload map[string]interface{}) {
+	resp, err := h.googleClient.SendGeminiRequest(c.Request.Context(), geminiPayload, false)
+	if err != nil {
+		log.Printf("Anthropic request failed: %v", err)
+		anthropicError(c, upstreamErrorStatus(err), "Request failed: "+err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Google API returned status %d", resp.StatusCode)
+		setTimingHeaders(c, resp)
+		h.handleErrorResponse(c, resp)
+		return
+	}
+
+	var geminiResponse map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResponse); err != nil {
+		log.Printf("Failed to parse Gemini response: %v", err)
+		anthropicError(c, http.StatusInternalServerError, "Failed to process response: "+err.Error())
+		return
+	}
+
+	accesslog.RecordUsage(c, geminiResponse)
+
+	// Explain a blocked prompt or response rather than returning an empty message
+	if details := google.BlockDetails(geminiResponse); details != nil {
+		log.Printf("Gemini blocked the request: %s", google.BlockMessage(details))
+		setTimingHeaders(c, resp)
+		anthropicError(c, http.StatusBadRequest, google.BlockMessage(details))
+		return
+	}
+
+	transformStart := time.Now()
+	anthropicResponse := transformers.GeminiResponseToAnthropic(geminiResponse, request.Model, messageID)
+	timing.FromContext(c.Request.Context()).Track("transform", transformStart)
+	setTimingHeaders(c, resp)
+
+	c.JSON(http.StatusOK, anthropicResponse)
+}
+
+// handleErrorResponse relays an upstream error in Anthropic format
+func (h *AnthropicHandler) handleErrorResponse(c *gin.Context, resp *http.Response) {
+	var errorData apierrors.Response
+	if err := json.NewDecoder(resp.Body).Decode(&errorData); err == nil && errorData.Error.Message != "" {
+		anthropicError(c, resp.StatusCode, errorData.Error.Message)
+		return
+	}
+	anthropicError(c, resp.StatusCode, fmt.Sprintf("API error: %d", resp.StatusCode))
+}
+
+// newAnthropicError creates an Anthropic error envelope. Anthropic's error types
+// match OpenAI's for the statuses we produce.
+func newAnthropicError(status int, message string) gin.H {
+	return gin.H{
+		"type": "error",
+		"error": gin.H{
+			"type":    apierrors.TypeForStatus(status),
+			"message": message,
+		},
+	}
+}
+
+// anthropicError writes an Anthropic error envelope as the response
+func anthropicError(c *gin.Context, status int, message string) {
+	c.JSON(status, newAnthropicError(status, message))
+}
+
+// abortWithAnthropicError writes an Anthropic error envelope and aborts the handler chain
+func abortWithAnthropicError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, newAnthropicError(status, message))
+}
+
+// writeAnthropicEvent writes a named SSE event and flushes it
+func writeAnthropicEvent(c *gin.Context, eventType string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", eventType, payload); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
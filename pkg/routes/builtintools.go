@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/accesslog"
+	"geminicli2api/pkg/builtintools"
+	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/transformers"
+)
+
+// runBuiltinTools runs the built-in tools Gemini calls in a non-streaming
+// response and sends their results back, until Gemini answers without calling
+// one. After BUILTIN_TOOL_MAX_ITERATIONS rounds Gemini is asked to answer
+// with function calling disabled. Built-in calls made alongside calls to the
+// client's own tools are dropped, since the client can't run them. It also
+// returns the payload of the final round, extended with every earlier round's
+// turns, and the total tokens used across all rounds.
+func (h *OpenAIHandler) runBuiltinTools(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}, geminiResponse map[string]interface{}) (map[string]interface{}, map[string]interface{}, int, error) {
+	tokens := accesslog.TotalTokens(geminiResponse)
+	if !transformers.BuiltinToolsEnabled(request, h.config) {
+		return geminiResponse, geminiPayload, tokens, nil
+	}
+	builtin := map[string]bool{}
+	for _, name := range h.config.BuiltinTools {
+		builtin[name] = true
+	}
+	for _, tool := range request.Tools {
+		delete(builtin, tool.Function.Name)
+	}
+
+	for round := 0; ; round++ {
+		candidates, _ := geminiResponse["candidates"].([]interface{})
+		if len(candidates) == 0 {
+			return geminiResponse, geminiPayload, tokens, nil
+		}
+		candidate, _ := candidates[0].(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+
+		var responses, clientParts []interface{}
+		clientCalls := 0
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			functionCall, ok := part["functionCall"].(map[string]interface{})
+			name, _ := functionCall["name"].(string)
+			if !ok || !builtin[name] {
+				clientParts = append(clientParts, p)
+				if ok {
+					clientCalls++
+				}
+				continue
+			}
+			args, _ := functionCall["args"].(map[string]interface{})
+			response := map[string]interface{}{"name": name, "response": builtintools.Run(name, args)}
+			if id, ok := functionCall["id"]; ok {
+				response["id"] = id
+			}
+			responses = append(responses, map[string]interface{}{"functionResponse": response})
+		}
+
+		if len(responses) == 0 {
+			return geminiResponse, geminiPayload, tokens, nil
+		}
+		if clientCalls > 0 {
+			log.Printf("Dropping %d built-in tool calls made alongside client tool calls", len(responses))
+			content["parts"] = clientParts
+			return geminiResponse, geminiPayload, tokens, nil
+		}
+
+		geminiPayload = withContents(geminiPayload,
+			map[string]interface{}{"role": "model", "parts": content["parts"]},
+			map[string]interface{}{"role": "user", "parts": responses},
+		)
+		if round+1 >= h.config.BuiltinToolMaxIterations {
+			log.Printf("Built-in tools reached %d rounds, asking for a final answer", h.config.BuiltinToolMaxIterations)
+			payloadRequest := geminiPayload["request"].(map[string]interface{})
+			payloadRequest["toolConfig"] = map[string]interface{}{"functionCallingConfig": map[string]interface{}{"mode": "NONE"}}
+			final, err := h.generate(c, geminiPayload)
+			if err != nil {
+				return nil, nil, tokens, err
+			}
+			return final, geminiPayload, tokens + accesslog.TotalTokens(final), nil
+		}
+		log.Printf("Ran %d built-in tool calls (round %d)", len(responses), round+1)
+
+		var err error
+		if geminiResponse, err = h.generate(c, geminiPayload); err != nil {
+			return nil, nil, tokens, err
+		}
+		tokens += accesslog.TotalTokens(geminiResponse)
+	}
+}
@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/transformers"
+)
+
+// maxCompareModels caps how many models a single comparison may fan out to
+const maxCompareModels = 10
+
+// Compare sends one chat completion to several models concurrently and returns
+// their completions side by side, with per-model latency and token usage
+func (h *OpenAIHandler) Compare(c *gin.Context) {
+	var request models.CompareRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, bindError(err))
+		return
+	}
+
+	if len(request.Models) == 0 || len(request.Models) > maxCompareModels {
+		apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("models", apierrors.CodeInvalidValue, "models must list between 1 and %d models", maxCompareModels))
+		return
+	}
+	if request.Stream {
+		apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("stream", apierrors.CodeUnsupportedValue, "Streaming is not supported for comparisons"))
+		return
+	}
+	if request.Prompt != "" {
+		request.Messages = append(request.Messages, models.OpenAIChatMessage{Role: "user", Content: request.Prompt})
+	}
+	if len(request.Messages) == 0 {
+		apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("messages", apierrors.CodeMissingParameter, "Either messages or prompt is required"))
+		return
+	}
+	for _, model := range request.Models {
+		if h.config.GetModel(model) == nil {
+			apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("models", apierrors.CodeInvalidValue, "Unknown model: %s", model))
+			return
+		}
+		if err := checkModelAllowed(h.config, model); err != nil {
+			apierrors.JSON(c, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	// Bound the whole comparison by the client's or the default timeout; each
+	// model's own CompareTimeout is derived from it
+	cancel, err := applyRequestTimeout(c, h.config, request.Timeout)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	defer cancel()
+
+	log.Printf("Comparison request: models=%v", request.Models)
+
+	// Fan out with bounded concurrency, keeping results in request order
+	results := make([]models.CompareResult, len(request.Models))
+	slots := make(chan struct{}, h.config.CompareMaxConcurrency)
+	var wg sync.WaitGroup
+	for i, model := range request.Models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = h.compareModel(c.Request.Context(), request.OpenAIChatCompletionRequest, model)
+		}(i, model)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, models.CompareResponse{
+		Object:  "compare",
+		Results: results,
+	})
+}
+
+// compareModel runs a request against a single model of a comparison
+func (h *OpenAIHandler) compareModel(ctx context.Context, request models.OpenAIChatCompletionRequest, model string) models.CompareResult {
+	result := models.CompareResult{Model: model}
+	fail := func(status int, message string) models.CompareResult {
+		result.Error = &models.CompareError{Status: status, Message: message}
+		return result
+	}
+
+	request.Model = model
+	geminiRequestData, err := transformers.OpenAIRequestToGemini(&request, h.config)
+	if err != nil {
+		return fail(http.StatusBadRequest, "Request processing failed: "+err.Error())
+	}
+	if err := google.CheckModelCapabilities(h.config, model, "generateContent", geminiRequestData); err != nil {
+		return fail(http.StatusBadRequest, err.Error())
+	}
+	geminiPayload := h.googleClient.BuildGeminiPayloadFromOpenAI(geminiRequestData)
+
+	ctx, cancel := context.WithTimeout(ctx, h.config.CompareTimeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := h.googleClient.SendGeminiRequest(ctx, geminiPayload, false)
+	if err != nil {
+		result.LatencyMs = time.Since(start).Milliseconds()
+		return fail(upstreamErrorStatus(err), "Request failed: "+err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		result.LatencyMs = time.Since(start).Milliseconds()
+		var errorData apierrors.Response
+		if err := json.NewDecoder(resp.Body).Decode(&errorData); err == nil && errorData.Error.Message != "" {
+			return fail(resp.StatusCode, errorData.Error.Message)
+		}
+		return fail(resp.StatusCode, fmt.Sprintf("API error: %d", resp.StatusCode))
+	}
+
+	var geminiResponse map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&geminiResponse)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "Failed to process response: "+err.Error())
+	}
+
+	result.Usage = usageFromGemini(geminiResponse)
+	if details := google.BlockDetails(geminiResponse); details != nil {
+		return fail(http.StatusBadRequest, google.BlockMessage(details))
+	}
+
+	result.Response = transformers.GeminiResponseToOpenAI(geminiResponse, model, transformers.SystemFingerprint(model, request.Seed), h.config.TextPartSeparator())
+	if h.config.StripThinking {
+		transformers.DropReasoningContent(result.Response)
+	}
+	if h.config.IncludeGroundingCitations {
+		transformers.AddGroundingCitations(result.Response, geminiResponse)
+	}
+	if h.config.ResponseContentFormat == "array" {
+		transformers.UseContentArray(result.Response)
+	}
+	return result
+}
+
+// usageFromGemini maps Gemini usageMetadata to OpenAI usage, or nil if absent.
+// Thinking and cached tokens are broken out when Gemini reports them.
+func usageFromGemini(geminiResponse map[string]interface{}) *models.OpenAIUsage {
+	metadata, ok := geminiResponse["usageMetadata"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	count := func(key string) int {
+		value, _ := metadata[key].(float64)
+		return int(value)
+	}
+	usage := &models.OpenAIUsage{
+		PromptTokens:     count("promptTokenCount"),
+		CompletionTokens: count("candidatesTokenCount") + count("thoughtsTokenCount"),
+		TotalTokens:      count("totalTokenCount"),
+	}
+	if _, ok := metadata["cachedContentTokenCount"]; ok {
+		usage.PromptTokensDetails = &models.OpenAIPromptTokensDetails{CachedTokens: count("cachedContentTokenCount")}
+	}
+	if _, ok := metadata["thoughtsTokenCount"]; ok {
+		usage.CompletionTokensDetails = &models.OpenAICompletionTokensDetails{ReasoningTokens: count("thoughtsTokenCount")}
+	}
+	return usage
+}
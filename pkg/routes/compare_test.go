@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"geminicli2api/pkg/models"
+)
+
+func TestUsageFromGemini(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     *models.OpenAIUsage
+	}{
+		{
+			name: "thinking and cached tokens",
+			response: `{"usageMetadata": {"promptTokenCount": 120, "cachedContentTokenCount": 100,
+				"candidatesTokenCount": 30, "thoughtsTokenCount": 50, "totalTokenCount": 200}}`,
+			want: &models.OpenAIUsage{
+				PromptTokens:            120,
+				CompletionTokens:        80,
+				TotalTokens:             200,
+				PromptTokensDetails:     &models.OpenAIPromptTokensDetails{CachedTokens: 100},
+				CompletionTokensDetails: &models.OpenAICompletionTokensDetails{ReasoningTokens: 50},
+			},
+		},
+		{
+			name:     "plain counts",
+			response: `{"usageMetadata": {"promptTokenCount": 12, "candidatesTokenCount": 3, "totalTokenCount": 15}}`,
+			want:     &models.OpenAIUsage{PromptTokens: 12, CompletionTokens: 3, TotalTokens: 15},
+		},
+		{
+			name:     "no usage",
+			response: `{"candidates": []}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var response map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.response), &response); err != nil {
+				t.Fatalf("invalid test response: %v", err)
+			}
+
+			if got := usageFromGemini(response); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("usageFromGemini() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareAppliesRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	})
+	t.Cleanup(func() { close(release) })
+	cfg := newTestConfig(t, upstream)
+	cfg.CompareTimeout = time.Minute
+	router := newOpenAIRouter(cfg)
+
+	start := time.Now()
+	w := postJSON(router, "/v1/compare", `{"models": ["gemini-2.5-flash", "gemini-2.5-pro"], "prompt": "Hi"}`, RequestTimeoutHeader, "0.1")
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("comparison took %v, want it bounded by the 0.1s request timeout", elapsed)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200; body: %s", w.Code, w.Body)
+	}
+	var response models.CompareResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range response.Results {
+		if result.Error == nil {
+			t.Errorf("%s succeeded, want a timeout error", result.Model)
+		}
+	}
+
+	w = postJSON(router, "/v1/compare", `{"models": ["gemini-2.5-flash"], "prompt": "Hi", "timeout": -1}`)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("negative timeout status = %d, want 400", w.Code)
+	}
+}
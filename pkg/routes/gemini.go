@@ -4,13 +4,18 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"geminicli2api/pkg/accesslog"
 	"geminicli2api/pkg/auth"
 	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
 	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/timing"
 )
 
 // GeminiHandler handles native Gemini API endpoints
@@ -30,12 +35,12 @@ func NewGeminiHandler(authConfig *auth.AuthConfig, googleClient *google.Client,
 }
 
 // RegisterRoutes registers native Gemini API routes
-func (h *GeminiHandler) RegisterRoutes(router *gin.Engine) {
+func (h *GeminiHandler) RegisterRoutes(router gin.IRouter) {
 	// Native Gemini endpoints
 	router.GET("/v1beta/models", h.AuthMiddleware(), h.ListModels)
-	// Specific generateContent endpoints
-	router.POST("/v1beta/models/:model/generateContent", h.AuthMiddleware(), h.GeminiProxy)
-	router.POST("/v1beta/models/:model/streamGenerateContent", h.AuthMiddleware(), h.GeminiProxy)
+	// generateContent endpoints; the action is matched in GeminiProxy so that
+	// trailing slashes, any case and the ":action" form are all accepted
+	router.POST("/v1beta/models/*action", h.AuthMiddleware(), h.GeminiProxy)
 }
 
 // AuthMiddleware handles authentication for Gemini routes
@@ -43,13 +48,7 @@ func (h *GeminiHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username, err := h.authConfig.AuthenticateUser(c.Request)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": gin.H{
-					"message": err.Error(),
-					"code":    http.StatusUnauthorized,
-				},
-			})
-			c.Abort()
+			apierrors.AbortWithJSON(c, http.StatusUnauthorized, err.Error())
 			return
 		}
 		c.Set("username", username)
@@ -61,69 +60,120 @@ func (h *GeminiHandler) AuthMiddleware() gin.HandlerFunc {
 func (h *GeminiHandler) ListModels(c *gin.Context) {
 	log.Printf("Gemini models list requested")
 
+	availableModels := h.config.AvailableModels()
 	modelsResponse := gin.H{
-		"models": h.config.SupportedModels,
+		"models": availableModels,
 	}
 
-	log.Printf("Returning %d Gemini models", len(h.config.SupportedModels))
+	log.Printf("Returning %d Gemini models", len(availableModels))
 
 	c.JSON(http.StatusOK, modelsResponse)
 }
 
 // GeminiProxy handles native Gemini API proxy requests
 func (h *GeminiHandler) GeminiProxy(c *gin.Context) {
-	fullPath := c.Param("full_path")
-	if fullPath == "" {
-		fullPath = c.Request.URL.Path
-	}
+	// Everything after /v1beta/models, e.g. "/gemini-2.5-pro:generateContent";
+	// parsing only this keeps a ROUTE_PREFIX out of the model name
+	actionPath := c.Param("action")
+	fullPath := c.Request.URL.Path
 
-	// Determine if this is a streaming request
-	isStreaming := strings.Contains(strings.ToLower(fullPath), "stream")
+	// Determine the action and whether this is a streaming request
+	action := extractActionFromPath(actionPath)
+	if action == "" {
+		apierrors.JSON(c, http.StatusNotFound, "Unknown endpoint: "+fullPath)
+		return
+	}
+	isStreaming := action == "streamGenerateContent"
 
 	// Extract model name from the path
-	modelName := extractModelFromPath(fullPath)
+	modelName := extractModelFromPath(actionPath)
+
+	// The override header takes precedence over the model in the path
+	override, err := modelOverride(c, h.config)
+	if err != nil {
+		apierrors.JSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if override != "" {
+		modelName = override
+	}
 
 	log.Printf("Gemini proxy request: path=%s, model=%s, stream=%v", fullPath, modelName, isStreaming)
 
 	if modelName == "" {
 		log.Printf("Could not extract model name from path: %s", fullPath)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "Could not extract model name from path: " + fullPath,
-				"code":    http.StatusBadRequest,
-			},
-		})
+		apierrors.JSON(c, http.StatusBadRequest, "Model name is missing or empty in path: "+fullPath)
+		return
+	}
+
+	if err := checkModelAllowed(h.config, modelName); err != nil {
+		apierrors.JSON(c, http.StatusForbidden, err.Error())
 		return
 	}
+	accesslog.SetModel(c, modelName)
 
 	// Read the request body
 	var requestData map[string]interface{}
 	if c.Request.ContentLength > 0 {
 		if err := c.ShouldBindJSON(&requestData); err != nil {
 			log.Printf("Invalid JSON in request body: %v", err)
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": gin.H{
-					"message": "Invalid JSON in request body",
-					"code":    http.StatusBadRequest,
-				},
-			})
+			apierrors.JSON(c, http.StatusBadRequest, "Invalid JSON in request body")
 			return
 		}
 	}
 
+	// Query parameters fill in, or with ?force=1 replace, generation parameters
+	queryParams, err := parseQueryOverrides(c, modelName)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if queryParams != nil {
+		if requestData == nil {
+			requestData = map[string]interface{}{}
+		}
+		queryParams.applyNative(requestData)
+	}
+
+	// Bound the whole request by the client's or the default timeout
+	cancel, err := applyRequestTimeout(c, h.config, nil)
+	if err != nil {
+		apierrors.JSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer cancel()
+
+	// Collect per-phase timings for the Server-Timing header
+	ctx, timings := timing.WithTimings(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	transformStart := time.Now()
+
+	// Validate inline data and model capabilities before hitting the upstream
+	err = google.ValidateNativeRequest(requestData)
+	if err == nil {
+		err = google.CheckModelCapabilities(h.config, modelName, action, requestData)
+	}
+	if err != nil {
+		log.Printf("Invalid native request: %v", err)
+		apierrors.JSON(c, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+
 	// Build the payload for Google API
 	geminiPayload := h.googleClient.BuildGeminiPayloadFromNative(requestData, modelName)
+	timings.Track("transform", transformStart)
+
+	// Optionally reject prompts over the model's input limit up front
+	if err := h.googleClient.CheckInputTokenLimit(c.Request.Context(), modelName, geminiPayload); err != nil {
+		apierrors.JSON(c, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	// Send the request to Google API
 	resp, err := h.googleClient.SendGeminiRequest(c.Request.Context(), geminiPayload, isStreaming)
 	if err != nil {
 		log.Printf("Gemini proxy error: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Proxy error: " + err.Error(),
-				"code":    http.StatusInternalServerError,
-			},
-		})
+		apierrors.JSON(c, upstreamErrorStatus(err), "Proxy error: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
@@ -135,6 +185,8 @@ func (h *GeminiHandler) GeminiProxy(c *gin.Context) {
 		}
 	}
 
+	setTimingHeaders(c, resp)
+
 	// Set status code
 	c.Status(resp.StatusCode)
 
@@ -160,33 +212,56 @@ func (h *GeminiHandler) HealthCheckDisabled(c *gin.Context) {
 	})
 }
 
-// extractModelFromPath extracts the model name from a Gemini API path
+// extractModelFromPath extracts the model name from the part of a Gemini API
+// path after "/models"
 //
 // Examples:
-// - "v1beta/models/gemini-1.5-pro/generateContent" -> "gemini-1.5-pro"
-// - "v1/models/gemini-2.0-flash/streamGenerateContent" -> "gemini-2.0-flash"
+// - "/gemini-1.5-pro/generateContent" -> "gemini-1.5-pro"
+// - "/gemini-2.0-flash:streamGenerateContent" -> "gemini-2.0-flash"
+// - "/gemini-2.5-flash%3AgenerateContent" -> "gemini-2.5-flash"
+// - "//generateContent" -> ""
 //
 // Args:
-//   path: The API path
+//   path: The path after "/models"
 //
 // Returns:
 //   Model name (just the model name, not prefixed with "models/") or empty string if not found
 func extractModelFromPath(path string) string {
-	parts := strings.Split(path, "/")
-
-	// Look for the pattern: .../models/{model_name}/...
-	for i, part := range parts {
-		if part == "models" && i+1 < len(parts) {
-			modelName := parts[i+1]
-			// Remove any action suffix like ":streamGenerateContent" or ":generateContent"
-			if idx := strings.Index(modelName, ":"); idx != -1 {
-				modelName = modelName[:idx]
-			}
-			// Return just the model name without "models/" prefix
-			return modelName
-		}
+	modelName := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+
+	// Decode percent-encoded characters, keeping the segment as is if malformed
+	if decoded, err := url.PathUnescape(modelName); err == nil {
+		modelName = decoded
+	}
+	// Remove any action suffix like ":streamGenerateContent" or ":generateContent"
+	if idx := strings.Index(modelName, ":"); idx != -1 {
+		modelName = modelName[:idx]
+	}
+	// A whitespace-only segment counts as empty
+	return strings.TrimSpace(modelName)
+}
+
+// extractActionFromPath returns the canonical generation action of a native path,
+// matched case-insensitively in either "/{model}/{action}" or "/{model}:{action}"
+// form, or an empty string if the path names no known action
+//
+// Examples:
+// - "/gemini-2.5-pro/StreamGenerateContent/" -> "streamGenerateContent"
+// - "/gemini-2.5-pro:generateContent" -> "generateContent"
+func extractActionFromPath(path string) string {
+	parts := strings.Split(strings.TrimRight(path, "/"), "/")
+	action := parts[len(parts)-1]
+	if decoded, err := url.PathUnescape(action); err == nil {
+		action = decoded
+	}
+	if idx := strings.LastIndex(action, ":"); idx != -1 {
+		action = action[idx+1:]
 	}
 
-	// If we can't find the pattern, return empty string
+	for _, known := range []string{"generateContent", "streamGenerateContent"} {
+		if strings.EqualFold(action, known) {
+			return known
+		}
+	}
 	return ""
-}
\ No newline at end of file
+}
@@ -0,0 +1,155 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const nativeRequest = `{"contents": [{"role": "user", "parts": [{"text": "Hi"}]}]}`
+
+// upstreamCall is what the fake upstream saw of a generate request
+type upstreamCall struct {
+	path  string
+	model string
+}
+
+// newRecordingGeminiRouter serves the native routes against a fake upstream
+// that records each generate request and answers with one candidate,
+// streamed when asked to
+func newRecordingGeminiRouter(t *testing.T) (*gin.Engine, *[]upstreamCall) {
+	t.Helper()
+	var calls []upstreamCall
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		calls = append(calls, upstreamCall{path: r.URL.Path, model: payload.Model})
+
+		response := `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "STOP"}]}`
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			writeSSE(w, response)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"response": %s}`, response)
+	})
+	return newGeminiRouter(newTestConfig(t, upstream)), &calls
+}
+
+func TestGeminiProxyActionForms(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantAction string
+	}{
+		{"/v1beta/models/gemini-2.5-flash:streamGenerateContent", "streamGenerateContent"},
+		{"/v1beta/models/gemini-2.5-flash/streamGenerateContent", "streamGenerateContent"},
+		{"/v1beta/models/gemini-2.5-flash/StreamGenerateContent/", "streamGenerateContent"},
+		{"/v1beta/models/gemini-2.5-flash/STREAMGENERATECONTENT", "streamGenerateContent"},
+		{"/v1beta/models/gemini-2.5-flash:StreamGenerateContent/", "streamGenerateContent"},
+		{"/v1beta/models/gemini-2.5-flash/generateContent/", "generateContent"},
+		{"/v1beta/models/gemini-2.5-flash:GenerateContent", "generateContent"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			router, calls := newRecordingGeminiRouter(t)
+
+			w := postJSON(router, tt.path, nativeRequest)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if len(*calls) != 1 {
+				t.Fatalf("upstream called %d times, want 1", len(*calls))
+			}
+			call := (*calls)[0]
+			if !strings.HasSuffix(call.path, ":"+tt.wantAction) {
+				t.Errorf("upstream path = %s, want the %s action", call.path, tt.wantAction)
+			}
+			if call.model != "gemini-2.5-flash" {
+				t.Errorf("upstream model = %q, want gemini-2.5-flash", call.model)
+			}
+			streamed := strings.Contains(w.Body.String(), "data: ")
+			if streamed != (tt.wantAction == "streamGenerateContent") {
+				t.Errorf("streamed = %v for %s:\n%s", streamed, tt.wantAction, w.Body)
+			}
+		})
+	}
+}
+
+func TestGeminiProxyUnknownAction(t *testing.T) {
+	router, calls := newRecordingGeminiRouter(t)
+
+	w := postJSON(router, "/v1beta/models/gemini-2.5-flash/streamGenerate", nativeRequest)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404", w.Code)
+	}
+	if len(*calls) != 0 {
+		t.Errorf("upstream called %d times for an unknown action", len(*calls))
+	}
+}
+
+func TestExtractModelFromPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/gemini-2.5-flash/generateContent", "gemini-2.5-flash"},
+		{"/gemini-2.5-flash:streamGenerateContent", "gemini-2.5-flash"},
+		{"/gemini-2.5-flash%3AgenerateContent", "gemini-2.5-flash"},
+		{"/gemini%2D2.5%2Dpro/generateContent", "gemini-2.5-pro"},
+		{"/bad%zzescape/generateContent", "bad%zzescape"},
+		{"//generateContent", ""},
+		{"/%20/generateContent", ""},
+		{"/:generateContent", ""},
+	}
+
+	for _, tt := range tests {
+		if got := extractModelFromPath(tt.path); got != tt.want {
+			t.Errorf("extractModelFromPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestGeminiProxyModelPath(t *testing.T) {
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantModel  string
+	}{
+		{"/v1beta/models/gemini-2.5-flash%3AgenerateContent", http.StatusOK, "gemini-2.5-flash"},
+		{"/v1beta/models//generateContent", http.StatusBadRequest, ""},
+		{"/v1beta/models/%20/generateContent", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			router, calls := newRecordingGeminiRouter(t)
+
+			w := postJSON(router, tt.path, nativeRequest)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusBadRequest {
+				if !strings.Contains(w.Body.String(), "Model name is missing or empty") {
+					t.Errorf("body = %s, want the missing model explained", w.Body)
+				}
+				if len(*calls) != 0 {
+					t.Errorf("upstream called %d times without a model", len(*calls))
+				}
+				return
+			}
+			if len(*calls) != 1 || (*calls)[0].model != tt.wantModel {
+				t.Errorf("upstream calls = %+v, want one for %s", *calls, tt.wantModel)
+			}
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package routes
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// IdempotencyKeyHeader lets clients that retry a request get the same response
+// ID each time
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyNamespace scopes the name-based UUIDs derived from idempotency keys
+var idempotencyNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("geminicli2api/idempotency-key"))
+
+// responseUUID returns the UUID a response ID is built from. With an
+// Idempotency-Key it's derived from the key and the authenticated user, so
+// retries get the same ID while different users' keys never collide; otherwise
+// it's random.
+func responseUUID(c *gin.Context) uuid.UUID {
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" {
+		return uuid.New()
+	}
+	return uuid.NewSHA1(idempotencyNamespace, []byte(c.GetString("username")+"\x00"+key))
+}
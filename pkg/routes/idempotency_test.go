@@ -0,0 +1,38 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// responseUUIDFor returns the response UUID of a request from user with key
+func responseUUIDFor(user string, key string) uuid.UUID {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	if key != "" {
+		c.Request.Header.Set(IdempotencyKeyHeader, key)
+	}
+	c.Set("username", user)
+	return responseUUID(c)
+}
+
+func TestResponseUUIDIdempotencyKey(t *testing.T) {
+	first := responseUUIDFor("alice", "order-42")
+
+	if again := responseUUIDFor("alice", "order-42"); again != first {
+		t.Errorf("retry got %s, want the same %s", again, first)
+	}
+	if other := responseUUIDFor("bob", "order-42"); other == first {
+		t.Error("another user's identical key got the same UUID")
+	}
+	if other := responseUUIDFor("alice", "order-43"); other == first {
+		t.Error("a different key got the same UUID")
+	}
+	if responseUUIDFor("alice", "") == responseUUIDFor("alice", "") {
+		t.Error("requests without a key got the same UUID")
+	}
+}
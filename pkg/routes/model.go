@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+)
+
+// ModelOverrideHeader lets gateways pick the target model without rewriting the body
+const ModelOverrideHeader = "X-Gemini-Model"
+
+// modelOverride returns the model named by the override header, or an empty string
+// if the header is absent. Unknown models are rejected.
+func modelOverride(c *gin.Context, cfg *config.Config) (string, error) {
+	model := strings.TrimSpace(c.GetHeader(ModelOverrideHeader))
+	if model == "" {
+		return "", nil
+	}
+	if cfg.GetModel(model) == nil {
+		return "", fmt.Errorf("unknown model in %s header: %s", ModelOverrideHeader, model)
+	}
+	return strings.TrimPrefix(model, "models/"), nil
+}
+
+// checkModelAllowed rejects models excluded by ALLOWED_MODELS or DENIED_MODELS
+func checkModelAllowed(cfg *config.Config, model string) error {
+	if !cfg.IsModelAllowed(model) {
+		return fmt.Errorf("model %s is not available on this server", model)
+	}
+	return nil
+}
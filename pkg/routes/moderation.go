@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/transformers"
+)
+
+const (
+	// defaultModerationModel evaluates moderation requests that don't name a
+	// Gemini model, such as those asking for "omni-moderation-latest"
+	defaultModerationModel = "gemini-2.5-flash"
+
+	// maxModerationInputs caps how many inputs a single request may moderate
+	maxModerationInputs = 32
+)
+
+// Moderations classifies inputs with Gemini's safety ratings and returns them
+// in OpenAI's moderation format. Each input costs one upstream request.
+func (h *OpenAIHandler) Moderations(c *gin.Context) {
+	var request models.OpenAIModerationRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, bindError(err))
+		return
+	}
+
+	inputs, err := moderationInputs(request.Input)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("input", apierrors.CodeInvalidValue, "%v", err))
+		return
+	}
+
+	model := defaultModerationModel
+	if request.Model != "" && h.config.GetModel(request.Model) != nil {
+		model = strings.TrimPrefix(request.Model, "models/")
+	}
+	if err := checkModelAllowed(h.config, model); err != nil {
+		apierrors.JSON(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	log.Printf("Moderation request: model=%s, inputs=%d", model, len(inputs))
+
+	results := make([]models.OpenAIModerationResult, 0, len(inputs))
+	for _, input := range inputs {
+		geminiResponse, err := h.googleClient.EvaluateSafety(c.Request.Context(), model, input)
+		if err != nil {
+			log.Printf("Moderation failed: %v", err)
+			apierrors.JSON(c, upstreamErrorStatus(err), "Moderation failed: "+err.Error())
+			return
+		}
+		results = append(results, transformers.GeminiSafetyToModeration(geminiResponse))
+	}
+
+	c.JSON(http.StatusOK, models.OpenAIModerationResponse{
+		ID:      "modr-" + uuid.New().String(),
+		Model:   model,
+		Results: results,
+	})
+}
+
+// moderationInputs returns the texts to moderate from a string, a list of
+// strings or a list of text content parts
+func moderationInputs(input interface{}) ([]string, error) {
+	var inputs []string
+	switch input := input.(type) {
+	case string:
+		inputs = []string{input}
+	case []interface{}:
+		for _, item := range input {
+			switch item := item.(type) {
+			case string:
+				inputs = append(inputs, item)
+			case map[string]interface{}:
+				if itemType, _ := item["type"].(string); itemType != "text" {
+					return nil, fmt.Errorf("only text inputs can be moderated, got %q", itemType)
+				}
+				text, _ := item["text"].(string)
+				inputs = append(inputs, text)
+			default:
+				return nil, fmt.Errorf("input items must be strings or text objects")
+			}
+		}
+	default:
+		return nil, fmt.Errorf("input must be a string or a list of strings")
+	}
+
+	if len(inputs) == 0 || len(inputs) > maxModerationInputs {
+		return nil, fmt.Errorf("input must contain between 1 and %d items", maxModerationInputs)
+	}
+	for _, text := range inputs {
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("input must not contain empty text")
+		}
+	}
+	return inputs, nil
+}
@@ -1,44 +1,71 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 
+	"geminicli2api/pkg/accesslog"
 	"geminicli2api/pkg/auth"
 	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/continuation"
+	apierrors "geminicli2api/pkg/errors"
 	"geminicli2api/pkg/google"
 	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/templates"
+	"geminicli2api/pkg/timing"
 	"geminicli2api/pkg/transformers"
 )
 
+// continuationEvery is how many content chunks pass between continuation tokens
+const continuationEvery = 10
+
+// continueInstruction asks the model to carry on after a resumed partial answer
+const continueInstruction = "Your previous response was cut off. Continue exactly where it stopped, without repeating anything or adding any preamble."
+
+// UnsupportedParamsHeader lists request parameters that were ignored because
+// Gemini has no equivalent
+const UnsupportedParamsHeader = "X-Unsupported-Params"
+
 // OpenAIHandler handles OpenAI-compatible endpoints
 type OpenAIHandler struct {
 	authConfig *auth.AuthConfig
 	googleClient *google.Client
 	config      *config.Config
+	continuations *continuation.Store // nil unless STREAM_CONTINUATION is enabled
+	templates   *templates.Store    // nil unless PROMPT_TEMPLATE_DIR is set
 }
 
 // NewOpenAIHandler creates a new OpenAI handler
-func NewOpenAIHandler(authConfig *auth.AuthConfig, googleClient *google.Client, cfg *config.Config) *OpenAIHandler {
-	return &OpenAIHandler{
+func NewOpenAIHandler(authConfig *auth.AuthConfig, googleClient *google.Client, cfg *config.Config, promptTemplates *templates.Store) *OpenAIHandler {
+	handler := &OpenAIHandler{
 		authConfig:  authConfig,
 		googleClient: googleClient,
 		config:      cfg,
+		templates:   promptTemplates,
 	}
+	if cfg.StreamContinuation {
+		handler.continuations = continuation.NewStore(cfg.StreamContinuationTTL)
+	}
+	return handler
 }
 
 // RegisterRoutes registers OpenAI-compatible routes
-func (h *OpenAIHandler) RegisterRoutes(router *gin.Engine) {
+func (h *OpenAIHandler) RegisterRoutes(router gin.IRouter) {
 	openai := router.Group("/v1")
 	{
 		openai.POST("/chat/completions", h.AuthMiddleware(), h.ChatCompletions)
 		openai.GET("/models", h.AuthMiddleware(), h.ListModels)
+		openai.POST("/compare", h.AuthMiddleware(), h.Compare)
+		openai.POST("/moderations", h.AuthMiddleware(), h.Moderations)
 	}
 }
 
@@ -47,14 +74,7 @@ func (h *OpenAIHandler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username, err := h.authConfig.AuthenticateUser(c.Request)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": gin.H{
-					"message": err.Error(),
-					"type":    "authentication_error",
-					"code":    http.StatusUnauthorized,
-				},
-			})
-			c.Abort()
+			apierrors.AbortWithJSON(c, http.StatusUnauthorized, err.Error())
 			return
 		}
 		c.Set("username", username)
@@ -66,85 +86,316 @@ func (h *OpenAIHandler) AuthMiddleware() gin.HandlerFunc {
 func (h *OpenAIHandler) ChatCompletions(c *gin.Context) {
 	var request models.OpenAIChatCompletionRequest
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "Invalid request format: " + err.Error(),
-				"type":    "invalid_request_error",
-				"code":    http.StatusBadRequest,
-			},
-		})
+		apierrors.JSONError(c, http.StatusBadRequest, bindError(err))
+		return
+	}
+
+	// The override header takes precedence over the body's model
+	override, err := modelOverride(c, h.config)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if override != "" {
+		request.Model = override
+	}
+	if err := checkModelAllowed(h.config, request.Model); err != nil {
+		apierrors.JSON(c, http.StatusForbidden, err.Error())
 		return
 	}
 
+	// Query parameters fill in, or with ?force=1 replace, generation parameters
+	queryParams, err := parseQueryOverrides(c, request.Model)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	if queryParams != nil {
+		queryParams.applyOpenAI(&request)
+	}
+
 	log.Printf("OpenAI chat completion request: model=%s, stream=%v", request.Model, request.Stream)
+	if request.Store != nil || len(request.Metadata) > 0 {
+		// store is acknowledged but nothing is persisted; metadata is for correlation
+		log.Printf("OpenAI chat completion request tags: store=%v, metadata=%v", request.Store != nil && *request.Store, request.Metadata)
+	}
+	accesslog.SetModel(c, request.Model)
+	accesslog.SetMetadata(c, request.Metadata)
+	if params := transformers.UnsupportedParams(&request, h.config); len(params) > 0 {
+		log.Printf("Ignoring unsupported parameters: %s", strings.Join(params, ", "))
+		c.Header(UnsupportedParamsHeader, strings.Join(params, ", "))
+	}
+
+	// A server-side prompt template expands into messages ahead of the client's
+	if request.Template != "" {
+		if h.templates == nil {
+			apierrors.JSONError(c, http.StatusBadRequest, apierrors.InvalidParam("template", apierrors.CodeUnsupportedValue, "prompt templates are not enabled; set PROMPT_TEMPLATE_DIR"))
+			return
+		}
+		expanded, err := h.templates.Expand(request.Template, request.Variables)
+		if err != nil {
+			apierrors.JSONError(c, http.StatusBadRequest, err)
+			return
+		}
+		request.Messages = append(expanded, request.Messages...)
+	}
+
+	// Resuming an interrupted stream replays its text as assistant context
+	resumed, err := h.resumeStream(c, &request)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Decide whether a blocked request is answered with an error or an empty completion
+	blockMode, err := safetyBlockMode(c, h.config, &request)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Bound the whole request by the client's or the default timeout
+	cancel, err := applyRequestTimeout(c, h.config, request.Timeout)
+	if err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
+	defer cancel()
+
+	// Collect per-phase timings for the Server-Timing header
+	ctx, timings := timing.WithTimings(c.Request.Context())
+	c.Request = c.Request.WithContext(ctx)
+	transformStart := time.Now()
 
 	// Transform OpenAI request to Gemini format
-	geminiRequestData, err := transformers.OpenAIRequestToGemini(&request)
+	geminiRequestData, err := transformers.OpenAIRequestToGemini(&request, h.config)
 	if err != nil {
 		log.Printf("Error processing OpenAI request: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": gin.H{
-				"message": "Request processing failed: " + err.Error(),
-				"type":    "invalid_request_error",
-				"code":    http.StatusBadRequest,
-			},
-		})
+		apierrors.JSONError(c, http.StatusBadRequest, fmt.Errorf("Request processing failed: %w", err))
+		return
+	}
+
+	// Reject requests the model can't serve before hitting the upstream
+	method := "generateContent"
+	if request.Stream {
+		method = "streamGenerateContent"
+	}
+	if err := google.CheckModelCapabilities(h.config, request.Model, method, geminiRequestData); err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
 		return
 	}
 
 	// Build the payload for Google API
 	geminiPayload := h.googleClient.BuildGeminiPayloadFromOpenAI(geminiRequestData)
+	if queryParams != nil {
+		queryParams.applyPayload(geminiPayload, h.config)
+	}
+	timings.Track("transform", transformStart)
+
+	// Optionally reject prompts over the model's input limit up front
+	if err := h.googleClient.CheckInputTokenLimit(c.Request.Context(), request.Model, geminiPayload); err != nil {
+		apierrors.JSONError(c, http.StatusBadRequest, err)
+		return
+	}
 
 	if request.Stream {
-		h.handleStreamingResponse(c, &request, geminiPayload)
+		h.handleStreamingResponse(c, &request, geminiPayload, resumed, blockMode)
 	} else {
-		h.handleNonStreamingResponse(c, &request, geminiPayload)
+		h.handleNonStreamingResponse(c, &request, geminiPayload, blockMode)
 	}
 }
 
 // handleStreamingResponse handles streaming responses
-func (h *OpenAIHandler) handleStreamingResponse(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}) {
-	responseID := fmt.Sprintf("chatcmpl-%s", uuid.New().String())
+func (h *OpenAIHandler) handleStreamingResponse(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}, resumed string, blockMode string) {
+	responseID := fmt.Sprintf("chatcmpl-%s", responseUUID(c).String())
 	log.Printf("Starting streaming response: %s", responseID)
 
-	// Set SSE headers
-	c.Header("Content-Type", "text/event-stream")
-	c.Header("Cache-Control", "no-cache")
-	c.Header("Connection", "keep-alive")
-	c.Header("Access-Control-Allow-Origin", "*")
-
-	// Send response
-	resp, err := h.googleClient.SendGeminiRequest(c.Request.Context(), geminiPayload, true)
+	// Send response, cancellable if the stream runs past MAX_STREAM_DURATION
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	resp, chunks, err := h.googleClient.SendStreamRequest(ctx, geminiPayload)
 	if err != nil {
+		// Nothing has been written yet, so return a regular JSON error
 		log.Printf("Streaming request failed: %v", err)
-		h.sendStreamingError(c, "Streaming request failed: "+err.Error(), http.StatusInternalServerError)
+		apierrors.JSON(c, upstreamErrorStatus(err), "Streaming request failed: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
+	setTimingHeaders(c, resp)
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Google API returned status %d", resp.StatusCode)
-		h.handleStreamingErrorResponse(c, resp)
+		h.handleNonStreamingErrorResponse(c, resp)
 		return
 	}
 
-	// Stream the response
-	ch := h.googleClient.StreamResponse(resp)
-	for chunk := range ch {
-		_, err := c.Writer.Write(chunk)
-		if err != nil {
-			log.Printf("Error writing chunk: %v", err)
+	// Only commit stream headers once the upstream stream is established.
+	// Clients that can't consume SSE may ask for one JSON chunk per line.
+	contentType := "text/event-stream"
+	if acceptsNDJSON(c) {
+		c.Set(ndjsonKey, true)
+		contentType = ndjsonContentType
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("Access-Control-Allow-Origin", "*")
+	c.Status(http.StatusOK)
+
+	// Optionally batch flushes of small chunks
+	defer batchStreamFlushes(c, h.config)()
+
+	// Report time to first byte as an SSE comment, which clients ignore
+	if serverTiming := timing.FromContext(c.Request.Context()).Header(); serverTiming != "" {
+		writeStreamComment(c, ": server-timing "+serverTiming+"\n\n")
+		c.Writer.Flush()
+	}
+
+	// In JSON mode, optionally accumulate content per choice to validate at the end
+	var jsonContent map[int]*strings.Builder
+	if validateStreamedJSON(request) {
+		jsonContent = make(map[int]*strings.Builder)
+	}
+
+	// Track the first choice's text so an interrupted stream can be resumed
+	var stream *continuation.Stream
+	if h.continuations != nil && (request.N == nil || *request.N <= 1) {
+		stream = h.continuations.Start(request.Model, c.GetString("username"), resumed)
+	}
+	contentChunks := 0
+
+	// Stream the response, translating each Gemini chunk into OpenAI chunks
+	transformer := transformers.NewStreamTransformer(request.Model, responseID, transformers.SystemFingerprint(request.Model, request.Seed))
+	if !transformers.ParallelToolCallsEnabled(request) {
+		transformer.SingleToolCall()
+	}
+	if h.config.StripThinking {
+		transformer.DropReasoning()
+	}
+	if h.config.ReportResolvedModel {
+		transformer.ReportResolvedModel()
+	}
+	transformer.EchoServiceTier(transformers.ServiceTier(request))
+	transformer.SeparateTextParts(h.config.TextPartSeparator())
+	if h.config.IncludeGroundingCitations {
+		transformer.IncludeCitations()
+	}
+	validator := h.functionCallValidator(request)
+	latency := newStreamLatency(c)
+	defer latency.Finish(responseID)
+	contentSent := false
+	keepAlive := newKeepAlive(h.config)
+	defer keepAlive.Stop()
+	for chunk, ok := keepAlive.Next(c, chunks); ok; chunk, ok = keepAlive.Next(c, chunks) {
+		if chunk.Err != nil {
+			h.sendStreamingError(c, "Streaming error: "+chunk.Err.Error(), http.StatusInternalServerError)
 			return
 		}
-		c.Writer.Flush()
+
+		// A blocked prompt, or a response blocked before any content was sent,
+		// ends the stream with an error or an empty content_filter completion
+		details := google.PromptBlockDetails(chunk.Data)
+		if details == nil && !contentSent {
+			details = google.BlockDetails(chunk.Data)
+		}
+		if details != nil {
+			log.Printf("Gemini blocked the request: %s", google.BlockMessage(details))
+			if blockMode == config.SafetyBlockError {
+				writeStreamingError(c, google.BlockError(details))
+				return
+			}
+			if details.BlockReason != "" {
+				finishReason := "content_filter"
+				filtered := models.NewOpenAIChatCompletionStreamResponse(responseID, request.Model, transformers.SystemFingerprint(request.Model, request.Seed), []*models.OpenAIChatCompletionStreamChoice{
+					models.NewOpenAIChatCompletionStreamChoice(0, models.OpenAIDelta{}, &finishReason),
+				})
+				filtered.ServiceTier = transformers.ServiceTier(request)
+				if err := writeSSEData(c, filtered); err != nil {
+					log.Printf("Error writing chunk: %v", err)
+					return
+				}
+				break
+			}
+		}
+		if validator != nil {
+			// Streams can't be retried, so invalid calls are always dropped
+			if problems := validator.Enforce(chunk.Data); len(problems) > 0 {
+				log.Printf("Dropping invalid function calls: %s", strings.Join(problems, "; "))
+			}
+		}
+		latency.Observe(chunk.Data)
+		if _, content := chunkKinds(chunk.Data); content {
+			contentSent = true
+		}
+
+		accesslog.RecordUsage(c, chunk.Data)
+		for _, openaiChunk := range transformer.Transform(chunk.Data) {
+			if jsonContent != nil {
+				for _, choice := range openaiChunk.Choices {
+					if choice.Delta.Content == nil {
+						continue
+					}
+					if jsonContent[choice.Index] == nil {
+						jsonContent[choice.Index] = &strings.Builder{}
+					}
+					jsonContent[choice.Index].WriteString(*choice.Delta.Content)
+				}
+			}
+			if stream != nil {
+				for _, choice := range openaiChunk.Choices {
+					if choice.Index == 0 && choice.Delta.Content != nil && *choice.Delta.Content != "" {
+						stream.Append(*choice.Delta.Content)
+						if contentChunks%continuationEvery == 0 {
+							openaiChunk.ContinuationToken = stream.Token()
+						}
+						contentChunks++
+					}
+				}
+			}
+			if err := writeSSEData(c, openaiChunk); err != nil {
+				log.Printf("Error writing chunk: %v", err)
+				return
+			}
+		}
 	}
 
-	// Send final marker
-	finalChunk := []byte("data: [DONE]\n\n")
-	_, err = c.Writer.Write(finalChunk)
-	if err != nil {
-		log.Printf("Error writing final chunk: %v", err)
-		return
+	// Cut off a stream that ran too long, finishing open choices with length
+	if keepAlive.Expired() {
+		cancel()
+		log.Printf("Stream %s reached MAX_STREAM_DURATION of %v, ending it", responseID, h.config.MaxStreamDuration)
+		for _, openaiChunk := range transformer.Truncate() {
+			if err := writeSSEData(c, openaiChunk); err != nil {
+				log.Printf("Error writing chunk: %v", err)
+				return
+			}
+		}
+	}
+
+	// Check the accumulated JSON once the stream is complete. A truncated
+	// stream's JSON is incomplete by design, so it isn't checked.
+	if jsonContent != nil && !keepAlive.Expired() && c.Request.Context().Err() == nil {
+		for index, content := range jsonContent {
+			if !json.Valid([]byte(content.String())) {
+				log.Printf("Streamed JSON for choice %d is malformed (%d bytes)", index, content.Len())
+				h.sendStreamingError(c, fmt.Sprintf("Model returned malformed JSON for choice %d", index), http.StatusBadGateway)
+				return
+			}
+		}
+	}
+
+	// Report streaming latency as an SSE comment before the final marker
+	if comment := latency.Comment(); comment != "" {
+		writeStreamComment(c, comment)
+	}
+
+	// Send final marker; newline-delimited JSON streams simply end
+	if !streamNDJSON(c) {
+		finalChunk := []byte("data: [DONE]\n\n")
+		_, err = c.Writer.Write(finalChunk)
+		if err != nil {
+			log.Printf("Error writing final chunk: %v", err)
+			return
+		}
 	}
 	c.Writer.Flush()
 
@@ -152,23 +403,18 @@ func (h *OpenAIHandler) handleStreamingResponse(c *gin.Context, request *models.
 }
 
 // handleNonStreamingResponse handles non-streaming responses
-func (h *OpenAIHandler) handleNonStreamingResponse(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}) {
+func (h *OpenAIHandler) handleNonStreamingResponse(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}, blockMode string) {
 	resp, err := h.googleClient.SendGeminiRequest(c.Request.Context(), geminiPayload, false)
 	if err != nil {
 		log.Printf("Non-streaming request failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Request failed: " + err.Error(),
-				"type":    "api_error",
-				"code":    http.StatusInternalServerError,
-			},
-		})
+		apierrors.JSON(c, upstreamErrorStatus(err), "Request failed: "+err.Error())
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		log.Printf("Google API returned status %d", resp.StatusCode)
+		setTimingHeaders(c, resp)
 		h.handleNonStreamingErrorResponse(c, resp)
 		return
 	}
@@ -177,34 +423,68 @@ func (h *OpenAIHandler) handleNonStreamingResponse(c *gin.Context, request *mode
 	var geminiResponse map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&geminiResponse); err != nil {
 		log.Printf("Failed to parse Gemini response: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": gin.H{
-				"message": "Failed to process response: " + err.Error(),
-				"type":    "api_error",
-				"code":    http.StatusInternalServerError,
-			},
-		})
+		apierrors.JSON(c, http.StatusInternalServerError, "Failed to process response: "+err.Error())
 		return
 	}
 
-	openaiResponse := transformers.GeminiResponseToOpenAI(geminiResponse, request.Model)
-	log.Printf("Successfully processed non-streaming response for model: %s", request.Model)
-
-	c.JSON(http.StatusOK, openaiResponse)
-}
+	// Run the proxy's built-in tools until Gemini gives a final answer, logging
+	// the usage of every round
+	geminiResponse, geminiPayload, tokens, err := h.runBuiltinTools(c, request, geminiPayload, geminiResponse)
+	accesslog.AddTokens(c, tokens)
+	if err != nil {
+		log.Printf("Built-in tool loop failed: %v", err)
+		apierrors.JSON(c, upstreamErrorStatus(err), "Request failed: "+err.Error())
+		return
+	}
 
-// handleStreamingErrorResponse handles error responses in streaming mode
-func (h *OpenAIHandler) handleStreamingErrorResponse(c *gin.Context, resp *http.Response) {
-	// Try to parse error response
-	var errorData map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&errorData); err == nil {
-		if error, ok := errorData["error"].(map[string]interface{}); ok {
-			h.sendStreamingError(c, error["message"].(string), resp.StatusCode)
+	// Explain a blocked prompt or response, unless the client prefers an empty completion
+	details := google.BlockDetails(geminiResponse)
+	if details != nil {
+		log.Printf("Gemini blocked the request: %s", google.BlockMessage(details))
+		if blockMode == config.SafetyBlockError {
+			setTimingHeaders(c, resp)
+			c.JSON(http.StatusBadRequest, google.BlockError(details))
 			return
 		}
 	}
 
-	h.sendStreamingError(c, fmt.Sprintf("API error: %d", resp.StatusCode), resp.StatusCode)
+	// Check returned function calls against the declared tools
+	if validator := h.functionCallValidator(request); validator != nil && details == nil {
+		geminiResponse = h.validateFunctionCalls(c, request, validator, geminiPayload, geminiResponse)
+	}
+
+	transformStart := time.Now()
+	openaiResponse := transformers.GeminiResponseToOpenAI(geminiResponse, request.Model, transformers.SystemFingerprint(request.Model, request.Seed), h.config.TextPartSeparator())
+	openaiResponse.ID = responseUUID(c).String()
+	openaiResponse.ServiceTier = transformers.ServiceTier(request)
+	if h.config.ReportResolvedModel {
+		openaiResponse.Model = openaiResponse.ResolvedModel
+	}
+	if details != nil {
+		transformers.MarkContentFiltered(openaiResponse)
+	}
+	if !transformers.ParallelToolCallsEnabled(request) {
+		transformers.KeepFirstToolCall(openaiResponse)
+	}
+	if h.config.StripThinking {
+		transformers.DropReasoningContent(openaiResponse)
+	}
+	if h.config.IncludeGroundingCitations {
+		transformers.AddGroundingCitations(openaiResponse, geminiResponse)
+	}
+	if h.config.ResponseContentFormat == "array" {
+		transformers.UseContentArray(openaiResponse)
+	}
+	timing.FromContext(c.Request.Context()).Track("transform", transformStart)
+	setTimingHeaders(c, resp)
+
+	// Attach the untranslated response for debugging when asked
+	if includeRawGemini(c, request) {
+		openaiResponse.RawGemini = geminiResponse
+	}
+	log.Printf("Successfully processed non-streaming response for model: %s", request.Model)
+
+	c.JSON(http.StatusOK, openaiResponse)
 }
 
 // handleNonStreamingErrorResponse handles error responses in non-streaming mode
@@ -213,48 +493,180 @@ func (h *OpenAIHandler) handleNonStreamingErrorResponse(c *gin.Context, resp *ht
 	var errorData map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&errorData); err == nil {
 		if error, ok := errorData["error"].(map[string]interface{}); ok {
-			c.JSON(resp.StatusCode, gin.H{
-				"error": gin.H{
-					"message": error["message"],
-					"type":    error["type"],
-					"code":    error["code"],
-				},
-			})
-			return
+			if message, ok := error["message"].(string); ok {
+				apierrors.JSON(c, resp.StatusCode, message)
+				return
+			}
 		}
 	}
 
 	// Fallback error response
-	errorType := "invalid_request_error"
-	if resp.StatusCode != http.StatusNotFound {
-		errorType = "api_error"
+	apierrors.JSON(c, resp.StatusCode, fmt.Sprintf("API error: %d", resp.StatusCode))
+}
+
+// statusClientClosedRequest is the nginx convention for a request the client
+// abandoned before the response was ready
+const statusClientClosedRequest = 499
+
+// upstreamErrorStatus maps a failure to reach the upstream to an HTTP status. Missing
+// credentials are a 503 so clients know the server isn't ready rather than broken,
+// while credentials that fail to load or refresh are a 401.
+func upstreamErrorStatus(err error) int {
+	if errors.Is(err, auth.ErrNoCredentials) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, google.ErrAuthentication) {
+		return http.StatusUnauthorized
 	}
+	if errors.Is(err, context.Canceled) {
+		return statusClientClosedRequest
+	}
+	if errors.Is(err, google.ErrUpstreamConnection) {
+		return http.StatusBadGateway
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	if errors.Is(err, google.ErrQuotaHold) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusInternalServerError
+}
 
-	c.JSON(resp.StatusCode, gin.H{
-		"error": gin.H{
-			"message": fmt.Sprintf("API error: %d", resp.StatusCode),
-			"type":    errorType,
-			"code":    resp.StatusCode,
-		},
-	})
+// validateStreamedJSON reports whether a JSON mode stream should be checked for
+// well-formed JSON at the end, which clients opt in to via extra_body.validate_json
+func validateStreamedJSON(request *models.OpenAIChatCompletionRequest) bool {
+	formatType, _ := request.ResponseFormat["type"].(string)
+	validate, _ := request.ExtraBody["validate_json"].(bool)
+	return formatType == "json_object" && validate
 }
 
-// sendStreamingError sends an error in streaming format
-func (h *OpenAIHandler) sendStreamingError(c *gin.Context, message string, code int) {
-	errorType := "invalid_request_error"
-	if code != http.StatusNotFound {
-		errorType = "api_error"
+// splitFilter splits a comma-separated query filter into its non-empty values
+func splitFilter(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// modelMatchesFilters reports whether a model has every capability and method
+func modelMatchesFilters(model *config.Model, capabilities []string, methods []string) bool {
+	for _, capability := range capabilities {
+		if !model.HasCapability(capability) {
+			return false
+		}
+	}
+	for _, method := range methods {
+		if !model.SupportsMethod(method) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
 	}
+	return false
+}
 
-	errorData := gin.H{
-		"error": gin.H{
-			"message": message,
-			"type":    errorType,
-			"code":    code,
-		},
+// includeRawGemini reports whether the client opted in to the untranslated Gemini
+// response, via the X-Include-Raw-Gemini header or extra_body.include_raw
+func includeRawGemini(c *gin.Context, request *models.OpenAIChatCompletionRequest) bool {
+	if include, err := strconv.ParseBool(c.GetHeader("X-Include-Raw-Gemini")); err == nil && include {
+		return true
 	}
+	include, _ := request.ExtraBody["include_raw"].(bool)
+	return include
+}
 
-	if errorJSON, err := json.Marshal(errorData); err == nil {
+// setTimingHeaders reports the upstream status and per-phase timings collected so far
+func setTimingHeaders(c *gin.Context, resp *http.Response) {
+	c.Header("X-Upstream-Status", strconv.Itoa(resp.StatusCode))
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+		c.Header("Retry-After", retryAfter)
+	}
+	if serverTiming := timing.FromContext(c.Request.Context()).Header(); serverTiming != "" {
+		c.Header("Server-Timing", serverTiming)
+	}
+}
+
+// bindError describes a request body that couldn't be decoded, naming the
+// field when a value has the wrong JSON type
+func bindError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return apierrors.InvalidParam(typeErr.Field, apierrors.CodeInvalidType, "Invalid request format: %s must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return fmt.Errorf("Invalid request format: %w", err)
+}
+
+// writeSSEData writes a value as an SSE data line, or as a single line on
+// newline-delimited JSON streams, and flushes it
+func writeSSEData(c *gin.Context, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	line := fmt.Sprintf("data: %s\n\n", string(data))
+	if streamNDJSON(c) {
+		line = string(data) + "\n"
+	}
+	if _, err := c.Writer.Write([]byte(line)); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
+// resumeStream handles extra_body.continuation_token. It appends the text
+// delivered before the interruption and a continue instruction to the
+// conversation, and returns that text so the new stream's tokens cover it.
+func (h *OpenAIHandler) resumeStream(c *gin.Context, request *models.OpenAIChatCompletionRequest) (string, error) {
+	token, _ := request.ExtraBody["continuation_token"].(string)
+	if token == "" {
+		return "", nil
+	}
+	if h.continuations == nil {
+		return "", apierrors.InvalidParam("extra_body.continuation_token", apierrors.CodeUnsupportedValue, "stream continuation is not enabled")
+	}
+	if !request.Stream {
+		return "", apierrors.InvalidParam("extra_body.continuation_token", apierrors.CodeInvalidValue, "continuation_token requires a streaming request")
+	}
+
+	resumed, err := h.continuations.Resume(token, request.Model, c.GetString("username"))
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Resuming stream after %d bytes", len(resumed))
+	if resumed != "" {
+		request.Messages = append(request.Messages,
+			models.OpenAIChatMessage{Role: "assistant", Content: resumed},
+			models.OpenAIChatMessage{Role: "user", Content: continueInstruction},
+		)
+	}
+	return resumed, nil
+}
+
+// sendStreamingError sends an error in streaming format
+func (h *OpenAIHandler) sendStreamingError(c *gin.Context, message string, code int) {
+	writeStreamingError(c, apierrors.New(code, message))
+}
+
+// writeStreamingError writes an error envelope as the final streamed chunk
+func writeStreamingError(c *gin.Context, errorResponse apierrors.Response) {
+	if errorJSON, err := json.Marshal(errorResponse); err == nil {
+		if streamNDJSON(c) {
+			c.Writer.Write(append(errorJSON, '\n'))
+			c.Writer.Flush()
+			return
+		}
 		c.Writer.Write([]byte(fmt.Sprintf("data: %s\n\n", string(errorJSON))))
 		c.Writer.Write([]byte("data: [DONE]\n\n"))
 		c.Writer.Flush()
@@ -265,8 +677,22 @@ func (h *OpenAIHandler) sendStreamingError(c *gin.Context, message string, code
 func (h *OpenAIHandler) ListModels(c *gin.Context) {
 	log.Printf("OpenAI models list requested")
 
+	// Optional filters; every listed capability and method must be supported
+	capabilities := splitFilter(c.Query("capability"))
+	methods := splitFilter(c.Query("supports"))
+	for _, capability := range capabilities {
+		if !containsString(config.ModelCapabilities, capability) {
+			apierrors.JSON(c, http.StatusBadRequest, fmt.Sprintf("Unknown capability %q (supported: %s)", capability, strings.Join(config.ModelCapabilities, ", ")))
+			return
+		}
+	}
+
 	openaiModels := []gin.H{}
-	for _, model := range h.config.SupportedModels {
+	for _, model := range h.config.AvailableModels() {
+		if !modelMatchesFilters(&model, capabilities, methods) {
+			continue
+		}
+
 		// Remove "models/" prefix for OpenAI compatibility
 		modelID := model.Name
 		if strings.HasPrefix(modelID, "models/") {
@@ -279,7 +705,7 @@ func (h *OpenAIHandler) ListModels(c *gin.Context) {
 			"created":                1677610602,
 			"allow_create_engine":    false,
 			"allow_sampling":         true,
-			"allow_logprobs":         false,
+			"allow_logprobs":         true,
 			"allow_search_indices":   false,
 			"allow_view":             true,
 			"allow_fine_tuning":      false,
@@ -0,0 +1,637 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"geminicli2api/pkg/auth"
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/models"
+)
+
+const streamRequest = `{"model": "gemini-2.5-flash", "stream": true, "messages": [{"role": "user", "content": "Hi"}]}`
+
+func TestStreamUpstreamErrorBeforeStreaming(t *testing.T) {
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED"}}`)
+	})
+	router := newOpenAIRouter(newTestConfig(t, upstream))
+
+	w := postJSON(router, "/v1/chat/completions", streamRequest)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		t.Errorf("Content-Type = %q, want JSON rather than an event stream", contentType)
+	}
+	var errorResponse apierrors.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("body is not a JSON error: %v\n%s", err, w.Body)
+	}
+	if errorResponse.Error.Message != "Quota exceeded" || errorResponse.Error.Type != apierrors.TypeRateLimit {
+		t.Errorf("error = %+v", errorResponse.Error)
+	}
+}
+
+func TestStreamUpstreamErrorMidStream(t *testing.T) {
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}]}}]}`)
+		// Drop the connection part way through the chunked body
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		conn.Close()
+	})
+	router := newOpenAIRouter(newTestConfig(t, upstream))
+
+	w := postJSON(router, "/v1/chat/completions", streamRequest)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 once streaming started", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", contentType)
+	}
+	data := sseData(w.Body.String())
+	if len(data) < 3 {
+		t.Fatalf("got %d data lines, want content, an error and [DONE]:\n%s", len(data), w.Body)
+	}
+	if !strings.Contains(data[0], `"content":"Hel"`) {
+		t.Errorf("first chunk = %s, want the content sent before the failure", data[0])
+	}
+	var errorResponse apierrors.Response
+	if err := json.Unmarshal([]byte(data[len(data)-2]), &errorResponse); err != nil || errorResponse.Error.Message == "" {
+		t.Errorf("last chunk before [DONE] = %s, want an error object", data[len(data)-2])
+	}
+	if data[len(data)-1] != "[DONE]" {
+		t.Errorf("stream ended with %s, want [DONE]", data[len(data)-1])
+	}
+}
+
+func TestChatCompletionsRejectsNAboveLimit(t *testing.T) {
+	cfg := newTestConfig(t, nil)
+	cfg.MaxCandidateCount = 4
+	router := newOpenAIRouter(cfg)
+
+	w := postJSON(router, "/v1/chat/completions", `{"model": "gemini-2.5-flash", "n": 5, "messages": [{"role": "user", "content": "Hi"}]}`)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	var errorResponse apierrors.Response
+	if err := json.Unmarshal(w.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("body is not a JSON error: %v", err)
+	}
+	if !strings.Contains(errorResponse.Error.Message, "between 1 and 4") {
+		t.Errorf("message = %q, want it to name the limit of 4", errorResponse.Error.Message)
+	}
+	if errorResponse.Error.Param == nil || *errorResponse.Error.Param != "n" {
+		t.Errorf("param = %v, want n", errorResponse.Error.Param)
+	}
+}
+
+func TestStreamEmitsChatCompletionChunks(t *testing.T) {
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w,
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Considering", "thought": true}]}}]}`,
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}}]}`,
+			`{"candidates": [{"content": {"role": "model", "parts": [{"text": " there"}]}, "finishReason": "STOP"}]}`,
+		)
+	})
+	router := newOpenAIRouter(newTestConfig(t, upstream))
+
+	w := postJSON(router, "/v1/chat/completions", streamRequest)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	data := sseData(w.Body.String())
+	if len(data) < 2 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("stream = %q, want chunks followed by [DONE]", data)
+	}
+
+	var id, content string
+	for _, line := range data[:len(data)-1] {
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields()
+		var chunk models.OpenAIChatCompletionStreamResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			t.Fatalf("data line is not a chat.completion.chunk: %v\n%s", err, line)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("object = %q, want chat.completion.chunk", chunk.Object)
+		}
+		if chunk.Model != "gemini-2.5-flash" || len(chunk.Choices) != 1 {
+			t.Errorf("chunk = %s, want one choice for gemini-2.5-flash", line)
+			continue
+		}
+		if id == "" {
+			id = chunk.ID
+		} else if chunk.ID != id {
+			t.Errorf("chunk id = %q, want every chunk to share %q", chunk.ID, id)
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != nil {
+			content += *delta
+		}
+	}
+	if content != "Hello there" {
+		t.Errorf("streamed content = %q, want %q", content, "Hello there")
+	}
+}
+
+func TestUpstreamErrorStatusWithoutCredentials(t *testing.T) {
+	// Headless servers start without credentials and report they aren't ready
+	err := fmt.Errorf("authenticate: %w", auth.ErrNoCredentials)
+	if got := upstreamErrorStatus(err); got != http.StatusServiceUnavailable {
+		t.Errorf("upstreamErrorStatus() = %d, want 503", got)
+	}
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"client cancelled", fmt.Errorf("sending request: %w", context.Canceled), statusClientClosedRequest},
+		{"credentials fail to load", fmt.Errorf("%w: %w", google.ErrAuthentication, errors.New("invalid credentials file")), http.StatusUnauthorized},
+		{"refresh fails", fmt.Errorf("%w: token refresh failed: %w", google.ErrAuthentication, errors.New("invalid_grant")), http.StatusUnauthorized},
+		{"connection fails", fmt.Errorf("%w: dial tcp: refused", google.ErrUpstreamConnection), http.StatusBadGateway},
+		{"deadline", fmt.Errorf("sending request: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{"other", errors.New("unexpected"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := upstreamErrorStatus(tt.err); got != tt.want {
+				t.Errorf("upstreamErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListModelsCapabilityFilters(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, nil))
+
+	listModels := func(t *testing.T, query string) map[string]bool {
+		t.Helper()
+		w := get(router, "/v1/models"+query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+		}
+		var list struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+			t.Fatalf("invalid model list: %v", err)
+		}
+		ids := map[string]bool{}
+		for _, model := range list.Data {
+			ids[model.ID] = true
+		}
+		return ids
+	}
+
+	// Image generation models take images but not audio
+	all := listModels(t, "")
+	imageModels := 0
+	for id := range all {
+		if strings.Contains(id, "-image") {
+			imageModels++
+		}
+	}
+	if imageModels == 0 {
+		t.Fatal("unfiltered list has no image models")
+	}
+	withAudio := len(all) - imageModels
+
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"vision", "?capability=vision", len(all)},
+		{"audio excludes image models", "?capability=audio", withAudio},
+		{"every capability must match", "?capability=vision,audio", withAudio},
+		{"spaces and empty values ignored", "?capability=%20audio%20,,", withAudio},
+		{"method", "?supports=streamGenerateContent", len(all)},
+		{"capability and method", "?capability=audio&supports=generateContent", withAudio},
+		{"unsupported method", "?capability=vision&supports=embedContent", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids := listModels(t, tt.query)
+			if len(ids) != tt.want {
+				t.Errorf("got %d models, want %d", len(ids), tt.want)
+			}
+			for id := range ids {
+				if tt.want == withAudio && strings.Contains(id, "-image") {
+					t.Errorf("%s listed although it lacks audio", id)
+				}
+			}
+		})
+	}
+
+	t.Run("unknown capability", func(t *testing.T) {
+		w := get(router, "/v1/models?capability=smell")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "vision, audio") {
+			t.Errorf("got %d %s, want 400 listing the supported capabilities", w.Code, w.Body)
+		}
+	})
+}
+
+// captureUpstreamRequest serves one completion from a fake upstream and
+// returns the headers and JSON payload of the generate request it received
+func captureUpstreamRequest(t *testing.T, configure func(*config.Config)) (http.Header, map[string]interface{}) {
+	t.Helper()
+	var header http.Header
+	var payload map[string]interface{}
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"response": {"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}}`)
+	})
+	cfg := newTestConfig(t, upstream)
+	if configure != nil {
+		configure(cfg)
+	}
+
+	w := postJSON(newOpenAIRouter(cfg), "/v1/chat/completions", `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if header == nil {
+		t.Fatal("upstream received no generate request")
+	}
+	return header, payload
+}
+
+func TestUpstreamExtraHeaders(t *testing.T) {
+	header, _ := captureUpstreamRequest(t, func(cfg *config.Config) {
+		cfg.UpstreamExtraHeaders = map[string]string{"X-Request-Tag": "batch-7", "x-goog-request-reason": "testing"}
+	})
+
+	if got := header.Get("X-Request-Tag"); got != "batch-7" {
+		t.Errorf("X-Request-Tag = %q, want batch-7", got)
+	}
+	if got := header.Get("X-Goog-Request-Reason"); got != "testing" {
+		t.Errorf("x-goog-request-reason = %q, want testing", got)
+	}
+	if got := header.Get("Authorization"); got != "Bearer access" {
+		t.Errorf("Authorization = %q, want the access token", got)
+	}
+}
+
+func TestUpstreamUserProjectHeader(t *testing.T) {
+	header, payload := captureUpstreamRequest(t, func(*config.Config) {
+		t.Setenv("GOOGLE_CLOUD_PROJECT", "quota-project")
+	})
+
+	if got := header.Get("x-goog-user-project"); got != "quota-project" {
+		t.Errorf("x-goog-user-project = %q, want the resolved project quota-project", got)
+	}
+	if got := payload["project"]; got != "quota-project" {
+		t.Errorf("payload project = %v, want the header to match it", got)
+	}
+}
+
+func TestEnforceInputLimit(t *testing.T) {
+	tests := []struct {
+		name         string
+		countTokens  func(w http.ResponseWriter)
+		wantStatus   int
+		wantGenerate bool
+	}{
+		{
+			name: "over the limit",
+			countTokens: func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"totalTokens": 2000000}`)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "within the limit",
+			countTokens: func(w http.ResponseWriter) {
+				fmt.Fprint(w, `{"totalTokens": 12}`)
+			},
+			wantStatus:   http.StatusOK,
+			wantGenerate: true,
+		},
+		{
+			name: "count failure skips the check",
+			countTokens: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			wantStatus:   http.StatusOK,
+			wantGenerate: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generated := false
+			upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				if strings.HasSuffix(r.URL.Path, ":countTokens") {
+					tt.countTokens(w)
+					return
+				}
+				generated = true
+				fmt.Fprint(w, `{"response": {"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}}`)
+			})
+			cfg := newTestConfig(t, upstream)
+			cfg.EnforceInputLimit = true
+
+			w := postJSON(newOpenAIRouter(cfg), "/v1/chat/completions", `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if generated != tt.wantGenerate {
+				t.Errorf("generate called = %v, want %v", generated, tt.wantGenerate)
+			}
+			if tt.wantStatus == http.StatusBadRequest && !strings.Contains(w.Body.String(), "exceeds the 1048576 token input limit") {
+				t.Errorf("body = %s, want the limit named", w.Body)
+			}
+		})
+	}
+}
+
+func TestParallelToolCalls(t *testing.T) {
+	const response = `{"candidates": [{"content": {"role": "model", "parts": [
+		{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+		{"functionCall": {"name": "get_time", "args": {}}}
+	]}, "finishReason": "STOP"}]}`
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			writeSSE(w, response)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"response": %s}`, response)
+	})
+	router := newOpenAIRouter(newTestConfig(t, upstream))
+
+	tests := []struct {
+		name      string
+		setting   string
+		wantCalls int
+	}{
+		{"default", "", 2},
+		{"enabled", `"parallel_tool_calls": true,`, 2},
+		{"disabled", `"parallel_tool_calls": false,`, 1},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			name := fmt.Sprintf("%s stream=%v", tt.name, stream)
+			t.Run(name, func(t *testing.T) {
+				body := fmt.Sprintf(`{"model": "gemini-2.5-flash", "stream": %v, %s
+					"tools": [
+						{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}},
+						{"type": "function", "function": {"name": "get_time", "parameters": {"type": "object"}}}
+					],
+					"messages": [{"role": "user", "content": "Weather and time in Paris?"}]}`, stream, tt.setting)
+
+				w := postJSON(router, "/v1/chat/completions", body)
+
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+				}
+				var names []string
+				if stream {
+					for _, data := range sseData(w.Body.String()) {
+						var chunk models.OpenAIChatCompletionStreamResponse
+						if json.Unmarshal([]byte(data), &chunk) != nil || len(chunk.Choices) == 0 {
+							continue
+						}
+						for _, call := range chunk.Choices[0].Delta.ToolCalls {
+							names = append(names, call.Function.Name)
+						}
+					}
+				} else {
+					var completion models.OpenAIChatCompletionResponse
+					if err := json.Unmarshal(w.Body.Bytes(), &completion); err != nil || len(completion.Choices) != 1 {
+						t.Fatalf("invalid completion: %v\n%s", err, w.Body)
+					}
+					for _, call := range completion.Choices[0].Message.ToolCalls {
+						names = append(names, call.Function.Name)
+					}
+				}
+
+				if len(names) != tt.wantCalls || names[0] != "get_weather" {
+					t.Errorf("tool calls = %v, want the first %d", names, tt.wantCalls)
+				}
+			})
+		}
+	}
+}
+
+// newCompletionUpstream starts a fake upstream that answers every generate
+// request with a one-word completion, streamed when asked to
+func newCompletionUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	const response = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}, "finishReason": "STOP"}]}`
+	return newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			writeSSE(w, response)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"response": %s}`, response)
+	})
+}
+
+func TestUnsupportedParamsHeader(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, newCompletionUpstream(t)))
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "prediction",
+			body: `{"model": "gemini-2.5-flash", "prediction": {"type": "content", "content": "Hi"}, "messages": [{"role": "user", "content": "Hi"}]}`,
+			want: "prediction",
+		},
+		{
+			name: "prediction and penalties on a model without them",
+			body: `{"model": "gemini-2.5-pro", "prediction": {"type": "content", "content": "Hi"}, "presence_penalty": 0.5, "messages": [{"role": "user", "content": "Hi"}]}`,
+			want: "prediction, presence_penalty",
+		},
+		{
+			name: "nothing unsupported",
+			body: `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(router, "/v1/chat/completions", tt.body)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+			}
+			if got := w.Header().Get(UnsupportedParamsHeader); got != tt.want {
+				t.Errorf("%s = %q, want %q", UnsupportedParamsHeader, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceTierEcho(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, newCompletionUpstream(t)))
+
+	tests := []struct {
+		tier string
+		want string
+	}{
+		{"auto", "default"},
+		{"flex", "flex"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		for _, stream := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%q stream=%v", tt.tier, stream), func(t *testing.T) {
+				body := fmt.Sprintf(`{"model": "gemini-2.5-flash", "stream": %v, "service_tier": %q, "messages": [{"role": "user", "content": "Hi"}]}`, stream, tt.tier)
+				w := postJSON(router, "/v1/chat/completions", body)
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+				}
+
+				responses := []string{w.Body.String()}
+				if stream {
+					data := sseData(w.Body.String())
+					responses = data[:len(data)-1]
+				}
+				for _, response := range responses {
+					var decoded struct {
+						ServiceTier *string `json:"service_tier"`
+					}
+					if err := json.Unmarshal([]byte(response), &decoded); err != nil {
+						t.Fatalf("invalid response %s: %v", response, err)
+					}
+					if tt.want == "" && decoded.ServiceTier != nil {
+						t.Errorf("service_tier = %q, want it omitted", *decoded.ServiceTier)
+					} else if tt.want != "" && (decoded.ServiceTier == nil || *decoded.ServiceTier != tt.want) {
+						t.Errorf("response %s, want service_tier %q", response, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestStreamNDJSON(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, newCompletionUpstream(t)))
+
+	w := postJSON(router, "/v1/chat/completions", streamRequest, "Accept", "application/x-ndjson")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q, want application/x-ndjson", got)
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("body = %q, want several lines", w.Body)
+	}
+	for _, line := range lines {
+		var chunk models.OpenAIChatCompletionStreamResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil || chunk.Object != "chat.completion.chunk" {
+			t.Errorf("line %q is not a chat.completion.chunk (%v); want no SSE framing, comments or [DONE]", line, err)
+		}
+	}
+}
+
+func TestValidationErrorParam(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, nil))
+	const messages = `"messages": [{"role": "user", "content": "Hi"}]`
+
+	tests := []struct {
+		name      string
+		body      string
+		wantParam string
+		wantCode  string
+	}{
+		{"wrong type", `{"model": "gemini-2.5-flash", "temperature": "hot", ` + messages + `}`, "temperature", apierrors.CodeInvalidType},
+		{"temperature", `{"model": "gemini-2.5-flash", "temperature": 3, ` + messages + `}`, "temperature", apierrors.CodeInvalidValue},
+		{"top_p", `{"model": "gemini-2.5-flash", "top_p": 2, ` + messages + `}`, "top_p", apierrors.CodeInvalidValue},
+		{"max_tokens", `{"model": "gemini-2.5-flash", "max_tokens": 0, ` + messages + `}`, "max_tokens", apierrors.CodeInvalidValue},
+		{"timeout", `{"model": "gemini-2.5-flash", "timeout": -1, ` + messages + `}`, "timeout", apierrors.CodeInvalidValue},
+		{"content type", `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": 5}]}`, "messages", apierrors.CodeInvalidType},
+		{"tool type", `{"model": "gemini-2.5-flash", "tools": [{"type": "retrieval"}], ` + messages + `}`, "tools[0].type", apierrors.CodeUnsupportedValue},
+		{"tool name", `{"model": "gemini-2.5-flash", "tools": [{"type": "function", "function": {}}], ` + messages + `}`, "tools[0].function.name", apierrors.CodeMissingParameter},
+		{"tool_choice value", `{"model": "gemini-2.5-flash", "tool_choice": "sometimes", ` + messages + `}`, "tool_choice", apierrors.CodeUnsupportedValue},
+		{"tool_choice name", `{"model": "gemini-2.5-flash", "tool_choice": {"type": "function"}, ` + messages + `}`, "tool_choice.function.name", apierrors.CodeMissingParameter},
+		{"unknown tool_call_id", `{"model": "gemini-2.5-flash", "messages": [{"role": "tool", "tool_call_id": "call_1", "content": "42"}]}`, "messages", apierrors.CodeInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postJSON(router, "/v1/chat/completions", tt.body)
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400: %s", w.Code, w.Body)
+			}
+			var errorResponse apierrors.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &errorResponse); err != nil {
+				t.Fatalf("body is not a JSON error: %v", err)
+			}
+			if param := errorResponse.Error.Param; param == nil || *param != tt.wantParam {
+				t.Errorf("param = %v, want %s (message %q)", param, tt.wantParam, errorResponse.Error.Message)
+			}
+			if errorResponse.Error.Code != tt.wantCode {
+				t.Errorf("code = %v, want %s", errorResponse.Error.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestStreamMaxDuration(t *testing.T) {
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		writeSSE(w, `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Partial"}]}}]}`)
+		<-r.Context().Done()
+	})
+	cfg := newTestConfig(t, upstream)
+	cfg.MaxStreamDuration = 50 * time.Millisecond
+	router := newOpenAIRouter(cfg)
+
+	w := postJSON(router, "/v1/chat/completions", streamRequest)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	data := sseData(w.Body.String())
+	if len(data) < 2 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("stream = %q, want chunks followed by [DONE]", data)
+	}
+	var last models.OpenAIChatCompletionStreamResponse
+	if err := json.Unmarshal([]byte(data[len(data)-2]), &last); err != nil {
+		t.Fatalf("invalid chunk %s: %v", data[len(data)-2], err)
+	}
+	if len(last.Choices) != 1 || last.Choices[0].FinishReason == nil || *last.Choices[0].FinishReason != "length" {
+		t.Errorf("last chunk = %s, want finish_reason length", data[len(data)-2])
+	}
+}
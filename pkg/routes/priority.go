@@ -0,0 +1,32 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/google"
+)
+
+// PriorityHeader sets a request's scheduling class for upstream slots
+const PriorityHeader = "X-Priority"
+
+// PriorityMiddleware schedules the request's upstream calls with the class in
+// the X-Priority header, rejecting unknown values
+func PriorityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value := c.GetHeader(PriorityHeader)
+		if value == "" {
+			c.Next()
+			return
+		}
+		priority, err := google.ParsePriority(value)
+		if err != nil {
+			apierrors.AbortWithJSON(c, http.StatusBadRequest, PriorityHeader+" "+err.Error())
+			return
+		}
+		c.Request = c.Request.WithContext(google.WithPriority(c.Request.Context(), priority))
+		c.Next()
+	}
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// queryOverrides are generation parameters given in the query string, e.g.
+// ?temperature=0.2&thinking_budget=0. They fill in parameters the body leaves
+// out, or replace the body's values with ?force=1.
+type queryOverrides struct {
+	temperature    *float64
+	topP           *float64
+	topK           *int
+	maxTokens      *int
+	seed           *int
+	thinkingBudget *int
+	force          bool
+}
+
+// parseQueryOverrides reads and validates the query overrides, returning nil
+// when none are given. Thinking budgets are clamped to what model accepts.
+func parseQueryOverrides(c *gin.Context, model string) (*queryOverrides, error) {
+	o := &queryOverrides{}
+	var err error
+	if o.temperature, err = queryFloat(c, "temperature", 0, 2); err != nil {
+		return nil, err
+	}
+	if o.topP, err = queryFloat(c, "top_p", 0, 1); err != nil {
+		return nil, err
+	}
+	if o.topK, err = queryInt(c, "top_k", 1); err != nil {
+		return nil, err
+	}
+	if o.maxTokens, err = queryInt(c, "max_tokens", 1); err != nil {
+		return nil, err
+	}
+	if o.seed, err = queryInt(c, "seed", 0); err != nil {
+		return nil, err
+	}
+	if o.thinkingBudget, err = queryInt(c, "thinking_budget", -1); err != nil {
+		return nil, err
+	}
+	if o.thinkingBudget != nil && *o.thinkingBudget != -1 {
+		budget := *o.thinkingBudget
+		if min := config.MinThinkingBudget(model); budget < min {
+			budget = min
+		}
+		if max := config.MaxThinkingBudget(model); budget > max {
+			budget = max
+		}
+		o.thinkingBudget = &budget
+	}
+
+	if o.temperature == nil && o.topP == nil && o.topK == nil && o.maxTokens == nil && o.seed == nil && o.thinkingBudget == nil {
+		return nil, nil
+	}
+	force := strings.ToLower(c.Query("force"))
+	o.force = force == "1" || force == "true"
+	return o, nil
+}
+
+// applyOpenAI sets the overrides on an OpenAI request. top_k and the thinking
+// budget have no body field and are applied to the built payload by applyPayload.
+func (o *queryOverrides) applyOpenAI(request *models.OpenAIChatCompletionRequest) {
+	if o.temperature != nil && (request.Temperature == nil || o.force) {
+		request.Temperature = o.temperature
+	}
+	if o.topP != nil && (request.TopP == nil || o.force) {
+		request.TopP = o.topP
+	}
+	if o.maxTokens != nil && (request.MaxTokens == nil || o.force) {
+		request.MaxTokens = o.maxTokens
+	}
+	if o.seed != nil && (request.Seed == nil || o.force) {
+		request.Seed = o.seed
+	}
+}
+
+// applyNative sets the overrides on a native request's generationConfig
+func (o *queryOverrides) applyNative(request map[string]interface{}) {
+	generationConfig, ok := request["generationConfig"].(map[string]interface{})
+	if !ok {
+		generationConfig = map[string]interface{}{}
+		request["generationConfig"] = generationConfig
+	}
+	o.set(generationConfig, "temperature", o.temperature)
+	o.set(generationConfig, "topP", o.topP)
+	o.set(generationConfig, "topK", o.topK)
+	o.set(generationConfig, "maxOutputTokens", o.maxTokens)
+	o.set(generationConfig, "seed", o.seed)
+	if o.thinkingBudget != nil {
+		thinkingConfig, ok := generationConfig["thinkingConfig"].(map[string]interface{})
+		if !ok {
+			thinkingConfig = map[string]interface{}{}
+			generationConfig["thinkingConfig"] = thinkingConfig
+		}
+		o.set(thinkingConfig, "thinkingBudget", o.thinkingBudget)
+	}
+}
+
+// applyPayload sets top_k and the thinking budget on a Gemini payload built
+// from an OpenAI request. The thinking budget leaves DISABLE_THINKING and
+// models without a thinking configuration alone.
+func (o *queryOverrides) applyPayload(payload map[string]interface{}, cfg *config.Config) {
+	request, _ := payload["request"].(map[string]interface{})
+	generationConfig, _ := request["generationConfig"].(map[string]interface{})
+	if generationConfig == nil {
+		return
+	}
+	if o.topK != nil {
+		generationConfig["topK"] = *o.topK
+	}
+	if o.thinkingBudget == nil || cfg.DisableThinking {
+		return
+	}
+	thinkingConfig, ok := generationConfig["thinkingConfig"].(map[string]interface{})
+	if !ok {
+		if model, _ := payload["model"].(string); strings.Contains(model, "gemini-2.5-flash-image") {
+			return
+		}
+		thinkingConfig = map[string]interface{}{}
+		generationConfig["thinkingConfig"] = thinkingConfig
+	}
+	thinkingConfig["thinkingBudget"] = *o.thinkingBudget
+}
+
+// set stores an override under key unless the body already has a value and
+// the override isn't forced
+func (o *queryOverrides) set(target map[string]interface{}, key string, value interface{}) {
+	switch v := value.(type) {
+	case *float64:
+		if v == nil {
+			return
+		}
+		value = *v
+	case *int:
+		if v == nil {
+			return
+		}
+		value = *v
+	}
+	if _, exists := target[key]; exists && !o.force {
+		return
+	}
+	target[key] = value
+}
+
+func queryFloat(c *gin.Context, name string, min, max float64) (*float64, error) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return nil, apierrors.InvalidParam(name, apierrors.CodeInvalidType, "%s query parameter must be a number, got %q", name, raw)
+	}
+	if value < min || value > max {
+		return nil, apierrors.InvalidParam(name, apierrors.CodeInvalidValue, "%s query parameter must be between %v and %v, got %v", name, min, max, value)
+	}
+	return &value, nil
+}
+
+func queryInt(c *gin.Context, name string, min int) (*int, error) {
+	raw := strings.TrimSpace(c.Query(name))
+	if raw == "" {
+		return nil, nil
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, apierrors.InvalidParam(name, apierrors.CodeInvalidType, "%s query parameter must be an integer, got %q", name, raw)
+	}
+	if value < min {
+		return nil, apierrors.InvalidParam(name, apierrors.CodeInvalidValue, "%s query parameter must be at least %d, got %d", name, min, value)
+	}
+	return &value, nil
+}
@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// queryOverridesFor parses the overrides of a request with the given query string
+func queryOverridesFor(t *testing.T, query string, model string) (*queryOverrides, error) {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions?"+query, nil)
+	return parseQueryOverrides(c, model)
+}
+
+func TestQueryOverridesNative(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "fills in missing parameters",
+			query: "temperature=0.2&top_k=5&thinking_budget=100",
+			want: map[string]interface{}{"temperature": 0.5, "topK": 5, "maxOutputTokens": 100,
+				"thinkingConfig": map[string]interface{}{"thinkingBudget": 100}},
+		},
+		{
+			name:  "force replaces the body's values",
+			query: "temperature=0.2&max_tokens=50&force=1",
+			want:  map[string]interface{}{"temperature": 0.2, "maxOutputTokens": 50},
+		},
+		{
+			name:  "thinking budget clamped to the model's maximum",
+			query: "thinking_budget=100000",
+			want: map[string]interface{}{"temperature": 0.5, "maxOutputTokens": 100,
+				"thinkingConfig": map[string]interface{}{"thinkingBudget": 24576}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			overrides, err := queryOverridesFor(t, tt.query, "gemini-2.5-flash")
+			if err != nil || overrides == nil {
+				t.Fatalf("parseQueryOverrides() = %v, %v", overrides, err)
+			}
+			request := map[string]interface{}{"generationConfig": map[string]interface{}{"temperature": 0.5, "maxOutputTokens": 100}}
+
+			overrides.applyNative(request)
+
+			if got := request["generationConfig"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("generationConfig = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryOverridesOpenAI(t *testing.T) {
+	temperature := 0.5
+	for _, force := range []bool{false, true} {
+		query := "temperature=0.2&max_tokens=50&top_k=5"
+		wantTemperature := 0.5
+		if force {
+			query += "&force=1"
+			wantTemperature = 0.2
+		}
+		overrides, err := queryOverridesFor(t, query, "gemini-2.5-flash")
+		if err != nil {
+			t.Fatalf("parseQueryOverrides() error = %v", err)
+		}
+		request := &models.OpenAIChatCompletionRequest{Temperature: &temperature}
+
+		overrides.applyOpenAI(request)
+
+		if *request.Temperature != wantTemperature {
+			t.Errorf("force=%v: temperature = %v, want %v", force, *request.Temperature, wantTemperature)
+		}
+		if request.MaxTokens == nil || *request.MaxTokens != 50 {
+			t.Errorf("force=%v: max_tokens = %v, want 50 from the query", force, request.MaxTokens)
+		}
+
+		payload := map[string]interface{}{"model": "gemini-2.5-flash", "request": map[string]interface{}{"generationConfig": map[string]interface{}{}}}
+		overrides.applyPayload(payload, config.NewConfig())
+		if topK := payload["request"].(map[string]interface{})["generationConfig"].(map[string]interface{})["topK"]; topK != 5 {
+			t.Errorf("force=%v: topK = %v, want 5 from the query", force, topK)
+		}
+	}
+}
+
+func TestParseQueryOverridesInvalid(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantParam string
+		wantCode  string
+	}{
+		{"temperature=hot", "temperature", apierrors.CodeInvalidType},
+		{"temperature=3", "temperature", apierrors.CodeInvalidValue},
+		{"top_k=0", "top_k", apierrors.CodeInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := queryOverridesFor(t, tt.query, "gemini-2.5-flash")
+
+			var paramErr *apierrors.ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam || paramErr.Code != tt.wantCode {
+				t.Errorf("parseQueryOverrides() error = %v, want %s %s", err, tt.wantParam, tt.wantCode)
+			}
+		})
+	}
+
+	if overrides, err := queryOverridesFor(t, "force=1", "gemini-2.5-flash"); overrides != nil || err != nil {
+		t.Errorf("parseQueryOverrides() without overrides = %v, %v, want nil", overrides, err)
+	}
+}
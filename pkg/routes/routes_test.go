@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/auth"
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/google"
+)
+
+// testPassword authenticates requests to the test routers
+const testPassword = "test-password"
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newUpstream starts a fake Code Assist server. loadCodeAssist reports an
+// onboarded user; generate and stream requests are answered by generate.
+func newUpstream(t *testing.T, generate http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":loadCodeAssist") {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"currentTier": {"id": "free-tier", "name": "Free"}, "cloudaicompanionProject": "test-project"}`)
+			return
+		}
+		generate(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestConfig returns a config that sends upstream calls to upstream, with
+// environment credentials that need no refresh
+func newTestConfig(t *testing.T, upstream *httptest.Server) *config.Config {
+	t.Helper()
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "test-project")
+	t.Setenv("GEMINI_CREDENTIALS", fmt.Sprintf(`{"refresh_token": "refresh", "token": "access", "expiry": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339)))
+
+	cfg := config.NewConfig()
+	cfg.GeminiAuthPassword = testPassword
+	cfg.GeminiAuthPasswordPrevious = ""
+	cfg.CredentialFile = filepath.Join(t.TempDir(), "oauth_creds.json")
+	if upstream != nil {
+		cfg.CodeAssistEndpoint = upstream.URL
+	}
+	return cfg
+}
+
+// newOpenAIRouter serves the OpenAI routes for cfg
+func newOpenAIRouter(cfg *config.Config) *gin.Engine {
+	authConfig := auth.NewAuthConfig(cfg)
+	router := gin.New()
+	NewOpenAIHandler(authConfig, google.NewClient(authConfig, cfg), cfg, nil).RegisterRoutes(router)
+	return router
+}
+
+// newGeminiRouter serves the native Gemini routes for cfg
+func newGeminiRouter(cfg *config.Config) *gin.Engine {
+	authConfig := auth.NewAuthConfig(cfg)
+	router := gin.New()
+	NewGeminiHandler(authConfig, google.NewClient(authConfig, cfg), cfg).RegisterRoutes(router)
+	return router
+}
+
+// postJSON sends an authenticated JSON request to the router, with header
+// given as alternating names and values
+func postJSON(router http.Handler, path string, body string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+testPassword)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// get sends an authenticated GET request to the router
+func get(router http.Handler, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Authorization", "Bearer "+testPassword)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// writeSSE writes Gemini responses as the upstream's SSE stream, each in the
+// internal "response" envelope
+func writeSSE(w http.ResponseWriter, responses ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, response := range responses {
+		fmt.Fprintf(w, "data: {\"response\": %s}\n\n", response)
+		w.(http.Flusher).Flush()
+	}
+}
+
+// sseData returns the payloads of a recorded stream's data lines
+func sseData(body string) []string {
+	var data []string
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(line, "data: ") {
+			data = append(data, strings.TrimPrefix(line, "data: "))
+		}
+	}
+	return data
+}
@@ -0,0 +1,37 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// SafetyBlockModeHeader overrides SAFETY_BLOCK_MODE for a single request
+const SafetyBlockModeHeader = "X-Safety-Block-Mode"
+
+// safetyBlockMode returns how a blocked chat completion should be answered: the
+// header wins over extra_body.safety_block_mode, which wins over the server's
+// SAFETY_BLOCK_MODE
+func safetyBlockMode(c *gin.Context, cfg *config.Config, request *models.OpenAIChatCompletionRequest) (string, error) {
+	mode, source := c.GetHeader(SafetyBlockModeHeader), SafetyBlockModeHeader+" header"
+	if mode == "" {
+		mode, _ = request.ExtraBody["safety_block_mode"].(string)
+		source = "extra_body.safety_block_mode"
+	}
+	if mode = strings.ToLower(strings.TrimSpace(mode)); mode == "" {
+		return cfg.SafetyBlockMode, nil
+	}
+	if !config.IsSafetyBlockMode(mode) {
+		err := fmt.Errorf("%s must be %s or %s, got %q", source, config.SafetyBlockError, config.SafetyBlockEmpty, mode)
+		if source == SafetyBlockModeHeader+" header" {
+			return "", err
+		}
+		return "", apierrors.InvalidParam(source, apierrors.CodeInvalidValue, "%v", err)
+	}
+	return mode, nil
+}
@@ -0,0 +1,114 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// blockedResponse is a response Gemini withheld for safety
+const blockedResponse = `{"candidates": [{"finishReason": "SAFETY", "safetyRatings": [
+	{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "probability": "HIGH", "blocked": true}]}]}`
+
+func TestSafetyBlockMode(t *testing.T) {
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ":streamGenerateContent") {
+			writeSSE(w, blockedResponse)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"response": %s}`, blockedResponse)
+	})
+	router := newOpenAIRouter(newTestConfig(t, upstream))
+
+	tests := []struct {
+		name   string
+		stream bool
+		mode   string
+	}{
+		{"error", false, ""},
+		{"empty", false, "empty"},
+		{"streamed error", true, ""},
+		{"streamed empty", true, "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"model": "gemini-2.5-flash", "stream": %v, "messages": [{"role": "user", "content": "Hi"}]}`, tt.stream)
+
+			w := postJSON(router, "/v1/chat/completions", body, SafetyBlockModeHeader, tt.mode)
+
+			var blockError *apierrors.Response
+			var finishReason string
+			switch {
+			case tt.stream:
+				if w.Code != http.StatusOK {
+					t.Fatalf("status = %d, want 200 for a stream", w.Code)
+				}
+				data := sseData(w.Body.String())
+				if len(data) == 0 || data[len(data)-1] != "[DONE]" {
+					t.Fatalf("stream = %q, want it to end with [DONE]", data)
+				}
+				for _, line := range data[:len(data)-1] {
+					var errorResponse apierrors.Response
+					if json.Unmarshal([]byte(line), &errorResponse) == nil && errorResponse.Error.Message != "" {
+						blockError = &errorResponse
+						continue
+					}
+					var chunk models.OpenAIChatCompletionStreamResponse
+					if json.Unmarshal([]byte(line), &chunk) == nil && len(chunk.Choices) > 0 && chunk.Choices[0].FinishReason != nil {
+						finishReason = *chunk.Choices[0].FinishReason
+					}
+				}
+			case w.Code == http.StatusBadRequest:
+				var errorResponse apierrors.Response
+				if err := json.Unmarshal(w.Body.Bytes(), &errorResponse); err != nil {
+					t.Fatalf("invalid error body: %v", err)
+				}
+				blockError = &errorResponse
+			default:
+				var completion models.OpenAIChatCompletionResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &completion); err != nil || len(completion.Choices) != 1 {
+					t.Fatalf("status %d, invalid completion: %v\n%s", w.Code, err, w.Body)
+				}
+				if completion.Choices[0].FinishReason != nil {
+					finishReason = *completion.Choices[0].FinishReason
+				}
+				if completion.Choices[0].Message.Content != "" {
+					t.Errorf("content = %#v, want empty", completion.Choices[0].Message.Content)
+				}
+			}
+
+			if tt.mode == "empty" {
+				if blockError != nil {
+					t.Errorf("got error %q, want an empty completion", blockError.Error.Message)
+				}
+				if finishReason != "content_filter" {
+					t.Errorf("finish_reason = %q, want content_filter", finishReason)
+				}
+				return
+			}
+			if blockError == nil || blockError.Error.Block == nil || blockError.Error.Block.FinishReason != "SAFETY" {
+				t.Fatalf("got %d %s, want a block error", w.Code, w.Body)
+			}
+			if !strings.Contains(blockError.Error.Message, "HARM_CATEGORY_DANGEROUS_CONTENT") {
+				t.Errorf("message = %q, want the blocked category", blockError.Error.Message)
+			}
+		})
+	}
+}
+
+func TestSafetyBlockModeRejectsUnknownValue(t *testing.T) {
+	router := newOpenAIRouter(newTestConfig(t, nil))
+
+	w := postJSON(router, "/v1/chat/completions", `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`, SafetyBlockModeHeader, "silent")
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), SafetyBlockModeHeader) {
+		t.Errorf("got %d %s, want 400 naming the header", w.Code, w.Body)
+	}
+}
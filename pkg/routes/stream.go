@@ -0,0 +1,321 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/google"
+	"geminicli2api/pkg/metrics"
+	"geminicli2api/pkg/timing"
+)
+
+// ndjsonContentType is the Accept value that switches an OpenAI stream from
+// SSE to newline-delimited JSON
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonKey marks a request whose stream is written as newline-delimited JSON
+const ndjsonKey = "stream.ndjson"
+
+// acceptsNDJSON reports whether the client asked for newline-delimited JSON
+// instead of SSE
+func acceptsNDJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), ndjsonContentType)
+}
+
+// streamNDJSON reports whether the current stream is newline-delimited JSON
+func streamNDJSON(c *gin.Context) bool {
+	return c.GetBool(ndjsonKey)
+}
+
+// writeStreamComment writes an SSE comment. Newline-delimited JSON has no
+// comment syntax, so nothing is written on such streams.
+func writeStreamComment(c *gin.Context, comment string) {
+	if streamNDJSON(c) {
+		return
+	}
+	c.Writer.Write([]byte(comment))
+}
+
+// batchingWriter delays flushes of a streaming response so that bursts of small
+// chunks share a single flush. Data is flushed once maxBytes are pending or
+// interval has passed since the first unflushed write, whichever comes first.
+type batchingWriter struct {
+	gin.ResponseWriter
+
+	mu       sync.Mutex
+	interval time.Duration
+	maxBytes int
+	pending  int
+	timer    *time.Timer
+	closed   bool
+}
+
+// batchStreamFlushes installs a batching writer on the response when
+// STREAM_FLUSH_INTERVAL_MS is set. The returned function flushes anything still
+// pending and must be called before the handler returns.
+func batchStreamFlushes(c *gin.Context, cfg *config.Config) func() {
+	if cfg.StreamFlushInterval <= 0 {
+		return func() {}
+	}
+
+	w := &batchingWriter{
+		ResponseWriter: c.Writer,
+		interval:       cfg.StreamFlushInterval,
+		maxBytes:       cfg.StreamFlushMaxBytes,
+	}
+	c.Writer = w
+	return w.close
+}
+
+func (w *batchingWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.ResponseWriter.Write(data)
+	w.pending += n
+	return n, err
+}
+
+func (w *batchingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush flushes now if enough data is pending, otherwise schedules a flush
+func (w *batchingWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pending >= w.maxBytes {
+		w.flushLocked()
+		return
+	}
+	if w.pending > 0 && w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, func() {
+			w.mu.Lock()
+			defer w.mu.Unlock()
+			w.timer = nil
+			if !w.closed {
+				w.flushLocked()
+			}
+		})
+	}
+}
+
+func (w *batchingWriter) flushLocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if w.pending > 0 {
+		w.ResponseWriter.Flush()
+		w.pending = 0
+	}
+}
+
+// close flushes pending data and stops any scheduled flush
+func (w *batchingWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.flushLocked()
+	w.closed = true
+}
+
+// keepAlive writes SSE comment lines while a stream is idle, such as during
+// Gemini's thinking phase, so intermediaries don't drop the connection. It
+// also ends the stream once it has run for MAX_STREAM_DURATION.
+type keepAlive struct {
+	interval time.Duration
+	timer    *time.Timer
+	limit    *time.Timer
+	expired  bool
+}
+
+// newKeepAlive creates a keep-alive for STREAM_HEARTBEAT_INTERVAL, which never
+// fires when the interval is zero, and starts the MAX_STREAM_DURATION clock
+func newKeepAlive(cfg *config.Config) *keepAlive {
+	k := &keepAlive{interval: cfg.StreamHeartbeatInterval}
+	if k.interval > 0 {
+		k.timer = time.NewTimer(k.interval)
+	}
+	if cfg.MaxStreamDuration > 0 {
+		k.limit = time.NewTimer(cfg.MaxStreamDuration)
+	}
+	return k
+}
+
+// Next waits for the next chunk, writing a keep-alive comment each time the
+// stream has been idle for the interval. It reports no more chunks once the
+// maximum stream duration has passed; Expired then tells the two apart.
+func (k *keepAlive) Next(c *gin.Context, chunks <-chan google.StreamChunk) (google.StreamChunk, bool) {
+	var heartbeat, limit <-chan time.Time
+	if k.timer != nil {
+		heartbeat = k.timer.C
+	}
+	if k.limit != nil {
+		limit = k.limit.C
+	}
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if k.timer != nil {
+				k.reset()
+			}
+			return chunk, ok
+		case <-heartbeat:
+			writeStreamComment(c, ": keep-alive\n\n")
+			c.Writer.Flush()
+			k.timer.Reset(k.interval)
+		case <-limit:
+			k.expired = true
+			return google.StreamChunk{}, false
+		}
+	}
+}
+
+// Expired reports whether the stream was cut off at MAX_STREAM_DURATION
+func (k *keepAlive) Expired() bool {
+	return k.expired
+}
+
+func (k *keepAlive) reset() {
+	if !k.timer.Stop() {
+		select {
+		case <-k.timer.C:
+		default:
+		}
+	}
+	k.timer.Reset(k.interval)
+}
+
+// Stop releases the timers
+func (k *keepAlive) Stop() {
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+	if k.limit != nil {
+		k.limit.Stop()
+	}
+}
+
+// streamLatency measures when a stream's first thought and first content
+// arrive, relative to the start of the request, and the gaps between content
+// chunks once content is flowing
+type streamLatency struct {
+	start        time.Time
+	firstThought time.Time
+	firstContent time.Time
+	lastContent  time.Time
+	gapTotal     time.Duration
+	gaps         int
+}
+
+// newStreamLatency starts measuring from when the request's timings began
+func newStreamLatency(c *gin.Context) *streamLatency {
+	start := timing.FromContext(c.Request.Context()).Start()
+	if start.IsZero() {
+		start = time.Now()
+	}
+	return &streamLatency{start: start}
+}
+
+// Observe classifies a Gemini chunk as thinking or content and records its
+// arrival. Chunks with neither, such as a final usage chunk, are ignored.
+func (l *streamLatency) Observe(data map[string]interface{}) {
+	thought, content := chunkKinds(data)
+	now := time.Now()
+	if thought && l.firstThought.IsZero() {
+		l.firstThought = now
+	}
+	if !content {
+		return
+	}
+	if l.firstContent.IsZero() {
+		l.firstContent = now
+	} else {
+		gap := now.Sub(l.lastContent)
+		l.gapTotal += gap
+		l.gaps++
+		metrics.StreamInterChunkTime.Observe(milliseconds(gap))
+	}
+	l.lastContent = now
+}
+
+// Finish logs the measurements and records them in the stream metrics
+func (l *streamLatency) Finish(id string) {
+	if l.firstContent.IsZero() {
+		return
+	}
+	ttft := l.firstContent.Sub(l.start)
+	metrics.StreamTimeToFirstToken.Observe(milliseconds(ttft))
+	if thinking, ok := l.thinking(); ok {
+		metrics.StreamThinkingTime.Observe(milliseconds(thinking))
+		log.Printf("Stream %s: time to first token %v (thinking %v), mean inter-chunk %v over %d gaps", id, ttft, thinking, l.meanGap(), l.gaps)
+		return
+	}
+	log.Printf("Stream %s: time to first token %v, mean inter-chunk %v over %d gaps", id, ttft, l.meanGap(), l.gaps)
+}
+
+// Comment formats the measurements as an SSE comment in Server-Timing syntax,
+// or returns "" if no content has arrived
+func (l *streamLatency) Comment() string {
+	if l.firstContent.IsZero() {
+		return ""
+	}
+	entries := []string{fmt.Sprintf("ttft;dur=%.1f", milliseconds(l.firstContent.Sub(l.start)))}
+	if thinking, ok := l.thinking(); ok {
+		entries = append(entries, fmt.Sprintf("thinking;dur=%.1f", milliseconds(thinking)))
+	}
+	if l.gaps > 0 {
+		entries = append(entries, fmt.Sprintf("inter-chunk;dur=%.1f", milliseconds(l.meanGap())))
+	}
+	return ": stream-timing " + strings.Join(entries, ", ") + "\n\n"
+}
+
+// thinking returns how long the model spent thinking before its first content.
+// It is only known when thoughts are streamed.
+func (l *streamLatency) thinking() (time.Duration, bool) {
+	if l.firstThought.IsZero() || l.firstContent.Before(l.firstThought) {
+		return 0, false
+	}
+	return l.firstContent.Sub(l.firstThought), true
+}
+
+func (l *streamLatency) meanGap() time.Duration {
+	if l.gaps == 0 {
+		return 0
+	}
+	return l.gapTotal / time.Duration(l.gaps)
+}
+
+// chunkKinds reports whether a Gemini chunk carries thought parts and whether
+// it carries any other parts
+func chunkKinds(data map[string]interface{}) (thought, content bool) {
+	candidates, _ := data["candidates"].([]interface{})
+	for _, c := range candidates {
+		candidate, _ := c.(map[string]interface{})
+		body, _ := candidate["content"].(map[string]interface{})
+		parts, _ := body["parts"].([]interface{})
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			if part == nil {
+				continue
+			}
+			if isThought, _ := part["thought"].(bool); isThought {
+				thought = true
+			} else if text, ok := part["text"].(string); !ok || text != "" {
+				content = true
+			}
+		}
+	}
+	return thought, content
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
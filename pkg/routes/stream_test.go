@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+)
+
+// flushCounter is a response writer that discards data and counts flushes
+type flushCounter struct {
+	header  http.Header
+	flushes int
+}
+
+func (w *flushCounter) Header() http.Header            { return w.header }
+func (w *flushCounter) Write(data []byte) (int, error) { return len(data), nil }
+func (w *flushCounter) WriteHeader(int)                {}
+func (w *flushCounter) Flush()                         { w.flushes++ }
+
+func BenchmarkBatchingWriter(b *testing.B) {
+	const chunksPerStream = 200
+	chunk := []byte(`data: {"choices":[{"index":0,"delta":{"content":"tok"}}]}` + "\n\n")
+
+	for _, interval := range []time.Duration{0, 20 * time.Millisecond} {
+		name := "unbatched"
+		if interval > 0 {
+			name = "interval=" + interval.String()
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg := config.NewConfig()
+			cfg.StreamFlushInterval = interval
+			cfg.StreamFlushMaxBytes = 16 * 1024
+
+			flushes := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				counter := &flushCounter{header: http.Header{}}
+				c, _ := gin.CreateTestContext(counter)
+				done := batchStreamFlushes(c, cfg)
+				for j := 0; j < chunksPerStream; j++ {
+					c.Writer.Write(chunk)
+					c.Writer.Flush()
+				}
+				done()
+				flushes += counter.flushes
+			}
+			b.ReportMetric(float64(flushes)/float64(b.N), "flushes/op")
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+)
+
+// RequestTimeoutHeader lets clients set how long a request may take, in seconds
+const RequestTimeoutHeader = "X-Request-Timeout-Seconds"
+
+// applyRequestTimeout bounds the request context by the client's timeout, from
+// the header or else bodyTimeout, or by REQUEST_TIMEOUT when neither is given.
+// Client timeouts are clamped to MAX_REQUEST_TIMEOUT. For streams the timeout
+// covers the whole stream. The returned function releases the context.
+func applyRequestTimeout(c *gin.Context, cfg *config.Config, bodyTimeout *float64) (context.CancelFunc, error) {
+	timeout := cfg.RequestTimeout
+
+	var seconds *float64
+	param := ""
+	if header := strings.TrimSpace(c.GetHeader(RequestTimeoutHeader)); header != "" {
+		value, err := strconv.ParseFloat(header, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number of seconds", RequestTimeoutHeader)
+		}
+		seconds = &value
+	} else if bodyTimeout != nil {
+		seconds = bodyTimeout
+		param = "timeout"
+	}
+
+	if seconds != nil {
+		if *seconds <= 0 {
+			if param != "" {
+				return nil, apierrors.InvalidParam(param, apierrors.CodeInvalidValue, "request timeout must be positive, got %v", *seconds)
+			}
+			return nil, fmt.Errorf("request timeout must be positive, got %v", *seconds)
+		}
+		timeout = time.Duration(*seconds * float64(time.Second))
+		if timeout > cfg.MaxRequestTimeout {
+			timeout = cfg.MaxRequestTimeout
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	c.Request = c.Request.WithContext(ctx)
+	return cancel, nil
+}
@@ -0,0 +1,68 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/config"
+)
+
+func TestApplyRequestTimeout(t *testing.T) {
+	float := func(v float64) *float64 { return &v }
+	tests := []struct {
+		name        string
+		header      string
+		bodyTimeout *float64
+		want        time.Duration
+		wantErr     bool
+	}{
+		{name: "default", want: time.Minute},
+		{name: "header", header: "5", want: 5 * time.Second},
+		{name: "fractional header", header: "2.5", want: 2500 * time.Millisecond},
+		{name: "body", bodyTimeout: float(7), want: 7 * time.Second},
+		{name: "header takes precedence over body", header: "5", bodyTimeout: float(7), want: 5 * time.Second},
+		{name: "clamped to the maximum", header: "3600", want: 10 * time.Minute},
+		{name: "not a number", header: "soon", wantErr: true},
+		{name: "zero", header: "0", wantErr: true},
+		{name: "negative body", bodyTimeout: float(-1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.RequestTimeout = time.Minute
+			cfg.MaxRequestTimeout = 10 * time.Minute
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+			if tt.header != "" {
+				c.Request.Header.Set(RequestTimeoutHeader, tt.header)
+			}
+
+			start := time.Now()
+			cancel, err := applyRequestTimeout(c, cfg, tt.bodyTimeout)
+			if tt.wantErr {
+				if err == nil {
+					cancel()
+					t.Error("applyRequestTimeout() accepted the timeout")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyRequestTimeout() error = %v", err)
+			}
+			defer cancel()
+
+			deadline, ok := c.Request.Context().Deadline()
+			if !ok {
+				t.Fatal("request context has no deadline")
+			}
+			if got := deadline.Sub(start); got < tt.want || got > tt.want+time.Second {
+				t.Errorf("deadline in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
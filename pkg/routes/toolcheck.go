@@ -0,0 +1,122 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"geminicli2api/pkg/accesslog"
+	"geminicli2api/pkg/models"
+	"geminicli2api/pkg/transformers"
+)
+
+// functionCallValidator returns a validator for the request's tools when
+// TOOL_CALL_VALIDATION is on, or nil
+func (h *OpenAIHandler) functionCallValidator(request *models.OpenAIChatCompletionRequest) *transformers.FunctionCallValidator {
+	if h.config.ToolCallValidation == "off" {
+		return nil
+	}
+	return transformers.NewFunctionCallValidator(request.Tools)
+}
+
+// validateFunctionCalls checks the function calls of a complete response to
+// geminiPayload, the conversation as extended by any built-in tool rounds. In
+// retry mode an invalid call is retried once with a corrective instruction;
+// whatever is still invalid is dropped and its candidate finished with
+// MALFORMED_FUNCTION_CALL.
+func (h *OpenAIHandler) validateFunctionCalls(c *gin.Context, request *models.OpenAIChatCompletionRequest, validator *transformers.FunctionCallValidator, geminiPayload map[string]interface{}, geminiResponse map[string]interface{}) map[string]interface{} {
+	problem := validator.Check(geminiResponse)
+	if problem == "" {
+		return geminiResponse
+	}
+	log.Printf("Gemini returned an invalid function call: %s", problem)
+
+	if h.config.ToolCallValidation == "retry" {
+		retried, err := h.retryFunctionCall(c, request, geminiPayload, geminiResponse, problem)
+		if err != nil {
+			log.Printf("Function call retry failed: %v", err)
+		} else {
+			geminiResponse = retried
+		}
+	}
+
+	if problems := validator.Enforce(geminiResponse); len(problems) > 0 {
+		log.Printf("Dropping invalid function calls: %s", strings.Join(problems, "; "))
+	}
+	return geminiResponse
+}
+
+// retryFunctionCall sends the request again with the invalid model turn and
+// an extra user turn explaining what was wrong with its function call, then
+// runs any built-in tools the retried response calls
+func (h *OpenAIHandler) retryFunctionCall(c *gin.Context, request *models.OpenAIChatCompletionRequest, geminiPayload map[string]interface{}, geminiResponse map[string]interface{}, problem string) (map[string]interface{}, error) {
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	var parts interface{}
+	if len(candidates) > 0 {
+		candidate, _ := candidates[0].(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts = content["parts"]
+	}
+
+	retryPayload := withContents(geminiPayload,
+		map[string]interface{}{"role": "model", "parts": parts},
+		map[string]interface{}{
+			"role": "user",
+			"parts": []interface{}{map[string]interface{}{
+				"text": fmt.Sprintf("Your previous function call was invalid: %s. Call only the declared functions, with arguments matching their parameter schemas.", problem),
+			}},
+		},
+	)
+	retried, err := h.generate(c, retryPayload)
+	if err != nil {
+		return nil, err
+	}
+	retried, _, tokens, err := h.runBuiltinTools(c, request, retryPayload, retried)
+	accesslog.AddTokens(c, tokens)
+	return retried, err
+}
+
+// withContents returns a copy of a Gemini payload with turns appended to its
+// contents, leaving the original unchanged
+func withContents(geminiPayload map[string]interface{}, turns ...interface{}) map[string]interface{} {
+	request, _ := geminiPayload["request"].(map[string]interface{})
+	extended := make(map[string]interface{}, len(request))
+	for key, value := range request {
+		extended[key] = value
+	}
+
+	var contents []interface{}
+	switch existing := request["contents"].(type) {
+	case []map[string]interface{}:
+		for _, content := range existing {
+			contents = append(contents, content)
+		}
+	case []interface{}:
+		contents = append(contents, existing...)
+	}
+	extended["contents"] = append(contents, turns...)
+	return map[string]interface{}{"model": geminiPayload["model"], "request": extended}
+}
+
+// generate sends a follow-up non-streaming request and returns its parsed
+// response. Its usage is left for the caller to add to the request's.
+func (h *OpenAIHandler) generate(c *gin.Context, geminiPayload map[string]interface{}) (map[string]interface{}, error) {
+	resp, err := h.googleClient.SendGeminiRequest(c.Request.Context(), geminiPayload, false)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Google API returned status %d", resp.StatusCode)
+	}
+
+	var geminiResponse map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&geminiResponse); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return geminiResponse, nil
+}
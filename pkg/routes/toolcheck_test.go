@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// newScriptedUpstream starts a fake upstream that answers successive generate
+// requests with responses in order, and returns the contents of every request
+func newScriptedUpstream(t *testing.T, responses ...string) (*httptest.Server, func() [][]interface{}) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests [][]interface{}
+	upstream := newUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Request struct {
+				Contents []interface{} `json:"contents"`
+			} `json:"request"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+
+		mu.Lock()
+		requests = append(requests, payload.Request.Contents)
+		n := len(requests)
+		mu.Unlock()
+		if n > len(responses) {
+			t.Errorf("unexpected upstream request %d", n)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"response": %s}`, responses[n-1])
+	})
+	return upstream, func() [][]interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+// functionCallResponse is a Gemini response calling one function
+func functionCallResponse(name string) string {
+	return fmt.Sprintf(`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": %q, "args": {}}}]}, "finishReason": "STOP"}]}`, name)
+}
+
+const (
+	toolRequest  = `{"model": "gemini-2.5-flash", "tools": [{"type": "function", "function": {"name": "get_weather", "parameters": {"type": "object"}}}], "messages": [{"role": "user", "content": "Weather?"}]}`
+	textResponse = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Sunny"}]}, "finishReason": "STOP"}]}`
+)
+
+// completionText returns the message content of a completion's first choice
+func completionText(t *testing.T, body []byte) interface{} {
+	t.Helper()
+	var completion models.OpenAIChatCompletionResponse
+	if err := json.Unmarshal(body, &completion); err != nil || len(completion.Choices) == 0 {
+		t.Fatalf("invalid completion: %v\n%s", err, body)
+	}
+	return completion.Choices[0].Message.Content
+}
+
+// turn returns a request turn's role and the JSON of its parts
+func turn(content interface{}) (string, string) {
+	turn, _ := content.(map[string]interface{})
+	role, _ := turn["role"].(string)
+	parts, _ := json.Marshal(turn["parts"])
+	return role, string(parts)
+}
+
+func TestFunctionCallRetryIncludesInvalidTurn(t *testing.T) {
+	upstream, requests := newScriptedUpstream(t, functionCallResponse("unknown_tool"), textResponse)
+	cfg := newTestConfig(t, upstream)
+	cfg.ToolCallValidation = "retry"
+
+	w := postJSON(newOpenAIRouter(cfg), "/v1/chat/completions", toolRequest)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := completionText(t, w.Body.Bytes()); got != "Sunny" {
+		t.Errorf("content = %v, want the retried answer", got)
+	}
+	sent := requests()
+	if len(sent) != 2 {
+		t.Fatalf("upstream got %d requests, want the original and one retry", len(sent))
+	}
+	retry := sent[1]
+	if len(retry) != len(sent[0])+2 {
+		t.Fatalf("retry has %d turns, want the original %d plus the model and corrective turns", len(retry), len(sent[0]))
+	}
+	if role, parts := turn(retry[len(retry)-2]); role != "model" || !strings.Contains(parts, "unknown_tool") {
+		t.Errorf("second to last retry turn = %s %s, want the model's invalid call", role, parts)
+	}
+	if role, parts := turn(retry[len(retry)-1]); role != "user" || !strings.Contains(parts, "invalid") {
+		t.Errorf("last retry turn = %s %s, want the corrective user turn", role, parts)
+	}
+}
+
+func TestFunctionCallRetryAfterBuiltinTools(t *testing.T) {
+	upstream, requests := newScriptedUpstream(t,
+		functionCallResponse("current_time"),
+		functionCallResponse("unknown_tool"),
+		functionCallResponse("current_time"),
+		textResponse,
+	)
+	cfg := newTestConfig(t, upstream)
+	cfg.ToolCallValidation = "retry"
+	cfg.BuiltinTools = []string{"current_time"}
+	cfg.BuiltinToolMaxIterations = config.NewConfig().BuiltinToolMaxIterations
+
+	w := postJSON(newOpenAIRouter(cfg), "/v1/chat/completions", toolRequest)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if got := completionText(t, w.Body.Bytes()); got != "Sunny" {
+		t.Errorf("content = %v, want the answer after the retried built-in round", got)
+	}
+	sent := requests()
+	if len(sent) != 4 {
+		t.Fatalf("upstream got %d requests, want 4", len(sent))
+	}
+	// The retry extends the conversation of the built-in round before it
+	retry := sent[2]
+	if len(retry) != len(sent[1])+2 {
+		t.Fatalf("retry has %d turns, want the built-in round's %d plus two", len(retry), len(sent[1]))
+	}
+	if role, parts := turn(retry[len(sent[0])]); role != "model" || !strings.Contains(parts, "current_time") {
+		t.Errorf("retry turn %d = %s %s, want the built-in call", len(sent[0]), role, parts)
+	}
+	if role, parts := turn(sent[3][len(retry)]); role != "model" || !strings.Contains(parts, "current_time") {
+		t.Errorf("turn after the retry = %s %s, want the retried built-in call", role, parts)
+	}
+}
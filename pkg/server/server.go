@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"geminicli2api/pkg/config"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 15 * time.Second
+
+// Run serves handler on addr until SIGINT or SIGTERM, then shuts down gracefully.
+// It serves HTTPS when TLS_CERT_FILE and TLS_KEY_FILE are set, and additionally
+// requires client certificates signed by TLS_CLIENT_CA when that is set.
+func Run(handler http.Handler, addr string, cfg *config.Config) error {
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: handler,
+	}
+
+	useTLS := cfg.TLSCertFile != "" && cfg.TLSKeyFile != ""
+	if useTLS {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return err
+		}
+		srv.TLSConfig = tlsConfig
+	} else if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" || cfg.TLSClientCA != "" {
+		return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must both be set to enable TLS")
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if useTLS {
+			log.Printf("Serving HTTPS on %s (client certificates required: %v)", addr, cfg.TLSClientCA != "")
+			err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Serving HTTP on %s", addr)
+			err = srv.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("graceful shutdown failed: %w", err)
+	}
+	log.Println("Server stopped")
+	return nil
+}
+
+// newTLSConfig builds the server TLS configuration, enabling mTLS when a client CA is set
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.TLSClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	caPEM, err := os.ReadFile(cfg.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS_CLIENT_CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in TLS_CLIENT_CA %s", cfg.TLSClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	return tlsConfig, nil
+}
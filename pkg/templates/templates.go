@@ -0,0 +1,124 @@
+// Package templates expands named prompt templates, loaded from
+// PROMPT_TEMPLATE_DIR, into chat messages
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// placeholder matches {{name}}, with optional spaces inside the braces
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Template is a named list of messages with {{variable}} placeholders
+type Template struct {
+	Description string                     `json:"description,omitempty"`
+	Messages    []models.OpenAIChatMessage `json:"messages"`
+}
+
+// Store holds the templates loaded at startup, keyed by file name
+type Store struct {
+	templates map[string]Template
+}
+
+// Load reads every <name>.json file in dir as the template <name>. It returns
+// nil when dir is empty, meaning templates are disabled.
+func Load(dir string) (*Store, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	store := &Store{templates: make(map[string]Template, len(paths))}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var template Template
+		if err := json.Unmarshal(data, &template); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(template.Messages) == 0 {
+			return nil, fmt.Errorf("%s: template has no messages", path)
+		}
+		store.templates[strings.TrimSuffix(filepath.Base(path), ".json")] = template
+	}
+	log.Printf("Loaded %d prompt templates from %s", len(store.templates), dir)
+	return store, nil
+}
+
+// Expand returns the messages of the named template with its placeholders
+// replaced by variables. Unknown templates and placeholders without a
+// variable are rejected; extra variables are ignored.
+func (s *Store) Expand(name string, variables map[string]interface{}) ([]models.OpenAIChatMessage, error) {
+	template, ok := s.templates[name]
+	if !ok {
+		return nil, apierrors.InvalidParam("template", apierrors.CodeInvalidValue, "unknown prompt template %q", name)
+	}
+
+	missing := map[string]bool{}
+	substitute := func(text string) string {
+		return placeholder.ReplaceAllStringFunc(text, func(match string) string {
+			key := placeholder.FindStringSubmatch(match)[1]
+			value, ok := variables[key]
+			if !ok {
+				missing[key] = true
+				return match
+			}
+			if str, ok := value.(string); ok {
+				return str
+			}
+			encoded, _ := json.Marshal(value)
+			return string(encoded)
+		})
+	}
+
+	messages := make([]models.OpenAIChatMessage, len(template.Messages))
+	for i, message := range template.Messages {
+		switch content := message.Content.(type) {
+		case string:
+			message.Content = substitute(content)
+		case []interface{}:
+			parts := make([]interface{}, len(content))
+			for j, part := range content {
+				partMap, ok := part.(map[string]interface{})
+				text, isText := partMap["text"].(string)
+				if !ok || !isText {
+					parts[j] = part
+					continue
+				}
+				copied := make(map[string]interface{}, len(partMap))
+				for key, value := range partMap {
+					copied[key] = value
+				}
+				copied["text"] = substitute(text)
+				parts[j] = copied
+			}
+			message.Content = parts
+		}
+		messages[i] = message
+	}
+
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for key := range missing {
+			names = append(names, key)
+		}
+		sort.Strings(names)
+		return nil, apierrors.InvalidParam("variables", apierrors.CodeMissingParameter, "prompt template %q needs variables: %s", name, strings.Join(names, ", "))
+	}
+	return messages, nil
+}
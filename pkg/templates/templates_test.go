@@ -0,0 +1,89 @@
+package templates
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// loadTemplates writes templates given as JSON into a directory and loads it
+func loadTemplates(t *testing.T, templates map[string]string) *Store {
+	t.Helper()
+	dir := t.TempDir()
+	for name, literal := range templates {
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(literal), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return store
+}
+
+func TestExpand(t *testing.T) {
+	store := loadTemplates(t, map[string]string{"review": `{"messages": [
+		{"role": "system", "content": "You review {{ language }} code."},
+		{"role": "user", "content": [{"type": "text", "text": "Limit: {{limit}}"}, {"type": "image_url", "image_url": {"url": "https://example.com/a.png"}}]}
+	]}`})
+
+	messages, err := store.Expand("review", map[string]interface{}{"language": "Go", "limit": 3, "unused": "x"})
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+
+	want := []models.OpenAIChatMessage{
+		{Role: "system", Content: "You review Go code."},
+		{Role: "user", Content: []interface{}{
+			map[string]interface{}{"type": "text", "text": "Limit: 3"},
+			map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "https://example.com/a.png"}},
+		}},
+	}
+	if !reflect.DeepEqual(messages, want) {
+		t.Errorf("Expand() = %+v, want %+v", messages, want)
+	}
+
+	// The loaded template is left unexpanded for later requests
+	again, err := store.Expand("review", map[string]interface{}{"language": "Rust", "limit": 1})
+	if err != nil || again[0].Content != "You review Rust code." {
+		t.Errorf("second Expand() = %+v, %v, want the template expanded afresh", again, err)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	store := loadTemplates(t, map[string]string{"greet": `{"messages": [{"role": "user", "content": "Hello {{name}}, from {{team}}"}]}`})
+
+	tests := []struct {
+		name      string
+		template  string
+		variables map[string]interface{}
+		wantParam string
+		wantCode  string
+	}{
+		{"missing variables", "greet", map[string]interface{}{"team": "ops"}, "variables", apierrors.CodeMissingParameter},
+		{"unknown template", "farewell", nil, "template", apierrors.CodeInvalidValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := store.Expand(tt.template, tt.variables)
+
+			var paramErr *apierrors.ParamError
+			if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam || paramErr.Code != tt.wantCode {
+				t.Errorf("Expand() error = %v, want %s %s", err, tt.wantParam, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestLoadDisabled(t *testing.T) {
+	if store, err := Load(""); store != nil || err != nil {
+		t.Errorf("Load(\"\") = %v, %v, want nil", store, err)
+	}
+}
@@ -0,0 +1,80 @@
+package timing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey struct{}
+
+// Timings collects named durations for a single request, reported via the
+// Server-Timing header
+type Timings struct {
+	mu      sync.Mutex
+	start   time.Time
+	entries []entry
+}
+
+type entry struct {
+	name     string
+	duration time.Duration
+}
+
+// WithTimings returns a context carrying a new Timings collector
+func WithTimings(ctx context.Context) (context.Context, *Timings) {
+	t := &Timings{start: time.Now()}
+	return context.WithValue(ctx, contextKey{}, t), t
+}
+
+// Start returns when the collector was created, which is close to when the
+// request started, or the zero time for a nil collector
+func (t *Timings) Start() time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.start
+}
+
+// FromContext returns the collector stored in ctx, or nil if there is none
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(contextKey{}).(*Timings)
+	return t
+}
+
+// Track records the time elapsed since start under name. Durations recorded
+// under the same name are summed. Safe to call on a nil collector.
+func (t *Timings) Track(name string, start time.Time) {
+	if t == nil {
+		return
+	}
+	elapsed := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.entries {
+		if t.entries[i].name == name {
+			t.entries[i].duration += elapsed
+			return
+		}
+	}
+	t.entries = append(t.entries, entry{name: name, duration: elapsed})
+}
+
+// Header formats the collected durations as a Server-Timing header value,
+// e.g. "transform;dur=0.4, auth;dur=1.2, upstream;dur=840.5"
+func (t *Timings) Header() string {
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metrics := make([]string, 0, len(t.entries))
+	for _, e := range t.entries {
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.1f", e.name, float64(e.duration.Microseconds())/1000))
+	}
+	return strings.Join(metrics, ", ")
+}
@@ -0,0 +1,545 @@
+package transformers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// AnthropicRequestToGemini transforms an Anthropic Messages API request to Gemini format
+func AnthropicRequestToGemini(anthropicRequest *models.AnthropicMessagesRequest, cfg *config.Config) (map[string]interface{}, error) {
+	contents := []map[string]interface{}{}
+
+	// Tool use IDs mapped to function names, for resolving tool results
+	toolUseNames := map[string]string{}
+
+	for i, message := range anthropicRequest.Messages {
+		role := message.Role
+		switch role {
+		case "user":
+		case "assistant":
+			role = "model"
+		default:
+			return nil, fmt.Errorf("messages[%d]: unsupported role %q", i, message.Role)
+		}
+
+		parts, err := anthropicContentToParts(message.Content, toolUseNames)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+
+		contents = append(contents, map[string]interface{}{
+			"role":  role,
+			"parts": parts,
+		})
+	}
+
+	generationConfig := map[string]interface{}{}
+	if anthropicRequest.MaxTokens > 0 {
+		generationConfig["maxOutputTokens"] = anthropicRequest.MaxTokens
+	} else if maxTokens := cfg.GetDefaultMaxOutputTokens(anthropicRequest.Model); maxTokens > 0 {
+		generationConfig["maxOutputTokens"] = maxTokens
+	}
+	if anthropicRequest.Temperature != nil {
+		generationConfig["temperature"] = *anthropicRequest.Temperature
+	}
+	if anthropicRequest.TopP != nil {
+		generationConfig["topP"] = *anthropicRequest.TopP
+	}
+	if anthropicRequest.TopK != nil {
+		generationConfig["topK"] = *anthropicRequest.TopK
+	}
+	cfg.ApplyModelDefaults(generationConfig, anthropicRequest.Model)
+	if stopSequences := cfg.MergeStopSequences(anthropicRequest.StopSequences); len(stopSequences) > 0 {
+		generationConfig["stopSequences"] = stopSequences
+	}
+	applyThinkingConfig(generationConfig, anthropicRequest.Model, cfg)
+
+	requestPayload := map[string]interface{}{
+		"contents":         contents,
+		"generationConfig": generationConfig,
+		"model":            config.GetBaseModelName(anthropicRequest.Model),
+	}
+
+	// Anthropic has a real system prompt, so map it to systemInstruction
+	if systemParts, err := anthropicSystemToParts(anthropicRequest.System); err != nil {
+		return nil, err
+	} else if len(systemParts) > 0 {
+		requestPayload["systemInstruction"] = map[string]interface{}{"parts": systemParts}
+	}
+
+	// Tools share the OpenAI translation
+	var tools []map[string]interface{}
+	openaiTools := make([]models.OpenAITool, 0, len(anthropicRequest.Tools))
+	for _, tool := range anthropicRequest.Tools {
+		openaiTools = append(openaiTools, models.OpenAITool{
+			Type: "function",
+			Function: models.OpenAIFunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	functionTool, err := toolsToGemini(openaiTools)
+	if err != nil {
+		return nil, err
+	}
+	if functionTool != nil {
+		tools = append(tools, functionTool)
+	}
+	if anthropicRequest.ToolChoice != nil {
+		toolConfig, err := anthropicToolChoiceToGemini(anthropicRequest.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		requestPayload["toolConfig"] = toolConfig
+	}
+
+	// Add Google Search grounding for search models
+	if config.IsSearchModel(anthropicRequest.Model) {
+		tools = append(tools, map[string]interface{}{"googleSearch": map[string]interface{}{}})
+	}
+	if len(tools) > 0 {
+		requestPayload["tools"] = tools
+	}
+
+	return requestPayload, nil
+}
+
+// anthropicSystemToParts converts an Anthropic system prompt into Gemini parts
+func anthropicSystemToParts(system interface{}) ([]map[string]interface{}, error) {
+	switch system := system.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if system == "" {
+			return nil, nil
+		}
+		return []map[string]interface{}{{"text": system}}, nil
+	case []interface{}:
+		var parts []map[string]interface{}
+		for _, block := range system {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if text, ok := blockMap["text"].(string); ok && text != "" {
+				parts = append(parts, map[string]interface{}{"text": text})
+			}
+		}
+		return parts, nil
+	default:
+		return nil, fmt.Errorf("unsupported system type: %T", system)
+	}
+}
+
+// anthropicContentToParts converts Anthropic message content into Gemini parts
+func anthropicContentToParts(content interface{}, toolUseNames map[string]string) ([]map[string]interface{}, error) {
+	var blocks []interface{}
+	switch content := content.(type) {
+	case string:
+		if content == "" {
+			return nil, fmt.Errorf("content is empty")
+		}
+		return []map[string]interface{}{{"text": content}}, nil
+	case []interface{}:
+		blocks = content
+	default:
+		return nil, fmt.Errorf("unsupported content type: %T", content)
+	}
+
+	var parts []map[string]interface{}
+	for _, block := range blocks {
+		blockMap, ok := block.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		blockType, _ := blockMap["type"].(string)
+		switch blockType {
+		case "text":
+			if text, ok := blockMap["text"].(string); ok && text != "" {
+				parts = append(parts, map[string]interface{}{"text": text})
+			}
+
+		case "image":
+			source, _ := blockMap["source"].(map[string]interface{})
+			sourceType, _ := source["type"].(string)
+			switch sourceType {
+			case "base64":
+				mediaType, _ := source["media_type"].(string)
+				data, _ := source["data"].(string)
+				parts = append(parts, map[string]interface{}{
+					"inlineData": map[string]interface{}{"mimeType": mediaType, "data": data},
+				})
+			case "url":
+				url, _ := source["url"].(string)
+				if part, ok := processImageURL(url); ok {
+					parts = append(parts, part)
+				}
+			}
+
+		case "tool_use":
+			id, _ := blockMap["id"].(string)
+			name, _ := blockMap["name"].(string)
+			input, ok := blockMap["input"].(map[string]interface{})
+			if !ok {
+				input = map[string]interface{}{}
+			}
+			toolUseNames[id] = name
+			parts = append(parts, map[string]interface{}{
+				"functionCall": map[string]interface{}{"name": name, "args": input},
+			})
+
+		case "tool_result":
+			toolUseID, _ := blockMap["tool_use_id"].(string)
+			name := toolUseNames[toolUseID]
+			if name == "" {
+				return nil, fmt.Errorf("tool_result references unknown tool_use_id %q", toolUseID)
+			}
+			parts = append(parts, map[string]interface{}{
+				"functionResponse": map[string]interface{}{
+					"name":     name,
+					"response": anthropicToolResult(blockMap),
+				},
+			})
+
+		case "thinking", "redacted_thinking":
+			// Prior reasoning isn't replayed to Gemini
+		}
+	}
+
+	// Gemini rejects empty text parts, so a turn with nothing left to send,
+	// such as one holding only thinking blocks, is the client's error
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("content has no text, image or tool blocks to send")
+	}
+	return parts, nil
+}
+
+// anthropicToolResult builds a functionResponse object from a tool_result block,
+// whose content may be a string or a list of text blocks
+func anthropicToolResult(block map[string]interface{}) map[string]interface{} {
+	var text string
+	switch content := block["content"].(type) {
+	case string:
+		text = content
+	case []interface{}:
+		var texts []string
+		for _, item := range content {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				if t, ok := itemMap["text"].(string); ok {
+					texts = append(texts, t)
+				}
+			}
+		}
+		text = strings.Join(texts, "\n")
+	}
+
+	// Gemini expects an object; wrap anything that isn't one
+	response := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(text), &response); err != nil {
+		response = map[string]interface{}{"content": text}
+	}
+	if isError, _ := block["is_error"].(bool); isError {
+		response["is_error"] = true
+	}
+	return response
+}
+
+// anthropicToolChoiceToGemini maps Anthropic's tool_choice onto the OpenAI values
+// understood by toolChoiceToGemini
+func anthropicToolChoiceToGemini(toolChoice map[string]interface{}) (map[string]interface{}, error) {
+	choiceType, _ := toolChoice["type"].(string)
+	switch choiceType {
+	case "auto":
+		return toolChoiceToGemini("auto")
+	case "any":
+		return toolChoiceToGemini("required")
+	case "none":
+		return toolChoiceToGemini("none")
+	case "tool":
+		return toolChoiceToGemini(map[string]interface{}{
+			"function": map[string]interface{}{"name": toolChoice["name"]},
+		})
+	default:
+		return nil, fmt.Errorf("unsupported tool_choice type %q", choiceType)
+	}
+}
+
+// GeminiResponseToAnthropic transforms a Gemini API response to Anthropic Messages format.
+// Anthropic has no multiple choices, so only the first candidate is used.
+func GeminiResponseToAnthropic(geminiResponse map[string]interface{}, model string, messageID string) *models.AnthropicMessagesResponse {
+	response := &models.AnthropicMessagesResponse{
+		ID:      messageID,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: []models.AnthropicContentBlock{},
+		Usage:   anthropicUsage(geminiResponse),
+	}
+
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		response.StopReason = stringPtr("end_turn")
+		return response
+	}
+	candidateMap, _ := candidates[0].(map[string]interface{})
+	content, _ := candidateMap["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+
+	hasToolUse := false
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if functionCall, ok := partMap["functionCall"].(map[string]interface{}); ok {
+			response.Content = append(response.Content, functionCallToToolUse(functionCall))
+			hasToolUse = true
+			continue
+		}
+
+		text, ok := partMap["text"].(string)
+		if !ok {
+			continue
+		}
+		blockType := "text"
+		if thought, _ := partMap["thought"].(bool); thought {
+			blockType = "thinking"
+		}
+
+		// Merge consecutive parts of the same kind into one block
+		if last := len(response.Content) - 1; last >= 0 && response.Content[last].Type == blockType {
+			if blockType == "thinking" {
+				response.Content[last].Thinking += text
+			} else {
+				response.Content[last].Text += text
+			}
+			continue
+		}
+		if blockType == "thinking" {
+			response.Content = append(response.Content, models.AnthropicContentBlock{Type: "thinking", Thinking: text})
+		} else {
+			response.Content = append(response.Content, models.AnthropicContentBlock{Type: "text", Text: text})
+		}
+	}
+
+	response.StopReason = mapAnthropicStopReason(candidateMap["finishReason"], hasToolUse)
+	return response
+}
+
+// functionCallToToolUse converts a Gemini functionCall part into an Anthropic tool_use block
+func functionCallToToolUse(functionCall map[string]interface{}) models.AnthropicContentBlock {
+	name, _ := functionCall["name"].(string)
+	input, ok := functionCall["args"].(map[string]interface{})
+	if !ok {
+		input = map[string]interface{}{}
+	}
+	return models.AnthropicContentBlock{
+		Type:  "tool_use",
+		ID:    "toolu_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Name:  name,
+		Input: input,
+	}
+}
+
+// mapAnthropicStopReason maps Gemini finish reasons to Anthropic stop reasons
+func mapAnthropicStopReason(reason interface{}, hasToolUse bool) *string {
+	reasonStr, _ := reason.(string)
+	switch reasonStr {
+	case "MAX_TOKENS":
+		return stringPtr("max_tokens")
+	case "SAFETY", "RECITATION", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII":
+		return stringPtr("refusal")
+	}
+	if hasToolUse {
+		return stringPtr("tool_use")
+	}
+	return stringPtr("end_turn")
+}
+
+// anthropicUsage maps Gemini usageMetadata to Anthropic usage; thinking tokens
+// count as output
+func anthropicUsage(geminiResponse map[string]interface{}) models.AnthropicUsage {
+	usage, _ := geminiResponse["usageMetadata"].(map[string]interface{})
+	return models.AnthropicUsage{
+		InputTokens:  getInt(usage["promptTokenCount"], 0),
+		OutputTokens: getInt(usage["candidatesTokenCount"], 0) + getInt(usage["thoughtsTokenCount"], 0),
+	}
+}
+
+// AnthropicStreamTransformer converts Gemini streaming chunks into Anthropic
+// stream events, tracking the open content block across chunks
+type AnthropicStreamTransformer struct {
+	model      string
+	messageID  string
+	started    bool
+	blockIndex int
+	blockType  string // Type of the open block, or "" if none is open
+	hasToolUse bool
+	stopReason *string
+	usage      models.AnthropicUsage
+}
+
+// NewAnthropicStreamTransformer creates a stream transformer for a single streamed message
+func NewAnthropicStreamTransformer(model string, messageID string) *AnthropicStreamTransformer {
+	return &AnthropicStreamTransformer{model: model, messageID: messageID}
+}
+
+// Transform converts a Gemini chunk into Anthropic stream events
+func (t *AnthropicStreamTransformer) Transform(geminiChunk map[string]interface{}) []models.AnthropicStreamEvent {
+	var events []models.AnthropicStreamEvent
+
+	if _, ok := geminiChunk["usageMetadata"]; ok {
+		t.usage = anthropicUsage(geminiChunk)
+	}
+
+	if !t.started {
+		t.started = true
+		events = append(events, models.AnthropicStreamEvent{Type: "message_start", Data: map[string]interface{}{
+			"type": "message_start",
+			"message": models.AnthropicMessagesResponse{
+				ID:      t.messageID,
+				Type:    "message",
+				Role:    "assistant",
+				Model:   t.model,
+				Content: []models.AnthropicContentBlock{},
+				Usage:   models.AnthropicUsage{InputTokens: t.usage.InputTokens},
+			},
+		}})
+	}
+
+	candidates, _ := geminiChunk["candidates"].([]interface{})
+	if len(candidates) == 0 {
+		return events
+	}
+	candidateMap, _ := candidates[0].(map[string]interface{})
+	content, _ := candidateMap["content"].(map[string]interface{})
+	parts, _ := content["parts"].([]interface{})
+
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		if functionCall, ok := partMap["functionCall"].(map[string]interface{}); ok {
+			// Tool calls arrive whole, so each is a complete block
+			block := functionCallToToolUse(functionCall)
+			arguments, _ := json.Marshal(block.Input)
+			block.Input = map[string]interface{}{}
+			events = append(events, t.openBlock("tool_use", block)...)
+			events = append(events, t.blockDelta(map[string]interface{}{"type": "input_json_delta", "partial_json": string(arguments)}))
+			events = append(events, t.closeBlock()...)
+			t.hasToolUse = true
+			continue
+		}
+
+		text, ok := partMap["text"].(string)
+		if !ok || text == "" {
+			continue
+		}
+		if thought, _ := partMap["thought"].(bool); thought {
+			if t.blockType != "thinking" {
+				events = append(events, t.openBlock("thinking", models.AnthropicContentBlock{Type: "thinking"})...)
+			}
+			events = append(events, t.blockDelta(map[string]interface{}{"type": "thinking_delta", "thinking": text}))
+		} else {
+			if t.blockType != "text" {
+				events = append(events, t.openBlock("text", models.AnthropicContentBlock{Type: "text"})...)
+			}
+			events = append(events, t.blockDelta(map[string]interface{}{"type": "text_delta", "text": text}))
+		}
+	}
+
+	if reason, ok := candidateMap["finishReason"]; ok && reason != nil {
+		t.stopReason = mapAnthropicStopReason(reason, t.hasToolUse)
+	}
+
+	return events
+}
+
+// Truncate marks the message as cut off before Gemini finished, so Finish
+// reports a max_tokens stop reason
+func (t *AnthropicStreamTransformer) Truncate() {
+	t.stopReason = stringPtr("max_tokens")
+}
+
+// Finish closes any open block and emits the closing message events
+func (t *AnthropicStreamTransformer) Finish() []models.AnthropicStreamEvent {
+	var events []models.AnthropicStreamEvent
+	if !t.started {
+		events = append(events, t.Transform(map[string]interface{}{})...)
+	}
+	events = append(events, t.closeBlock()...)
+
+	stopReason := t.stopReason
+	if stopReason == nil {
+		stopReason = mapAnthropicStopReason(nil, t.hasToolUse)
+	}
+	events = append(events,
+		models.AnthropicStreamEvent{Type: "message_delta", Data: map[string]interface{}{
+			"type":  "message_delta",
+			"delta": map[string]interface{}{"stop_reason": stopReason, "stop_sequence": nil},
+			"usage": map[string]interface{}{"output_tokens": t.usage.OutputTokens},
+		}},
+		models.AnthropicStreamEvent{Type: "message_stop", Data: map[string]interface{}{"type": "message_stop"}},
+	)
+	return events
+}
+
+// openBlock closes any open block and starts a new one
+func (t *AnthropicStreamTransformer) openBlock(blockType string, block models.AnthropicContentBlock) []models.AnthropicStreamEvent {
+	events := t.closeBlock()
+	t.blockType = blockType
+
+	// Start events carry empty fields that omitempty would drop
+	contentBlock := map[string]interface{}{"type": blockType}
+	switch blockType {
+	case "text":
+		contentBlock["text"] = ""
+	case "thinking":
+		contentBlock["thinking"] = ""
+	case "tool_use":
+		contentBlock["id"] = block.ID
+		contentBlock["name"] = block.Name
+		contentBlock["input"] = block.Input
+	}
+
+	return append(events, models.AnthropicStreamEvent{Type: "content_block_start", Data: map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": contentBlock,
+	}})
+}
+
+// blockDelta emits a delta for the open block
+func (t *AnthropicStreamTransformer) blockDelta(delta map[string]interface{}) models.AnthropicStreamEvent {
+	return models.AnthropicStreamEvent{Type: "content_block_delta", Data: map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": t.blockIndex,
+		"delta": delta,
+	}}
+}
+
+// closeBlock emits a stop event for the open block, if any
+func (t *AnthropicStreamTransformer) closeBlock() []models.AnthropicStreamEvent {
+	if t.blockType == "" {
+		return nil
+	}
+	event := models.AnthropicStreamEvent{Type: "content_block_stop", Data: map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": t.blockIndex,
+	}}
+	t.blockType = ""
+	t.blockIndex++
+	return []models.AnthropicStreamEvent{event}
+}
@@ -0,0 +1,206 @@
+package transformers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// convertAnthropicRequest runs an Anthropic request given as JSON through AnthropicRequestToGemini
+func convertAnthropicRequest(t *testing.T, literal string) (map[string]interface{}, error) {
+	t.Helper()
+	var request models.AnthropicMessagesRequest
+	if err := json.Unmarshal([]byte(literal), &request); err != nil {
+		t.Fatalf("invalid test request %s: %v", literal, err)
+	}
+	return AnthropicRequestToGemini(&request, config.NewConfig())
+}
+
+func TestAnthropicContentBlocks(t *testing.T) {
+	payload, err := convertAnthropicRequest(t, `{"model": "gemini-2.5-flash", "max_tokens": 100, "system": "Be brief", "messages": [
+		{"role": "user", "content": "Describe this"},
+		{"role": "assistant", "content": [{"type": "thinking", "thinking": "Hmm"}, {"type": "text", "text": "Sure."}]},
+		{"role": "user", "content": [
+			{"type": "text", "text": "Here:"},
+			{"type": "text", "text": ""},
+			{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "aW1n"}}
+		]}]}`)
+	if err != nil {
+		t.Fatalf("AnthropicRequestToGemini() error = %v", err)
+	}
+
+	want := []map[string]interface{}{
+		{"role": "user", "parts": []map[string]interface{}{{"text": "Describe this"}}},
+		{"role": "model", "parts": []map[string]interface{}{{"text": "Sure."}}},
+		{"role": "user", "parts": []map[string]interface{}{
+			{"text": "Here:"},
+			{"inlineData": map[string]interface{}{"mimeType": "image/png", "data": "aW1n"}},
+		}},
+	}
+	if got := payload["contents"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("contents = %v, want %v", got, want)
+	}
+	wantSystem := map[string]interface{}{"parts": []map[string]interface{}{{"text": "Be brief"}}}
+	if got := payload["systemInstruction"]; !reflect.DeepEqual(got, wantSystem) {
+		t.Errorf("systemInstruction = %v, want %v", got, wantSystem)
+	}
+}
+
+func TestAnthropicEmptyContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"empty string", `{"role": "user", "content": ""}`},
+		{"only thinking", `{"role": "assistant", "content": [{"type": "thinking", "thinking": "Hmm"}]}`},
+		{"only empty text", `{"role": "assistant", "content": [{"type": "text", "text": ""}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := convertAnthropicRequest(t, `{"model": "gemini-2.5-flash", "max_tokens": 100, "messages": [
+				{"role": "user", "content": "Hi"}, `+tt.message+`]}`)
+
+			if err == nil || !strings.Contains(err.Error(), "messages[1]") {
+				t.Errorf("AnthropicRequestToGemini() error = %v, want one naming messages[1]", err)
+			}
+		})
+	}
+}
+
+func TestAnthropicToolUseMapping(t *testing.T) {
+	payload, err := convertAnthropicRequest(t, `{"model": "gemini-2.5-flash", "max_tokens": 100, "messages": [
+		{"role": "user", "content": "Weather in Paris?"},
+		{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+		{"role": "user", "content": [
+			{"type": "tool_result", "tool_use_id": "toolu_1", "content": "{\"temp\": 20}"},
+			{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "timeout"}], "is_error": true}
+		]}]}`)
+	if err != nil {
+		t.Fatalf("AnthropicRequestToGemini() error = %v", err)
+	}
+
+	contents := payload["contents"].([]map[string]interface{})
+	wantCall := []map[string]interface{}{{"functionCall": map[string]interface{}{"name": "get_weather", "args": map[string]interface{}{"city": "Paris"}}}}
+	if got := contents[1]["parts"]; !reflect.DeepEqual(got, wantCall) {
+		t.Errorf("tool_use parts = %v, want %v", got, wantCall)
+	}
+	wantResults := []map[string]interface{}{
+		{"functionResponse": map[string]interface{}{"name": "get_weather", "response": map[string]interface{}{"temp": float64(20)}}},
+		{"functionResponse": map[string]interface{}{"name": "get_weather", "response": map[string]interface{}{"content": "timeout", "is_error": true}}},
+	}
+	if got := contents[2]["parts"]; !reflect.DeepEqual(got, wantResults) {
+		t.Errorf("tool_result parts = %v, want %v", got, wantResults)
+	}
+
+	_, err = convertAnthropicRequest(t, `{"model": "gemini-2.5-flash", "max_tokens": 100, "messages": [
+		{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_missing", "content": "42"}]}]}`)
+	if err == nil || !strings.Contains(err.Error(), "messages[0]") || !strings.Contains(err.Error(), "toolu_missing") {
+		t.Errorf("unknown tool_use_id error = %v, want one naming messages[0] and the id", err)
+	}
+}
+
+func TestGeminiResponseToAnthropicStopReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		finishReason string
+		parts        string
+		want         string
+	}{
+		{"stop", "STOP", `[{"text": "Hi"}]`, "end_turn"},
+		{"max tokens", "MAX_TOKENS", `[{"text": "Hi"}]`, "max_tokens"},
+		{"safety", "SAFETY", `[]`, "refusal"},
+		{"tool call", "STOP", `[{"functionCall": {"name": "f", "args": {}}}]`, "tool_use"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			geminiResponse := decodeJSON(t, `{"candidates": [{"content": {"role": "model", "parts": `+tt.parts+`}, "finishReason": "`+tt.finishReason+`"}]}`)
+
+			response := GeminiResponseToAnthropic(geminiResponse.(map[string]interface{}), "gemini-2.5-flash", "msg_1")
+
+			if response.StopReason == nil || *response.StopReason != tt.want {
+				t.Errorf("stop_reason = %v, want %s", response.StopReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeminiResponseToAnthropicContent(t *testing.T) {
+	geminiResponse := decodeJSON(t, `{"candidates": [{"content": {"role": "model", "parts": [
+		{"text": "Plan", "thought": true},
+		{"text": "Hello"},
+		{"text": " there"},
+		{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}
+	]}, "finishReason": "STOP"}], "usageMetadata": {"promptTokenCount": 5, "candidatesTokenCount": 3, "thoughtsTokenCount": 2}}`)
+
+	response := GeminiResponseToAnthropic(geminiResponse.(map[string]interface{}), "gemini-2.5-flash", "msg_1")
+
+	if len(response.Content) != 3 {
+		t.Fatalf("content = %+v, want thinking, text and tool_use blocks", response.Content)
+	}
+	if block := response.Content[0]; block.Type != "thinking" || block.Thinking != "Plan" {
+		t.Errorf("block 0 = %+v, want the thinking block", block)
+	}
+	if block := response.Content[1]; block.Type != "text" || block.Text != "Hello there" {
+		t.Errorf("block 1 = %+v, want the joined text", block)
+	}
+	if block := response.Content[2]; block.Type != "tool_use" || block.Name != "get_weather" || !strings.HasPrefix(block.ID, "toolu_") {
+		t.Errorf("block 2 = %+v, want a get_weather tool_use", block)
+	}
+	if want := (models.AnthropicUsage{InputTokens: 5, OutputTokens: 5}); response.Usage != want {
+		t.Errorf("usage = %+v, want %+v", response.Usage, want)
+	}
+}
+
+func TestAnthropicStreamEventOrder(t *testing.T) {
+	transformer := NewAnthropicStreamTransformer("gemini-2.5-flash", "msg_1")
+	var events []models.AnthropicStreamEvent
+	for _, chunk := range []string{
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Plan", "thought": true}]}}], "usageMetadata": {"promptTokenCount": 5}}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}, {"text": " there"}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "f", "args": {"a": 1}}}]}, "finishReason": "STOP"}],
+			"usageMetadata": {"promptTokenCount": 5, "candidatesTokenCount": 4}}`,
+	} {
+		events = append(events, transformer.Transform(decodeJSON(t, chunk).(map[string]interface{}))...)
+	}
+	events = append(events, transformer.Finish()...)
+
+	want := []string{
+		"message_start",
+		"content_block_start 0 thinking", "content_block_delta 0", "content_block_stop 0",
+		"content_block_start 1 text", "content_block_delta 1", "content_block_delta 1", "content_block_stop 1",
+		"content_block_start 2 tool_use", "content_block_delta 2", "content_block_stop 2",
+		"message_delta", "message_stop",
+	}
+	var got []string
+	for _, event := range events {
+		if event.Data["type"] != event.Type {
+			t.Errorf("event %s carries data type %v", event.Type, event.Data["type"])
+		}
+		description := event.Type
+		if index, ok := event.Data["index"]; ok {
+			description += fmt.Sprint(" ", index)
+		}
+		if block, ok := event.Data["content_block"].(map[string]interface{}); ok {
+			description += " " + block["type"].(string)
+		}
+		got = append(got, description)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+
+	messageDelta := events[len(events)-2].Data
+	if delta := messageDelta["delta"].(map[string]interface{}); *delta["stop_reason"].(*string) != "tool_use" {
+		t.Errorf("message_delta stop_reason = %v, want tool_use", *delta["stop_reason"].(*string))
+	}
+	if usage := messageDelta["usage"].(map[string]interface{}); usage["output_tokens"] != 4 {
+		t.Errorf("message_delta usage = %v, want 4 output tokens", usage)
+	}
+}
@@ -0,0 +1,101 @@
+package transformers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"geminicli2api/pkg/models"
+)
+
+// defaultPCMRate is the sample rate of Gemini's speech output when the
+// mimeType doesn't name one
+const defaultPCMRate = 24000
+
+// newAudioID returns an OpenAI-style ID for a choice's audio output
+func newAudioID() string {
+	return "audio_" + uuid.New().String()
+}
+
+// audioClip collects the audio parts of one candidate. Gemini splits speech
+// across several inlineData parts, which together make a single clip.
+type audioClip struct {
+	mimeType string
+	data     []byte
+}
+
+// add appends a part's base64 data to the clip
+func (a *audioClip) add(mimeType string, data string) {
+	decoded, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+	if a.mimeType == "" {
+		a.mimeType = mimeType
+	}
+	a.data = append(a.data, decoded...)
+}
+
+// output returns the clip as OpenAI audio, or nil when there is none. Raw PCM
+// is wrapped in a WAV container so clients know its sample rate and format.
+func (a *audioClip) output() *models.OpenAIAudio {
+	if len(a.data) == 0 {
+		return nil
+	}
+	mimeType, data := a.mimeType, a.data
+	if rate, ok := pcmRate(mimeType); ok {
+		mimeType, data = "audio/wav", wavFromPCM(data, rate)
+	}
+	return &models.OpenAIAudio{
+		ID:       newAudioID(),
+		Data:     base64.StdEncoding.EncodeToString(data),
+		MimeType: mimeType,
+	}
+}
+
+// pcmRate reports whether mimeType is raw 16-bit PCM, such as Gemini's
+// audio/L16;codec=pcm;rate=24000, and its sample rate
+func pcmRate(mimeType string) (int, bool) {
+	params := strings.Split(mimeType, ";")
+	switch strings.ToLower(strings.TrimSpace(params[0])) {
+	case "audio/l16", "audio/pcm":
+	default:
+		return 0, false
+	}
+	for _, param := range params[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, "rate") {
+			if rate, err := strconv.Atoi(value); err == nil && rate > 0 {
+				return rate, true
+			}
+		}
+	}
+	return defaultPCMRate, true
+}
+
+// wavFromPCM wraps mono 16-bit little-endian PCM samples, as Gemini returns
+// them, in a WAV header
+func wavFromPCM(pcm []byte, rate int) []byte {
+	const channels, bitsPerSample = 1, 16
+	blockAlign := channels * bitsPerSample / 8
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	binary.Write(&buf, binary.LittleEndian, uint32(16))
+	binary.Write(&buf, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate))
+	binary.Write(&buf, binary.LittleEndian, uint32(rate*blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	binary.Write(&buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
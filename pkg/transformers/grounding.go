@@ -0,0 +1,90 @@
+package transformers
+
+import (
+	"unicode/utf8"
+
+	"geminicli2api/pkg/models"
+)
+
+// AddGroundingCitations attaches each candidate's grounding metadata to its
+// choice as url_citation annotations, for INCLUDE_GROUNDING_CITATIONS. It must
+// run while choice content is still a string.
+func AddGroundingCitations(response *models.OpenAIChatCompletionResponse, geminiResponse map[string]interface{}) {
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	for position, candidate := range candidates {
+		candidateMap, ok := candidate.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index := getInt(candidateMap["index"], position)
+		for _, choice := range response.Choices {
+			if choice.Index != index {
+				continue
+			}
+			text, _ := choice.Message.Content.(string)
+			choice.Message.Annotations = groundingAnnotations(candidateMap, text)
+		}
+	}
+}
+
+// groundingAnnotations converts a candidate's groundingMetadata into
+// url_citation annotations, one per cited source of each supported segment.
+// Gemini gives segment offsets in bytes of the response text; they become
+// character offsets into text. Sources without supports cite the whole text.
+func groundingAnnotations(candidate map[string]interface{}, text string) []models.OpenAIAnnotation {
+	metadata, ok := candidate["groundingMetadata"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	var sources []models.OpenAIURLCitation
+	chunks, _ := metadata["groundingChunks"].([]interface{})
+	for _, chunk := range chunks {
+		chunkMap, _ := chunk.(map[string]interface{})
+		source, ok := chunkMap["web"].(map[string]interface{})
+		if !ok {
+			source, _ = chunkMap["retrievedContext"].(map[string]interface{})
+		}
+		url, _ := source["uri"].(string)
+		title, _ := source["title"].(string)
+		sources = append(sources, models.OpenAIURLCitation{URL: url, Title: title})
+	}
+
+	var annotations []models.OpenAIAnnotation
+	supports, _ := metadata["groundingSupports"].([]interface{})
+	for _, support := range supports {
+		supportMap, _ := support.(map[string]interface{})
+		segment, _ := supportMap["segment"].(map[string]interface{})
+		start := charOffset(text, getInt(segment["startIndex"], 0))
+		end := charOffset(text, getInt(segment["endIndex"], 0))
+		indices, _ := supportMap["groundingChunkIndices"].([]interface{})
+		for _, i := range indices {
+			i := getInt(i, -1)
+			if i < 0 || i >= len(sources) || sources[i].URL == "" {
+				continue
+			}
+			citation := sources[i]
+			citation.StartIndex, citation.EndIndex = start, end
+			annotations = append(annotations, models.OpenAIAnnotation{Type: "url_citation", URLCitation: citation})
+		}
+	}
+
+	if len(supports) == 0 {
+		for _, citation := range sources {
+			if citation.URL == "" {
+				continue
+			}
+			citation.EndIndex = utf8.RuneCountInString(text)
+			annotations = append(annotations, models.OpenAIAnnotation{Type: "url_citation", URLCitation: citation})
+		}
+	}
+	return annotations
+}
+
+// charOffset converts a byte offset into text to a character offset
+func charOffset(text string, byteOffset int) int {
+	if byteOffset > len(text) {
+		byteOffset = len(text)
+	}
+	return utf8.RuneCountInString(text[:byteOffset])
+}
@@ -0,0 +1,63 @@
+package transformers
+
+import (
+	"reflect"
+	"testing"
+
+	"geminicli2api/pkg/models"
+)
+
+const groundingMetadata = `"groundingMetadata": {
+	"groundingChunks": [
+		{"web": {"uri": "https://example.com/paris", "title": "Paris"}},
+		{"web": {"uri": "https://example.com/france", "title": "France"}}
+	],
+	"groundingSupports": [
+		{"segment": {"startIndex": 0, "endIndex": 21}, "groundingChunkIndices": [0]},
+		{"segment": {"startIndex": 22, "endIndex": 38}, "groundingChunkIndices": [0, 1]}
+	]}`
+
+// wantCitations are the annotations for groundingMetadata on "Paris é the capital. It is in France."
+var wantCitations = []models.OpenAIAnnotation{
+	{Type: "url_citation", URLCitation: models.OpenAIURLCitation{URL: "https://example.com/paris", Title: "Paris", StartIndex: 0, EndIndex: 20}},
+	{Type: "url_citation", URLCitation: models.OpenAIURLCitation{URL: "https://example.com/paris", Title: "Paris", StartIndex: 21, EndIndex: 37}},
+	{Type: "url_citation", URLCitation: models.OpenAIURLCitation{URL: "https://example.com/france", Title: "France", StartIndex: 21, EndIndex: 37}},
+}
+
+func TestAddGroundingCitations(t *testing.T) {
+	literal := `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Paris é the capital. It is in France."}]},
+		"finishReason": "STOP", ` + groundingMetadata + `}]}`
+	response := convertResponse(t, literal)
+
+	AddGroundingCitations(response, decodeJSON(t, literal).(map[string]interface{}))
+
+	if got := response.Choices[0].Message.Annotations; !reflect.DeepEqual(got, wantCitations) {
+		t.Errorf("annotations = %+v, want %+v with character offsets", got, wantCitations)
+	}
+}
+
+func TestTransformGroundingCitations(t *testing.T) {
+	transformer := NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp")
+	transformer.IncludeCitations()
+
+	responses := transformChunks(t, transformer,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Paris é the capital."}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": " It is in France."}]}, `+groundingMetadata+`}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": []}, "finishReason": "STOP", `+groundingMetadata+`}]}`,
+	)
+
+	var annotated []int
+	var got []models.OpenAIAnnotation
+	for i, response := range responses {
+		if annotations := response.Choices[0].Delta.Annotations; len(annotations) > 0 {
+			annotated = append(annotated, i)
+			got = append(got, annotations...)
+		}
+	}
+	if len(annotated) != 1 {
+		t.Errorf("annotations on chunks %v, want them once on the chunk carrying the metadata", annotated)
+	}
+	if !reflect.DeepEqual(got, wantCitations) {
+		t.Errorf("annotations = %+v, want %+v", got, wantCitations)
+	}
+}
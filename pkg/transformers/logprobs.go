@@ -0,0 +1,88 @@
+package transformers
+
+import (
+	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// applyLogprobs asks Gemini for token log probabilities. As with OpenAI,
+// top_logprobs above 0 implies logprobs unless logprobs is explicitly false.
+// top_logprobs above what Gemini supports is clamped to config.MaxLogprobs.
+func applyLogprobs(generationConfig map[string]interface{}, openaiRequest *models.OpenAIChatCompletionRequest) error {
+	topLogprobs := 0
+	if openaiRequest.TopLogprobs != nil {
+		topLogprobs = *openaiRequest.TopLogprobs
+		if topLogprobs < 0 {
+			return apierrors.InvalidParam("top_logprobs", apierrors.CodeInvalidValue, "top_logprobs must be between 0 and %d, got %d", config.MaxLogprobs, topLogprobs)
+		}
+		if topLogprobs > config.MaxLogprobs {
+			topLogprobs = config.MaxLogprobs
+		}
+	}
+
+	enabled := topLogprobs > 0
+	if openaiRequest.Logprobs != nil {
+		enabled = *openaiRequest.Logprobs
+	}
+	if !enabled {
+		return nil
+	}
+	generationConfig["responseLogprobs"] = true
+	if topLogprobs > 0 {
+		generationConfig["logprobs"] = topLogprobs
+	}
+	return nil
+}
+
+// logprobsToOpenAI converts a candidate's logprobsResult into OpenAI logprobs,
+// pairing each chosen token with the top candidates at its position. Returns
+// nil when Gemini sent none.
+func logprobsToOpenAI(logprobsResult interface{}) *models.OpenAILogprobs {
+	result, ok := logprobsResult.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	chosen, _ := result["chosenCandidates"].([]interface{})
+	if len(chosen) == 0 {
+		return nil
+	}
+	top, _ := result["topCandidates"].([]interface{})
+
+	logprobs := &models.OpenAILogprobs{Content: []models.OpenAITokenLogprob{}}
+	for i, candidate := range chosen {
+		token, logprob := tokenLogprob(candidate)
+		entry := models.OpenAITokenLogprob{
+			Token:       token,
+			Logprob:     logprob,
+			Bytes:       tokenBytes(token),
+			TopLogprobs: []models.OpenAITopLogprob{},
+		}
+		if i < len(top) {
+			position, _ := top[i].(map[string]interface{})
+			alternatives, _ := position["candidates"].([]interface{})
+			for _, alternative := range alternatives {
+				token, logprob := tokenLogprob(alternative)
+				entry.TopLogprobs = append(entry.TopLogprobs, models.OpenAITopLogprob{Token: token, Logprob: logprob, Bytes: tokenBytes(token)})
+			}
+		}
+		logprobs.Content = append(logprobs.Content, entry)
+	}
+	return logprobs
+}
+
+func tokenLogprob(candidate interface{}) (string, float64) {
+	candidateMap, _ := candidate.(map[string]interface{})
+	token, _ := candidateMap["token"].(string)
+	logprob, _ := candidateMap["logProbability"].(float64)
+	return token, logprob
+}
+
+// tokenBytes returns a token's UTF-8 bytes, as OpenAI reports them
+func tokenBytes(token string) []int {
+	bytes := make([]int, len(token))
+	for i := 0; i < len(token); i++ {
+		bytes[i] = int(token[i])
+	}
+	return bytes
+}
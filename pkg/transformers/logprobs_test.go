@@ -0,0 +1,36 @@
+package transformers
+
+import (
+	"testing"
+
+	"geminicli2api/pkg/config"
+)
+
+func TestApplyLogprobs(t *testing.T) {
+	tests := []struct {
+		name         string
+		params       string
+		wantResponse interface{} // nil when responseLogprobs isn't set
+		wantTop      interface{}
+	}{
+		{"top_logprobs implies logprobs", `"top_logprobs": 3`, true, 3},
+		{"clamped to the maximum", `"top_logprobs": 50`, true, config.MaxLogprobs},
+		{"logprobs alone", `"logprobs": true`, true, nil},
+		{"explicitly disabled", `"logprobs": false, "top_logprobs": 3`, nil, nil},
+		{"zero top_logprobs", `"top_logprobs": 0`, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, generationConfig := convertRequest(t, config.NewConfig(),
+				`{"model": "gemini-2.5-flash", `+tt.params+`, "messages": [{"role": "user", "content": "Hi"}]}`)
+
+			if got := generationConfig["responseLogprobs"]; got != tt.wantResponse {
+				t.Errorf("responseLogprobs = %v, want %v", got, tt.wantResponse)
+			}
+			if got := generationConfig["logprobs"]; got != tt.wantTop {
+				t.Errorf("logprobs = %v, want %v", got, tt.wantTop)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package transformers
+
+import (
+	"geminicli2api/pkg/models"
+)
+
+// moderationCategories is OpenAI's moderation category set. Every category is
+// always reported, as OpenAI does, even those Gemini has no rating for.
+var moderationCategories = []string{
+	"harassment",
+	"harassment/threatening",
+	"hate",
+	"hate/threatening",
+	"illicit",
+	"illicit/violent",
+	"self-harm",
+	"self-harm/intent",
+	"self-harm/instructions",
+	"sexual",
+	"sexual/minors",
+	"violence",
+	"violence/graphic",
+}
+
+// safetyCategoryMapping maps Gemini harm categories to the OpenAI moderation
+// categories they approximate. Dangerous content is Gemini's catch-all for
+// harmful instructions, so it stands in for violence, self-harm and illicit
+// activity alike.
+var safetyCategoryMapping = map[string][]string{
+	"HARM_CATEGORY_HARASSMENT":        {"harassment"},
+	"HARM_CATEGORY_HATE_SPEECH":       {"hate"},
+	"HARM_CATEGORY_SEXUALLY_EXPLICIT": {"sexual"},
+	"HARM_CATEGORY_DANGEROUS_CONTENT": {"violence", "self-harm", "illicit"},
+}
+
+// probabilityScores approximates a score for Gemini's probability levels when
+// no numeric probabilityScore is given
+var probabilityScores = map[string]float64{
+	"NEGLIGIBLE": 0.01,
+	"LOW":        0.25,
+	"MEDIUM":     0.6,
+	"HIGH":       0.9,
+}
+
+// GeminiSafetyToModeration converts the safety ratings of a Gemini safety
+// evaluation into an OpenAI moderation result. The prompt's ratings are used,
+// falling back to the first candidate's. A category is flagged when Gemini
+// rated it MEDIUM or HIGH; the evaluation blocks from LOW up, so "blocked"
+// alone isn't enough. A prompt blocked for a reason other than safety, such as
+// PROHIBITED_CONTENT, is flagged as a whole.
+func GeminiSafetyToModeration(geminiResponse map[string]interface{}) models.OpenAIModerationResult {
+	result := models.OpenAIModerationResult{
+		Categories:     make(map[string]bool, len(moderationCategories)),
+		CategoryScores: make(map[string]float64, len(moderationCategories)),
+	}
+	for _, category := range moderationCategories {
+		result.Categories[category] = false
+		result.CategoryScores[category] = 0
+	}
+
+	feedback, _ := geminiResponse["promptFeedback"].(map[string]interface{})
+	ratings, _ := feedback["safetyRatings"].([]interface{})
+	if len(ratings) == 0 {
+		if candidates, _ := geminiResponse["candidates"].([]interface{}); len(candidates) > 0 {
+			candidate, _ := candidates[0].(map[string]interface{})
+			ratings, _ = candidate["safetyRatings"].([]interface{})
+		}
+	}
+
+	for _, r := range ratings {
+		rating, _ := r.(map[string]interface{})
+		category, _ := rating["category"].(string)
+		probability, _ := rating["probability"].(string)
+		score, ok := rating["probabilityScore"].(float64)
+		if !ok {
+			score = probabilityScores[probability]
+		}
+		flagged := probability == "MEDIUM" || probability == "HIGH"
+
+		for _, openaiCategory := range safetyCategoryMapping[category] {
+			if score > result.CategoryScores[openaiCategory] {
+				result.CategoryScores[openaiCategory] = score
+			}
+			if flagged {
+				result.Categories[openaiCategory] = true
+				result.Flagged = true
+			}
+		}
+	}
+
+	if blockReason, _ := feedback["blockReason"].(string); blockReason != "" && blockReason != "SAFETY" {
+		result.Flagged = true
+	}
+	return result
+}
@@ -1,26 +1,48 @@
 package transformers
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 
+	"geminicli2api/pkg/builtintools"
 	"geminicli2api/pkg/models"
 	"geminicli2api/pkg/config"
+	apierrors "geminicli2api/pkg/errors"
 )
 
 // OpenAIRequestToGemini transforms an OpenAI chat completion request to Gemini format
-func OpenAIRequestToGemini(openaiRequest *models.OpenAIChatCompletionRequest) (map[string]interface{}, error) {
+func OpenAIRequestToGemini(openaiRequest *models.OpenAIChatCompletionRequest, cfg *config.Config) (map[string]interface{}, error) {
 	contents := []map[string]interface{}{}
 
-	// Process each message in the conversation
-	for _, message := range openaiRequest.Messages {
+	// Tool call IDs mapped to function names, for resolving tool results
+	toolCallNames := map[string]string{}
+
+	// Process each message in the conversation, trimmed to the configured budget
+	for _, message := range truncateMessages(openaiRequest.Messages, cfg) {
 		role := message.Role
 
+		// Tool results become functionResponse parts
+		if role == "tool" {
+			part, err := toolMessageToPart(message, toolCallNames)
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, map[string]interface{}{
+				"role":  "user",
+				"parts": []map[string]interface{}{part},
+			})
+			continue
+		}
+
 		// Map OpenAI roles to Gemini roles
 		if role == "assistant" {
 			role = "model"
@@ -28,10 +50,25 @@ func OpenAIRequestToGemini(openaiRequest *models.OpenAIChatCompletionRequest) (m
 			role = "user" // Gemini treats system messages as user messages
 		}
 
-		// Handle different content types
-		parts, err := processContent(message.Content)
-		if err != nil {
-			return nil, fmt.Errorf("failed to process content: %w", err)
+		// Handle different content types; assistant tool calls may have no content
+		var parts []map[string]interface{}
+		if message.Content != nil || len(message.ToolCalls) == 0 {
+			var err error
+			parts, err = processContent(message.Content)
+			if err != nil {
+				return nil, fmt.Errorf("failed to process content: %w", err)
+			}
+		}
+
+		if len(message.ToolCalls) > 0 {
+			callParts, err := toolCallsToParts(message.ToolCalls)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, callParts...)
+			for _, toolCall := range message.ToolCalls {
+				toolCallNames[toolCall.ID] = toolCall.Function.Name
+			}
 		}
 
 		contents = append(contents, map[string]interface{}{
@@ -43,6 +80,9 @@ func OpenAIRequestToGemini(openaiRequest *models.OpenAIChatCompletionRequest) (m
 	// Map OpenAI generation parameters to Gemini format
 	generationConfig := map[string]interface{}{}
 
+	if err := validateSamplingParams(openaiRequest); err != nil {
+		return nil, err
+	}
 	if openaiRequest.Temperature != nil {
 		generationConfig["temperature"] = *openaiRequest.Temperature
 	}
@@ -51,154 +91,238 @@ func OpenAIRequestToGemini(openaiRequest *models.OpenAIChatCompletionRequest) (m
 	}
 	if openaiRequest.MaxTokens != nil {
 		generationConfig["maxOutputTokens"] = *openaiRequest.MaxTokens
+	} else if maxTokens := cfg.GetDefaultMaxOutputTokens(openaiRequest.Model); maxTokens > 0 {
+		generationConfig["maxOutputTokens"] = maxTokens
 	}
+	var stopSequences []string
 	if openaiRequest.Stop != nil {
 		// Gemini supports stop sequences
+		// JSON arrays decode as []interface{}; numbers are accepted as their text
 		switch stop := openaiRequest.Stop.(type) {
 		case string:
-			generationConfig["stopSequences"] = []string{stop}
+			stopSequences = []string{stop}
+		case float64:
+			stopSequences = []string{strconv.FormatFloat(stop, 'f', -1, 64)}
 		case []string:
-			generationConfig["stopSequences"] = stop
+			stopSequences = stop
+		case []interface{}:
+			for _, item := range stop {
+				switch value := item.(type) {
+				case string:
+					stopSequences = append(stopSequences, value)
+				case float64:
+					stopSequences = append(stopSequences, strconv.FormatFloat(value, 'f', -1, 64))
+				}
+			}
 		}
 	}
-	if openaiRequest.FrequencyPenalty != nil {
-		generationConfig["frequencyPenalty"] = *openaiRequest.FrequencyPenalty
+	if stopSequences = cfg.MergeStopSequences(stopSequences); len(stopSequences) > 0 {
+		generationConfig["stopSequences"] = stopSequences
 	}
-	if openaiRequest.PresencePenalty != nil {
-		generationConfig["presencePenalty"] = *openaiRequest.PresencePenalty
+	if openaiRequest.FrequencyPenalty != nil || openaiRequest.PresencePenalty != nil {
+		// Models that reject penalties get neither; UnsupportedParams reports them
+		if supportsPenalties(openaiRequest.Model, cfg) {
+			if openaiRequest.FrequencyPenalty != nil {
+				generationConfig["frequencyPenalty"] = clampPenalty(*openaiRequest.FrequencyPenalty)
+			}
+			if openaiRequest.PresencePenalty != nil {
+				generationConfig["presencePenalty"] = clampPenalty(*openaiRequest.PresencePenalty)
+			}
+		}
 	}
 	if openaiRequest.N != nil {
-		generationConfig["candidateCount"] = *openaiRequest.N
+		n := *openaiRequest.N
+		maxCandidates := cfg.GetMaxCandidateCount(openaiRequest.Model)
+		if n < 1 || n > maxCandidates {
+			return nil, apierrors.InvalidParam("n", apierrors.CodeInvalidValue, "n must be between 1 and %d (the maximum candidate count for this model), got %d", maxCandidates, n)
+		}
+		// candidateCount defaults to 1 upstream, so only send it when needed
+		if n > 1 {
+			generationConfig["candidateCount"] = n
+		}
 	}
 	if openaiRequest.Seed != nil {
 		generationConfig["seed"] = *openaiRequest.Seed
 	}
+	if err := applyLogprobs(generationConfig, openaiRequest); err != nil {
+		return nil, err
+	}
 	if openaiRequest.ResponseFormat != nil {
 		if formatType, ok := openaiRequest.ResponseFormat["type"].(string); ok && formatType == "json_object" {
 			generationConfig["responseMimeType"] = "application/json"
 		}
 	}
+	// Fall back to the model's sampling defaults for anything the client omitted
+	cfg.ApplyModelDefaults(generationConfig, openaiRequest.Model)
+	// Map requested output modalities; Gemini defaults to text-only when unset
+	if modalities := getResponseModalities(openaiRequest); len(modalities) > 0 {
+		generationConfig["responseModalities"] = modalities
+	}
 
 	// Build the request payload
 	requestPayload := map[string]interface{}{
 		"contents":        contents,
 		"generationConfig": generationConfig,
-		"safetySettings":  getDefaultSafetySettings(),
 		"model":           config.GetBaseModelName(openaiRequest.Model),
 	}
 
+	// Safety settings are owned by the client layer, which applies the configured
+	// defaults; only forward an explicit per-request override
+	if safetySettings, ok := openaiRequest.ExtraBody["safety_settings"].([]interface{}); ok {
+		requestPayload["safetySettings"] = safetySettings
+	}
+
+	// Add function declarations and the calling mode
+	var tools []map[string]interface{}
+	functionTool, err := toolsToGemini(openaiRequest.Tools)
+	if err != nil {
+		return nil, err
+	}
+	// Declare the built-in tools the proxy runs itself, unless the client
+	// declares a function of the same name
+	if BuiltinToolsEnabled(openaiRequest, cfg) {
+		declared := map[string]bool{}
+		for _, tool := range openaiRequest.Tools {
+			declared[tool.Function.Name] = true
+		}
+		if builtins := builtintools.Declarations(cfg.BuiltinTools, declared); len(builtins) > 0 {
+			if functionTool == nil {
+				functionTool = map[string]interface{}{"functionDeclarations": []map[string]interface{}{}}
+			}
+			functionTool["functionDeclarations"] = append(functionTool["functionDeclarations"].([]map[string]interface{}), builtins...)
+		}
+	}
+	if functionTool != nil {
+		tools = append(tools, functionTool)
+	}
+	if openaiRequest.ToolChoice != nil {
+		toolConfig, err := toolChoiceToGemini(openaiRequest.ToolChoice)
+		if err != nil {
+			return nil, err
+		}
+		requestPayload["toolConfig"] = toolConfig
+	}
+
 	// Add Google Search grounding for search models
 	if config.IsSearchModel(openaiRequest.Model) {
-		requestPayload["tools"] = []map[string]interface{}{{"googleSearch": map[string]interface{}{}}}
+		tools = append(tools, map[string]interface{}{"googleSearch": map[string]interface{}{}})
+	}
+	if len(tools) > 0 {
+		requestPayload["tools"] = tools
 	}
 
 	// Add thinking configuration for thinking models
-	if !strings.Contains(openaiRequest.Model, "gemini-2.5-flash-image") {
-		thinkingBudget := config.GetThinkingBudget(openaiRequest.Model)
-		if thinkingBudget != -1 {
-			if generationConfig["thinkingConfig"] == nil {
-				generationConfig["thinkingConfig"] = map[string]interface{}{}
-			}
-			thinkingConfig := generationConfig["thinkingConfig"].(map[string]interface{})
-			thinkingConfig["thinkingBudget"] = thinkingBudget
-			thinkingConfig["includeThoughts"] = config.ShouldIncludeThoughts(openaiRequest.Model)
-		}
+	applyThinkingConfig(generationConfig, openaiRequest.Model, cfg)
+	// Thoughts that will be discarded anyway needn't be sent at all
+	if thinkingConfig, ok := generationConfig["thinkingConfig"].(map[string]interface{}); ok && cfg.StripThinking {
+		thinkingConfig["includeThoughts"] = false
 	}
 
 	return requestPayload, nil
 }
 
-// GeminiResponseToOpenAI transforms a Gemini API response to OpenAI chat completion format
-func GeminiResponseToOpenAI(geminiResponse map[string]interface{}, model string) *models.OpenAIChatCompletionResponse {
-	choices := []*models.OpenAIChatCompletionChoice{}
-
-	candidates, _ := geminiResponse["candidates"].([]interface{})
-	for _, candidate := range candidates {
-		candidateMap, ok := candidate.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		content, _ := candidateMap["content"].(map[string]interface{})
-		role, _ := content["role"].(string)
-
-		// Map Gemini roles back to OpenAI roles
-		if role == "model" {
-			role = "assistant"
-		}
-
-		// Extract and separate thinking tokens from regular content
-		parts, _ := content["parts"].([]interface{})
-		var contentParts []string
-		var reasoningContent string
+// BuiltinToolsEnabled reports whether the proxy runs its BUILTIN_TOOLS for a
+// request. The tool loop only runs for single-choice, non-streaming requests
+// to models without Search grounding, and not when tool_choice is "none".
+func BuiltinToolsEnabled(openaiRequest *models.OpenAIChatCompletionRequest, cfg *config.Config) bool {
+	if len(cfg.BuiltinTools) == 0 || openaiRequest.Stream || config.IsSearchModel(openaiRequest.Model) {
+		return false
+	}
+	if openaiRequest.N != nil && *openaiRequest.N > 1 {
+		return false
+	}
+	return openaiRequest.ToolChoice != "none"
+}
 
-		for _, part := range parts {
-			partMap, ok := part.(map[string]interface{})
-			if !ok {
-				continue
-			}
+// validateSamplingParams checks sampling parameters against the ranges Gemini
+// accepts, naming the offending parameter
+func validateSamplingParams(openaiRequest *models.OpenAIChatCompletionRequest) error {
+	if t := openaiRequest.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return apierrors.InvalidParam("temperature", apierrors.CodeInvalidValue, "temperature must be between 0 and 2, got %v", *t)
+	}
+	if p := openaiRequest.TopP; p != nil && (*p < 0 || *p > 1) {
+		return apierrors.InvalidParam("top_p", apierrors.CodeInvalidValue, "top_p must be between 0 and 1, got %v", *p)
+	}
+	if m := openaiRequest.MaxTokens; m != nil && *m < 1 {
+		return apierrors.InvalidParam("max_tokens", apierrors.CodeInvalidValue, "max_tokens must be at least 1, got %d", *m)
+	}
+	return nil
+}
 
-			// Text parts (may include thinking tokens)
-			if text, ok := partMap["text"].(string); ok {
-				if thought, ok := partMap["thought"].(bool); ok && thought {
-					reasoningContent += text
-				} else {
-					contentParts = append(contentParts, text)
-				}
-				continue
-			}
+// ServiceTier returns the service_tier to report for a request. Gemini has no
+// tiers, so the requested one is echoed back, with "auto" resolving to
+// "default" as OpenAI does. Empty when the request didn't set one.
+func ServiceTier(openaiRequest *models.OpenAIChatCompletionRequest) string {
+	if openaiRequest.ServiceTier == "auto" {
+		return "default"
+	}
+	return openaiRequest.ServiceTier
+}
 
-			// Inline image data -> embed as Markdown data URI
-			if inlineData, ok := partMap["inlineData"].(map[string]interface{}); ok {
-				if data, ok := inlineData["data"].(string); ok && data != "" {
-					mimeType := "image/png"
-					if mime, ok := inlineData["mimeType"].(string); ok {
-						mimeType = mime
-					}
-					if strings.HasPrefix(mimeType, "image/") {
-						contentParts = append(contentParts, fmt.Sprintf("![image](data:%s;base64,%s)", mimeType, data))
-					}
-				}
-			}
+// UnsupportedParams lists request parameters that were sent but have no Gemini
+// equivalent or aren't accepted by the requested model, so they're ignored
+func UnsupportedParams(openaiRequest *models.OpenAIChatCompletionRequest, cfg *config.Config) []string {
+	var params []string
+	if openaiRequest.Prediction != nil {
+		params = append(params, "prediction")
+	}
+	if !supportsPenalties(openaiRequest.Model, cfg) {
+		if openaiRequest.FrequencyPenalty != nil {
+			params = append(params, "frequency_penalty")
 		}
-
-		contentText := strings.Join(contentParts, "\n\n")
-
-		// Build message object
-		message := models.OpenAIChatMessage{
-			Role:    role,
-			Content: contentText,
+		if openaiRequest.PresencePenalty != nil {
+			params = append(params, "presence_penalty")
 		}
+	}
+	return params
+}
 
-		// Add reasoning_content if there are thinking tokens
-		if reasoningContent != "" {
-			message.ReasoningContent = &reasoningContent
-		}
+// supportsPenalties reports whether a model accepts frequency and presence
+// penalties. Unknown models are given the benefit of the doubt.
+func supportsPenalties(model string, cfg *config.Config) bool {
+	m := cfg.GetModel(model)
+	return m == nil || m.SupportsPenalties
+}
 
-		finishReason := mapFinishReason(candidateMap["finishReason"])
+// clampPenalty limits a penalty to the range Gemini accepts. OpenAI allows
+// [-2, 2], so only a penalty of exactly 2 is normally affected.
+func clampPenalty(penalty float64) float64 {
+	return math.Max(config.MinPenalty, math.Min(config.MaxPenalty, penalty))
+}
 
-		choice := models.NewOpenAIChatCompletionChoice(
-			getInt(candidateMap["index"], 0),
-			message,
-			finishReason,
-		)
+// applyThinkingConfig sets the thinking configuration for a model variant, or
+// turns thinking off when DISABLE_THINKING is set
+func applyThinkingConfig(generationConfig map[string]interface{}, model string, cfg *config.Config) {
+	// Image models don't accept a thinking configuration
+	if strings.Contains(model, "gemini-2.5-flash-image") {
+		return
+	}
 
-		choices = append(choices, choice)
+	if cfg.DisableThinking {
+		// The global switch overrides model variants
+		generationConfig["thinkingConfig"] = config.DisabledThinkingConfig(model)
+		return
 	}
 
-	return models.NewOpenAIChatCompletionResponse(
-		uuid.New().String(),
-		model,
-		choices,
-	)
+	thinkingBudget := config.GetThinkingBudget(model)
+	if thinkingBudget != -1 {
+		if generationConfig["thinkingConfig"] == nil {
+			generationConfig["thinkingConfig"] = map[string]interface{}{}
+		}
+		thinkingConfig := generationConfig["thinkingConfig"].(map[string]interface{})
+		thinkingConfig["thinkingBudget"] = thinkingBudget
+		thinkingConfig["includeThoughts"] = config.ShouldIncludeThoughts(model)
+	}
 }
 
-// GeminiStreamChunkToOpenAI transforms a Gemini streaming response chunk to OpenAI streaming format
-func GeminiStreamChunkToOpenAI(geminiChunk map[string]interface{}, model string, responseID string) *models.OpenAIChatCompletionStreamResponse {
-	choices := []*models.OpenAIChatCompletionStreamChoice{}
+// GeminiResponseToOpenAI transforms a Gemini API response to OpenAI chat completion format.
+// Consecutive text parts are joined with separator (see TEXT_PART_JOIN); images are
+// always set off by a blank line.
+func GeminiResponseToOpenAI(geminiResponse map[string]interface{}, model string, systemFingerprint string, separator string) *models.OpenAIChatCompletionResponse {
+	choices := []*models.OpenAIChatCompletionChoice{}
 
-	candidates, _ := geminiChunk["candidates"].([]interface{})
-	for _, candidate := range candidates {
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	for position, candidate := range candidates {
 		candidateMap, ok := candidate.(map[string]interface{})
 		if !ok {
 			continue
@@ -214,8 +338,12 @@ func GeminiStreamChunkToOpenAI(geminiChunk map[string]interface{}, model string,
 
 		// Extract and separate thinking tokens from regular content
 		parts, _ := content["parts"].([]interface{})
-		var contentParts []string
+		var contentText string
+		lastImage := false
+		var contentBlocks []interface{}
 		var reasoningContent string
+		var audio audioClip
+		var toolCalls []models.OpenAIToolCall
 
 		for _, part := range parts {
 			partMap, ok := part.(map[string]interface{})
@@ -223,12 +351,19 @@ func GeminiStreamChunkToOpenAI(geminiChunk map[string]interface{}, model string,
 				continue
 			}
 
+			// Function calls -> OpenAI tool calls
+			if functionCall, ok := partMap["functionCall"].(map[string]interface{}); ok {
+				toolCalls = append(toolCalls, functionCallToToolCall(functionCall))
+				continue
+			}
+
 			// Text parts (may include thinking tokens)
 			if text, ok := partMap["text"].(string); ok {
 				if thought, ok := partMap["thought"].(bool); ok && thought {
 					reasoningContent += text
 				} else {
-					contentParts = append(contentParts, text)
+					contentText, lastImage = joinContent(contentText, lastImage, text, false, separator), false
+					contentBlocks = appendTextBlock(contentBlocks, text, separator)
 				}
 				continue
 			}
@@ -241,39 +376,73 @@ func GeminiStreamChunkToOpenAI(geminiChunk map[string]interface{}, model string,
 						mimeType = mime
 					}
 					if strings.HasPrefix(mimeType, "image/") {
-						contentParts = append(contentParts, fmt.Sprintf("![image](data:%s;base64,%s)", mimeType, data))
+						contentText, lastImage = joinContent(contentText, lastImage, fmt.Sprintf("![image](data:%s;base64,%s)", mimeType, data), true, separator), true
+						contentBlocks = append(contentBlocks, map[string]interface{}{
+							"type":      "image_url",
+							"image_url": map[string]interface{}{"url": fmt.Sprintf("data:%s;base64,%s", mimeType, data)},
+						})
+					} else if strings.HasPrefix(mimeType, "audio/") {
+						audio.add(mimeType, data)
 					}
 				}
 			}
 		}
 
-		contentText := strings.Join(contentParts, "\n\n")
+		// Build message object
+		message := models.OpenAIChatMessage{
+			Role:          role,
+			Content:       contentText,
+			ContentBlocks: contentBlocks,
+			Audio:         audio.output(),
+		}
 
-		// Build delta object
-		delta := models.OpenAIDelta{}
-		if contentText != "" {
-			delta.Content = &contentText
+		// Without any text but with reasoning or tool output, content is null rather than ""
+		if contentText == "" && (reasoningContent != "" || len(toolCalls) > 0 || message.Audio != nil) {
+			message.Content = nil
 		}
+
+		// Add reasoning_content if there are thinking tokens
 		if reasoningContent != "" {
-			delta.ReasoningContent = &reasoningContent
+			message.ReasoningContent = &reasoningContent
+		}
+		if len(toolCalls) > 0 {
+			message.ToolCalls = toolCalls
 		}
 
 		finishReason := mapFinishReason(candidateMap["finishReason"])
+		if len(toolCalls) > 0 && finishReason != nil && *finishReason == "stop" {
+			finishReason = stringPtr("tool_calls")
+		}
 
-		choice := models.NewOpenAIChatCompletionStreamChoice(
-			getInt(candidateMap["index"], 0),
-			delta,
+		// Gemini may omit the index (notably 0), so fall back to the position
+		choice := models.NewOpenAIChatCompletionChoice(
+			getInt(candidateMap["index"], position),
+			message,
 			finishReason,
 		)
+		choice.Logprobs = logprobsToOpenAI(candidateMap["logprobsResult"])
 
 		choices = append(choices, choice)
 	}
 
-	return models.NewOpenAIChatCompletionStreamResponse(
-		responseID,
+	response := models.NewOpenAIChatCompletionResponse(
+		uuid.New().String(),
 		model,
+		systemFingerprint,
 		choices,
 	)
+	response.ResolvedModel = ResolvedModel(geminiResponse, model)
+	return response
+}
+
+// ResolvedModel returns the model that actually served a response: Gemini's
+// reported modelVersion, or else the requested model without variant suffixes
+// such as -maxthinking
+func ResolvedModel(geminiResponse map[string]interface{}, requestedModel string) string {
+	if version, _ := geminiResponse["modelVersion"].(string); version != "" {
+		return version
+	}
+	return config.GetRootModelName(strings.TrimPrefix(requestedModel, "models/"))
 }
 
 // processContent processes message content and converts it to Gemini parts
@@ -284,7 +453,7 @@ func processContent(content interface{}) ([]map[string]interface{}, error) {
 	case []interface{}:
 		return processArrayContent(content), nil
 	default:
-		return nil, fmt.Errorf("unsupported content type: %T", content)
+		return nil, apierrors.InvalidParam("messages", apierrors.CodeInvalidType, "unsupported content type: %T", content)
 	}
 }
 
@@ -351,7 +520,14 @@ func processTextContent(text string) []map[string]interface{} {
 func processArrayContent(contentArray []interface{}) []map[string]interface{} {
 	var parts []map[string]interface{}
 
+	// Parts are appended strictly in input order; text items expand in place
 	for _, item := range contentArray {
+		// Some clients send bare strings mixed with typed parts
+		if text, ok := item.(string); ok {
+			parts = append(parts, processTextContent(text)...)
+			continue
+		}
+
 		partMap, ok := item.(map[string]interface{})
 		if !ok {
 			continue
@@ -359,14 +535,19 @@ func processArrayContent(contentArray []interface{}) []map[string]interface{} {
 
 		partType, ok := partMap["type"].(string)
 		if !ok {
-			continue
+			// Treat untyped parts carrying text as text parts
+			if _, hasText := partMap["text"]; hasText {
+				partType = "text"
+			} else {
+				continue
+			}
 		}
 
 		switch partType {
 		case "text":
-			if text, ok := partMap["text"].(string); ok {
-				textParts := processTextContent(text)
-				parts = append(parts, textParts...)
+			// A text part without text contributes nothing
+			if text, ok := partMap["text"].(string); ok && text != "" {
+				parts = append(parts, processTextContent(text)...)
 			}
 
 		case "image_url":
@@ -429,6 +610,133 @@ func processImageURL(url string) (map[string]interface{}, bool) {
 	}, true
 }
 
+// SystemFingerprint derives a stable fingerprint from the model, seed and proxy
+// version, so identical deterministic requests report the same backend configuration
+func SystemFingerprint(model string, seed *int) string {
+	seedStr := ""
+	if seed != nil {
+		seedStr = strconv.Itoa(*seed)
+	}
+	sum := sha256.Sum256([]byte(model + "|" + seedStr + "|" + config.ProxyVersion))
+	return "fp_" + hex.EncodeToString(sum[:])[:10]
+}
+
+// getResponseModalities returns the Gemini response modalities requested via the
+// OpenAI "modalities" field or extra_body.modalities
+func getResponseModalities(openaiRequest *models.OpenAIChatCompletionRequest) []string {
+	requested := openaiRequest.Modalities
+	if len(requested) == 0 {
+		if values, ok := openaiRequest.ExtraBody["modalities"].([]interface{}); ok {
+			for _, value := range values {
+				if modality, ok := value.(string); ok {
+					requested = append(requested, modality)
+				}
+			}
+		}
+	}
+
+	var modalities []string
+	for _, modality := range requested {
+		modality = strings.ToUpper(strings.TrimSpace(modality))
+		if modality != "" {
+			modalities = append(modalities, modality)
+		}
+	}
+	return modalities
+}
+
+// functionCallToToolCall converts a Gemini functionCall part into an OpenAI tool call
+func functionCallToToolCall(functionCall map[string]interface{}) models.OpenAIToolCall {
+	name, _ := functionCall["name"].(string)
+
+	arguments := "{}"
+	if args, ok := functionCall["args"]; ok && args != nil {
+		if argsJSON, err := json.Marshal(args); err == nil {
+			arguments = string(argsJSON)
+		}
+	}
+
+	id, _ := functionCall["id"].(string)
+	if id == "" {
+		id = "call_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+
+	return models.OpenAIToolCall{
+		ID:   id,
+		Type: "function",
+		Function: models.OpenAIFunctionCall{
+			Name:      name,
+			Arguments: arguments,
+		},
+	}
+}
+
+// DropReasoningContent removes reasoning_content from every choice, for
+// STRIP_THINKING_FROM_CONTENT. Content that was null only because of the
+// reasoning becomes an empty string.
+func DropReasoningContent(response *models.OpenAIChatCompletionResponse) {
+	for _, choice := range response.Choices {
+		if choice.Message.ReasoningContent == nil {
+			continue
+		}
+		choice.Message.ReasoningContent = nil
+		if choice.Message.Content == nil && len(choice.Message.ToolCalls) == 0 && choice.Message.Audio == nil {
+			choice.Message.Content = ""
+		}
+	}
+}
+
+// UseContentArray replaces each choice's flattened content with its typed
+// text and image_url blocks, for RESPONSE_CONTENT_FORMAT=array. Null content
+// is left as is.
+func UseContentArray(response *models.OpenAIChatCompletionResponse) {
+	for _, choice := range response.Choices {
+		if len(choice.Message.ContentBlocks) > 0 {
+			choice.Message.Content = choice.Message.ContentBlocks
+		}
+	}
+}
+
+// joinContent appends a text or image part to flattened content. Text parts
+// are joined with separator, while an image and its neighbours are always
+// separated by a blank line so the Markdown stays on its own paragraph.
+func joinContent(content string, lastImage bool, text string, image bool, separator string) string {
+	if content == "" {
+		return text
+	}
+	if image || lastImage {
+		separator = "\n\n"
+	}
+	return content + separator + text
+}
+
+// appendTextBlock adds text to blocks, extending a trailing text block the
+// same way consecutive text parts are joined in flattened content
+func appendTextBlock(blocks []interface{}, text string, separator string) []interface{} {
+	if len(blocks) > 0 {
+		if last, ok := blocks[len(blocks)-1].(map[string]interface{}); ok && last["type"] == "text" {
+			last["text"] = last["text"].(string) + separator + text
+			return blocks
+		}
+	}
+	return append(blocks, map[string]interface{}{"type": "text", "text": text})
+}
+
+// MarkContentFiltered turns a blocked response into an empty completion: every
+// choice finishes with content_filter, and a prompt blocked before any
+// candidate was generated gets a single empty choice
+func MarkContentFiltered(response *models.OpenAIChatCompletionResponse) {
+	if len(response.Choices) == 0 {
+		response.Choices = append(response.Choices, models.NewOpenAIChatCompletionChoice(0, models.OpenAIChatMessage{Role: "assistant", Content: ""}, nil))
+	}
+	for _, choice := range response.Choices {
+		choice.FinishReason = stringPtr("content_filter")
+		if choice.Message.Content == nil {
+			choice.Message.Content = ""
+		}
+	}
+}
+
 // mapFinishReason maps Gemini finish reasons to OpenAI finish reasons
 func mapFinishReason(reason interface{}) *string {
 	if reasonStr, ok := reason.(string); ok {
@@ -437,8 +745,10 @@ func mapFinishReason(reason interface{}) *string {
 			return stringPtr("stop")
 		case "MAX_TOKENS":
 			return stringPtr("length")
-		case "SAFETY", "RECITATION":
+		case "SAFETY", "RECITATION", "PROHIBITED_CONTENT", "BLOCKLIST", "SPII", "IMAGE_SAFETY":
 			return stringPtr("content_filter")
+		case MalformedFunctionCallReason:
+			return stringPtr("malformed_function_call")
 		default:
 			return nil
 		}
@@ -448,21 +758,6 @@ func mapFinishReason(reason interface{}) *string {
 
 // Helper functions
 
-func getDefaultSafetySettings() []map[string]interface{} {
-	return []map[string]interface{}{
-		{"category": "HARM_CATEGORY_HARASSMENT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_HATE_SPEECH", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_SEXUALLY_EXPLICIT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_DANGEROUS_CONTENT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_CIVIC_INTEGRITY", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_IMAGE_DANGEROUS_CONTENT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_IMAGE_HARASSMENT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_IMAGE_HATE", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_IMAGE_SEXUALLY_EXPLICIT", "threshold": "BLOCK_NONE"},
-		{"category": "HARM_CATEGORY_UNSPECIFIED", "threshold": "BLOCK_NONE"},
-	}
-}
-
 func stringPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,467 @@
+package transformers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// decodeJSON decodes a JSON literal the way request bodies are decoded
+func decodeJSON(t *testing.T, literal string) interface{} {
+	t.Helper()
+	var value interface{}
+	if err := json.Unmarshal([]byte(literal), &value); err != nil {
+		t.Fatalf("invalid test JSON %s: %v", literal, err)
+	}
+	return value
+}
+
+func TestProcessArrayContentMixedTypes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []map[string]interface{}
+	}{
+		{
+			name:    "bare strings between typed parts",
+			content: `["first", {"type": "text", "text": "second"}, "third"]`,
+			want: []map[string]interface{}{
+				{"text": "first"},
+				{"text": "second"},
+				{"text": "third"},
+			},
+		},
+		{
+			name:    "untyped text part",
+			content: `[{"text": "untyped"}, {"type": "text", "text": "typed"}]`,
+			want: []map[string]interface{}{
+				{"text": "untyped"},
+				{"text": "typed"},
+			},
+		},
+		{
+			name:    "image keeps its position",
+			content: `["before", {"type": "image_url", "image_url": {"url": "data:image/jpeg;base64,AAAA"}}, {"type": "text", "text": "after"}]`,
+			want: []map[string]interface{}{
+				{"text": "before"},
+				{"inlineData": map[string]interface{}{"mimeType": "image/jpeg", "data": "AAAA"}},
+				{"text": "after"},
+			},
+		},
+		{
+			name:    "empty text and unknown items are skipped",
+			content: `[{"type": "text", "text": ""}, 42, null, {"type": "input_audio"}, {"type": "text", "text": "kept"}]`,
+			want: []map[string]interface{}{
+				{"text": "kept"},
+			},
+		},
+		{
+			name:    "nothing usable",
+			content: `[{"type": "text", "text": ""}, 7]`,
+			want: []map[string]interface{}{
+				{"text": ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := decodeJSON(t, tt.content).([]interface{})
+			if got := processArrayContent(content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("processArrayContent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// convertResponse converts a Gemini response given as JSON with the default
+// part separator
+func convertResponse(t *testing.T, literal string) *models.OpenAIChatCompletionResponse {
+	t.Helper()
+	return GeminiResponseToOpenAI(decodeJSON(t, literal).(map[string]interface{}), "gemini-2.5-flash", "fp", "")
+}
+
+func TestGeminiResponseNullContent(t *testing.T) {
+	tests := []struct {
+		name         string
+		response     string
+		wantToolCall bool
+		wantFinish   string
+	}{
+		{
+			name:       "thought only",
+			response:   `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Thinking it over", "thought": true}]}, "finishReason": "STOP"}]}`,
+			wantFinish: "stop",
+		},
+		{
+			name:         "tool call only",
+			response:     `{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}}]}, "finishReason": "STOP"}]}`,
+			wantToolCall: true,
+			wantFinish:   "tool_calls",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := convertResponse(t, tt.response)
+			if len(response.Choices) != 1 {
+				t.Fatalf("got %d choices, want 1", len(response.Choices))
+			}
+			choice := response.Choices[0]
+
+			if choice.Message.Content != nil {
+				t.Errorf("content = %#v, want nil", choice.Message.Content)
+			}
+			if tt.wantToolCall && len(choice.Message.ToolCalls) != 1 {
+				t.Errorf("tool_calls = %+v, want one call", choice.Message.ToolCalls)
+			}
+			if !tt.wantToolCall && (choice.Message.ReasoningContent == nil || *choice.Message.ReasoningContent != "Thinking it over") {
+				t.Errorf("reasoning_content = %v, want the thought text", choice.Message.ReasoningContent)
+			}
+			if choice.FinishReason == nil || *choice.FinishReason != tt.wantFinish {
+				t.Errorf("finish_reason = %v, want %s", choice.FinishReason, tt.wantFinish)
+			}
+
+			body, err := json.Marshal(choice.Message)
+			if err != nil {
+				t.Fatalf("marshal message: %v", err)
+			}
+			if !strings.Contains(string(body), `"content":null`) {
+				t.Errorf("message JSON = %s, want \"content\":null", body)
+			}
+		})
+	}
+}
+
+// convertRequest decodes an OpenAI request given as JSON and converts it with
+// cfg, returning the payload and its generationConfig
+func convertRequest(t *testing.T, cfg *config.Config, literal string) (map[string]interface{}, map[string]interface{}) {
+	t.Helper()
+	var request models.OpenAIChatCompletionRequest
+	if err := json.Unmarshal([]byte(literal), &request); err != nil {
+		t.Fatalf("invalid test request %s: %v", literal, err)
+	}
+	payload, err := OpenAIRequestToGemini(&request, cfg)
+	if err != nil {
+		t.Fatalf("OpenAIRequestToGemini() error = %v", err)
+	}
+	return payload, payload["generationConfig"].(map[string]interface{})
+}
+
+func TestDisableThinkingOverridesModelSuffix(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.DisableThinking = true
+
+	tests := []struct {
+		model      string
+		wantBudget int
+	}{
+		{"gemini-2.5-flash", 0},
+		{"gemini-2.5-flash-maxthinking", 0},
+		{"gemini-2.5-flash-nothinking", 0},
+		{"gemini-2.5-flash-search-maxthinking", 0},
+		// Pro can't turn thinking off, so it gets its minimum budget
+		{"gemini-2.5-pro-maxthinking", 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			_, generationConfig := convertRequest(t, cfg, `{"model": "`+tt.model+`", "messages": [{"role": "user", "content": "Hi"}]}`)
+
+			want := map[string]interface{}{"thinkingBudget": tt.wantBudget, "includeThoughts": false}
+			if got := generationConfig["thinkingConfig"]; !reflect.DeepEqual(got, want) {
+				t.Errorf("thinkingConfig = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestDefaultMaxOutputTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		defaultTokens int
+		request       string
+		want          interface{} // nil when maxOutputTokens must be absent
+	}{
+		{
+			name:          "applied when max_tokens is absent",
+			defaultTokens: 1000,
+			request:       `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`,
+			want:          1000,
+		},
+		{
+			name:          "client max_tokens wins",
+			defaultTokens: 1000,
+			request:       `{"model": "gemini-2.5-flash", "max_tokens": 50, "messages": [{"role": "user", "content": "Hi"}]}`,
+			want:          50,
+		},
+		{
+			name:          "clamped to the model's output limit",
+			defaultTokens: 1000000,
+			request:       `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`,
+			want:          65535,
+		},
+		{
+			name:    "not set when unconfigured",
+			request: `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.DefaultMaxOutputTokens = tt.defaultTokens
+
+			_, generationConfig := convertRequest(t, cfg, tt.request)
+
+			if got := generationConfig["maxOutputTokens"]; got != tt.want {
+				t.Errorf("maxOutputTokens = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelDefaultSampling(t *testing.T) {
+	cfg := config.NewConfig()
+	model := cfg.GetModel("gemini-2.5-flash")
+	if model == nil || model.Temperature == 0 {
+		t.Fatal("gemini-2.5-flash has no default temperature")
+	}
+
+	tests := []struct {
+		name            string
+		request         string
+		wantTemperature interface{}
+		wantTopP        interface{}
+	}{
+		{
+			name:            "omitted",
+			request:         `{"model": "gemini-2.5-flash-maxthinking", "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantTemperature: model.Temperature,
+			wantTopP:        model.TopP,
+		},
+		{
+			name:            "explicit values win",
+			request:         `{"model": "gemini-2.5-flash", "temperature": 0.2, "top_p": 0.5, "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantTemperature: 0.2,
+			wantTopP:        0.5,
+		},
+		{
+			name:            "explicit zero temperature is kept",
+			request:         `{"model": "gemini-2.5-flash", "temperature": 0, "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantTemperature: 0.0,
+			wantTopP:        model.TopP,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, generationConfig := convertRequest(t, cfg, tt.request)
+
+			if got := generationConfig["temperature"]; got != tt.wantTemperature {
+				t.Errorf("temperature = %v, want %v", got, tt.wantTemperature)
+			}
+			if got := generationConfig["topP"]; got != tt.wantTopP {
+				t.Errorf("topP = %v, want %v", got, tt.wantTopP)
+			}
+			if got := generationConfig["topK"]; got != model.TopK {
+				t.Errorf("topK = %v, want the model default %d", got, model.TopK)
+			}
+		})
+	}
+}
+
+func TestStopSequences(t *testing.T) {
+	tests := []struct {
+		name string
+		stop string
+		want interface{}
+	}{
+		{"array", `["\n\n", "END"]`, []string{"\n\n", "END"}},
+		{"string", `"END"`, []string{"END"}},
+		{"number", `42`, []string{"42"}},
+		{"mixed array", `["END", 7, null]`, []string{"END", "7"}},
+		{"empty strings dropped", `["", "END", "END"]`, []string{"END"}},
+		{"empty array", `[]`, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.DefaultStopSequences = nil
+
+			_, generationConfig := convertRequest(t, cfg, `{"model": "gemini-2.5-flash", "stop": `+tt.stop+`, "messages": [{"role": "user", "content": "Hi"}]}`)
+
+			if got := generationConfig["stopSequences"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("stopSequences = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPenalties(t *testing.T) {
+	tests := []struct {
+		name          string
+		request       string
+		wantFrequency interface{}
+		wantPresence  interface{}
+		wantIgnored   []string
+	}{
+		{
+			name:          "within range",
+			request:       `{"model": "gemini-2.5-flash", "frequency_penalty": 0.5, "presence_penalty": -2, "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantFrequency: 0.5,
+			wantPresence:  -2.0,
+		},
+		{
+			name:          "2 clamped below Gemini's exclusive maximum",
+			request:       `{"model": "gemini-2.5-flash", "frequency_penalty": 2, "presence_penalty": 2, "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantFrequency: config.MaxPenalty,
+			wantPresence:  config.MaxPenalty,
+		},
+		{
+			name:        "omitted for models without penalties",
+			request:     `{"model": "gemini-2.5-pro", "frequency_penalty": 0.5, "presence_penalty": 0.5, "messages": [{"role": "user", "content": "Hi"}]}`,
+			wantIgnored: []string{"frequency_penalty", "presence_penalty"},
+		},
+		{
+			name:    "unset",
+			request: `{"model": "gemini-2.5-flash", "messages": [{"role": "user", "content": "Hi"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			_, generationConfig := convertRequest(t, cfg, tt.request)
+
+			if got := generationConfig["frequencyPenalty"]; got != tt.wantFrequency {
+				t.Errorf("frequencyPenalty = %v, want %v", got, tt.wantFrequency)
+			}
+			if got := generationConfig["presencePenalty"]; got != tt.wantPresence {
+				t.Errorf("presencePenalty = %v, want %v", got, tt.wantPresence)
+			}
+
+			var request models.OpenAIChatCompletionRequest
+			json.Unmarshal([]byte(tt.request), &request)
+			if got := UnsupportedParams(&request, cfg); !reflect.DeepEqual(got, tt.wantIgnored) {
+				t.Errorf("UnsupportedParams() = %v, want %v", got, tt.wantIgnored)
+			}
+		})
+	}
+}
+
+func TestResolvedModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		response  string
+		want      string
+	}{
+		{"variant alias", "gemini-2.5-flash-maxthinking", `{}`, "gemini-2.5-flash"},
+		{"combined variant alias", "gemini-2.5-pro-search-nothinking", `{}`, "gemini-2.5-pro"},
+		{"models/ prefix", "models/gemini-2.5-flash", `{}`, "gemini-2.5-flash"},
+		{"reported model version wins", "gemini-2.5-flash-nothinking", `{"modelVersion": "gemini-2.5-flash-preview-05-20"}`, "gemini-2.5-flash-preview-05-20"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := decodeJSON(t, tt.response).(map[string]interface{})
+			if got := ResolvedModel(response, tt.requested); got != tt.want {
+				t.Errorf("ResolvedModel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportResolvedModel(t *testing.T) {
+	const chunk = `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hi"}]}}]}`
+
+	response := GeminiResponseToOpenAI(decodeJSON(t, chunk).(map[string]interface{}), "gemini-2.5-flash-search-maxthinking", "fp", "")
+	if response.Model != "gemini-2.5-flash-search-maxthinking" || response.ResolvedModel != "gemini-2.5-flash" {
+		t.Errorf("model = %q, resolved = %q, want the alias and its resolved model", response.Model, response.ResolvedModel)
+	}
+
+	transformer := NewStreamTransformer("gemini-2.5-flash-search-maxthinking", "chatcmpl-1", "fp")
+	plain := transformChunks(t, transformer, chunk)
+	if plain[0].Model != "gemini-2.5-flash-search-maxthinking" || plain[0].ResolvedModel != "gemini-2.5-flash" {
+		t.Errorf("model = %q, resolved = %q, want the requested alias reported as is", plain[0].Model, plain[0].ResolvedModel)
+	}
+
+	transformer = NewStreamTransformer("gemini-2.5-flash-search-maxthinking", "chatcmpl-2", "fp")
+	transformer.ReportResolvedModel()
+	resolved := transformChunks(t, transformer, chunk)
+	if resolved[0].Model != "gemini-2.5-flash" {
+		t.Errorf("model = %q, want the resolved gemini-2.5-flash", resolved[0].Model)
+	}
+}
+
+func TestCandidatesWithoutIndex(t *testing.T) {
+	response := convertResponse(t, `{"candidates": [
+		{"content": {"role": "model", "parts": [{"text": "first"}]}, "finishReason": "STOP"},
+		{"content": {"role": "model", "parts": [{"text": "second"}]}, "finishReason": "STOP"}]}`)
+
+	if len(response.Choices) != 2 {
+		t.Fatalf("got %d choices, want 2", len(response.Choices))
+	}
+	for i, want := range []string{"first", "second"} {
+		if choice := response.Choices[i]; choice.Index != i || choice.Message.Content != want {
+			t.Errorf("choice %d = index %d %v, want index %d %q", i, choice.Index, choice.Message.Content, i, want)
+		}
+	}
+}
+
+func TestUseContentArray(t *testing.T) {
+	response := convertResponse(t, `{"candidates": [{"content": {"role": "model", "parts": [
+		{"text": "Here is "},
+		{"text": "a cat:"},
+		{"inlineData": {"mimeType": "image/png", "data": "aW1n"}},
+		{"text": "Done."}
+	]}, "finishReason": "STOP"}]}`)
+
+	UseContentArray(response)
+
+	want := []interface{}{
+		map[string]interface{}{"type": "text", "text": "Here is a cat:"},
+		map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,aW1n"}},
+		map[string]interface{}{"type": "text", "text": "Done."},
+	}
+	if got := response.Choices[0].Message.Content; !reflect.DeepEqual(got, want) {
+		t.Errorf("content = %v, want %v", got, want)
+	}
+
+	empty := convertResponse(t, `{"candidates": [{"content": {"role": "model", "parts": [{"functionCall": {"name": "f", "args": {}}}]}, "finishReason": "STOP"}]}`)
+	UseContentArray(empty)
+	if content := empty.Choices[0].Message.Content; content != nil {
+		t.Errorf("content without text or images = %v, want null", content)
+	}
+}
+
+func TestTextPartJoin(t *testing.T) {
+	const parts = `[{"text": "Hel"}, {"text": "lo"}, {"inlineData": {"mimeType": "image/png", "data": "aW1n"}}, {"text": "Bye"}]`
+	tests := []struct {
+		join string
+		want string
+	}{
+		{"concat", "Hello\n\n![image](data:image/png;base64,aW1n)\n\nBye"},
+		{"blank_line", "Hel\n\nlo\n\n![image](data:image/png;base64,aW1n)\n\nBye"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.join, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.TextPartJoin = tt.join
+			geminiResponse := decodeJSON(t, `{"candidates": [{"content": {"role": "model", "parts": `+parts+`}, "finishReason": "STOP"}]}`).(map[string]interface{})
+
+			response := GeminiResponseToOpenAI(geminiResponse, "gemini-2.5-flash", "fp", cfg.TextPartSeparator())
+
+			if got := response.Choices[0].Message.Content; got != tt.want {
+				t.Errorf("content = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
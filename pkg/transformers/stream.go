@@ -0,0 +1,318 @@
+package transformers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"geminicli2api/pkg/models"
+)
+
+// StreamTransformer converts Gemini streaming chunks into OpenAI streaming chunks.
+// It keeps state that spans chunks, such as tool call indices per choice.
+type StreamTransformer struct {
+	model             string
+	responseID        string
+	systemFingerprint string
+	toolCallCount     map[int]int
+	unfinished        map[int]bool // Choices that have been sent without a finish reason
+	singleToolCall    bool
+	dropReasoning     bool
+	reportResolved    bool
+	serviceTier       string
+	separator         string // Between text parts within a chunk, for TEXT_PART_JOIN
+	citations         bool
+	text              map[int]*strings.Builder // Content streamed so far per choice, for citation offsets
+	cited             map[int]map[models.OpenAIURLCitation]bool
+	audioIDs          map[int]string // One audio ID per choice, so clients join its audio deltas
+}
+
+// NewStreamTransformer creates a stream transformer for a single streamed response
+func NewStreamTransformer(model string, responseID string, systemFingerprint string) *StreamTransformer {
+	return &StreamTransformer{
+		model:             model,
+		responseID:        responseID,
+		systemFingerprint: systemFingerprint,
+		toolCallCount:     make(map[int]int),
+		unfinished:        make(map[int]bool),
+		audioIDs:          make(map[int]string),
+	}
+}
+
+// SingleToolCall makes the transformer surface only the first tool call of
+// each candidate, for requests with parallel_tool_calls: false
+func (t *StreamTransformer) SingleToolCall() {
+	t.singleToolCall = true
+}
+
+// DropReasoning makes the transformer discard thought parts instead of
+// emitting them as reasoning_content, for STRIP_THINKING_FROM_CONTENT
+func (t *StreamTransformer) DropReasoning() {
+	t.dropReasoning = true
+}
+
+// ReportResolvedModel makes the transformer report the resolved model in the
+// model field instead of the requested one, for REPORT_RESOLVED_MODEL
+func (t *StreamTransformer) ReportResolvedModel() {
+	t.reportResolved = true
+}
+
+// EchoServiceTier makes the transformer set service_tier on every chunk
+func (t *StreamTransformer) EchoServiceTier(tier string) {
+	t.serviceTier = tier
+}
+
+// IncludeCitations makes the transformer stream grounding metadata as
+// url_citation annotations on the candidate's last delta, for
+// INCLUDE_GROUNDING_CITATIONS. Citations already sent aren't repeated when
+// later chunks carry the metadata again.
+func (t *StreamTransformer) IncludeCitations() {
+	t.citations = true
+	t.text = make(map[int]*strings.Builder)
+	t.cited = make(map[int]map[models.OpenAIURLCitation]bool)
+}
+
+// SeparateTextParts makes the transformer join consecutive text parts within
+// a chunk with separator instead of concatenating them, for TEXT_PART_JOIN
+func (t *StreamTransformer) SeparateTextParts(separator string) {
+	t.separator = separator
+}
+
+// GeminiStreamChunkToOpenAI transforms a single Gemini streaming response chunk to OpenAI streaming format
+func GeminiStreamChunkToOpenAI(geminiChunk map[string]interface{}, model string, responseID string, systemFingerprint string) []*models.OpenAIChatCompletionStreamResponse {
+	return NewStreamTransformer(model, responseID, systemFingerprint).Transform(geminiChunk)
+}
+
+// Transform converts a Gemini chunk into one or more OpenAI chunks. Parts are
+// emitted in their original order, so text, tool calls and more text within a
+// single Gemini chunk become consecutive OpenAI deltas rather than being merged.
+// As with OpenAI, a choice's finish_reason only ever comes in a chunk of its
+// own, with an empty delta, after the choice's last content, and anything
+// Gemini sends for a choice after it finished is dropped.
+func (t *StreamTransformer) Transform(geminiChunk map[string]interface{}) []*models.OpenAIChatCompletionStreamResponse {
+	var responses []*models.OpenAIChatCompletionStreamResponse
+	resolvedModel := ResolvedModel(geminiChunk, t.model)
+	model := t.model
+	if t.reportResolved {
+		model = resolvedModel
+	}
+
+	candidates, _ := geminiChunk["candidates"].([]interface{})
+	for position, candidate := range candidates {
+		candidateMap, ok := candidate.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		index := getInt(candidateMap["index"], position)
+		// A choice finishes once, e.g. when an invalid function call was
+		// dropped before Gemini's own finish reason arrives
+		if open, sent := t.unfinished[index]; sent && !open {
+			continue
+		}
+		content, _ := candidateMap["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+
+		deltas := t.partsToDeltas(index, parts)
+
+		finishReason := mapFinishReason(candidateMap["finishReason"])
+		if finishReason != nil && *finishReason == "stop" && t.toolCallCount[index] > 0 {
+			finishReason = stringPtr("tool_calls")
+		}
+
+		if t.citations {
+			if annotations := t.newAnnotations(index, candidateMap, deltas); len(annotations) > 0 {
+				if len(deltas) == 0 {
+					deltas = []models.OpenAIDelta{{}}
+				}
+				deltas[len(deltas)-1].Annotations = annotations
+			}
+		}
+
+		if len(deltas) == 0 && finishReason == nil {
+			continue
+		}
+
+		logprobs := logprobsToOpenAI(candidateMap["logprobsResult"])
+		emit := func(choice *models.OpenAIChatCompletionStreamChoice) {
+			response := models.NewOpenAIChatCompletionStreamResponse(
+				t.responseID,
+				model,
+				t.systemFingerprint,
+				[]*models.OpenAIChatCompletionStreamChoice{choice},
+			)
+			response.ResolvedModel = resolvedModel
+			response.ServiceTier = t.serviceTier
+			responses = append(responses, response)
+		}
+		for i, delta := range deltas {
+			choice := models.NewOpenAIChatCompletionStreamChoice(index, delta, nil)
+			// The chunk's token log probabilities go with its first delta
+			if i == 0 {
+				choice.Logprobs = logprobs
+			}
+			emit(choice)
+		}
+		if finishReason != nil {
+			emit(models.NewOpenAIChatCompletionStreamChoice(index, models.OpenAIDelta{}, finishReason))
+		}
+		t.unfinished[index] = finishReason == nil
+	}
+
+	return responses
+}
+
+// Truncate returns the chunks that end a stream cut off before Gemini
+// finished: every choice still open finishes with length, or choice 0 if
+// nothing has been sent
+func (t *StreamTransformer) Truncate() []*models.OpenAIChatCompletionStreamResponse {
+	var indices []int
+	for index, open := range t.unfinished {
+		if open {
+			indices = append(indices, index)
+		}
+	}
+	if len(t.unfinished) == 0 {
+		indices = []int{0}
+	}
+	sort.Ints(indices)
+
+	var responses []*models.OpenAIChatCompletionStreamResponse
+	for _, index := range indices {
+		response := models.NewOpenAIChatCompletionStreamResponse(
+			t.responseID,
+			t.model,
+			t.systemFingerprint,
+			[]*models.OpenAIChatCompletionStreamChoice{
+				models.NewOpenAIChatCompletionStreamChoice(index, models.OpenAIDelta{}, stringPtr("length")),
+			},
+		)
+		response.ServiceTier = t.serviceTier
+		t.unfinished[index] = false
+		responses = append(responses, response)
+	}
+	return responses
+}
+
+// newAnnotations records the candidate's streamed content and returns the
+// citations from its grounding metadata that haven't been sent yet
+func (t *StreamTransformer) newAnnotations(index int, candidate map[string]interface{}, deltas []models.OpenAIDelta) []models.OpenAIAnnotation {
+	text := t.text[index]
+	if text == nil {
+		text = &strings.Builder{}
+		t.text[index] = text
+		t.cited[index] = make(map[models.OpenAIURLCitation]bool)
+	}
+	for _, delta := range deltas {
+		if delta.Content != nil {
+			text.WriteString(*delta.Content)
+		}
+	}
+
+	var annotations []models.OpenAIAnnotation
+	for _, annotation := range groundingAnnotations(candidate, text.String()) {
+		if !t.cited[index][annotation.URLCitation] {
+			t.cited[index][annotation.URLCitation] = true
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+// partsToDeltas groups consecutive parts of the same kind into deltas, preserving order
+func (t *StreamTransformer) partsToDeltas(index int, parts []interface{}) []models.OpenAIDelta {
+	var deltas []models.OpenAIDelta
+	lastKind := ""
+
+	for _, part := range parts {
+		partMap, ok := part.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Function calls -> tool call deltas
+		if functionCall, ok := partMap["functionCall"].(map[string]interface{}); ok {
+			if t.singleToolCall && t.toolCallCount[index] > 0 {
+				continue
+			}
+			toolCall := functionCallToToolCall(functionCall)
+			toolCallIndex := t.toolCallCount[index]
+			toolCall.Index = &toolCallIndex
+			t.toolCallCount[index]++
+
+			if lastKind == "tool" {
+				last := &deltas[len(deltas)-1]
+				last.ToolCalls = append(last.ToolCalls, toolCall)
+			} else {
+				deltas = append(deltas, models.OpenAIDelta{ToolCalls: []models.OpenAIToolCall{toolCall}})
+				lastKind = "tool"
+			}
+			continue
+		}
+
+		// Text parts (may include thinking tokens)
+		if text, ok := partMap["text"].(string); ok {
+			if thought, ok := partMap["thought"].(bool); ok && thought {
+				if t.dropReasoning {
+					continue
+				}
+				if lastKind == "reasoning" {
+					last := &deltas[len(deltas)-1]
+					*last.ReasoningContent += text
+				} else {
+					deltas = append(deltas, models.OpenAIDelta{ReasoningContent: stringPtr(text)})
+					lastKind = "reasoning"
+				}
+			} else {
+				deltas, lastKind = t.appendContentDelta(deltas, lastKind, text, false)
+			}
+			continue
+		}
+
+		// Inline data -> Markdown image or audio output
+		if inlineData, ok := partMap["inlineData"].(map[string]interface{}); ok {
+			if data, ok := inlineData["data"].(string); ok && data != "" {
+				mimeType := "image/png"
+				if mime, ok := inlineData["mimeType"].(string); ok {
+					mimeType = mime
+				}
+				if strings.HasPrefix(mimeType, "image/") {
+					deltas, lastKind = t.appendContentDelta(deltas, lastKind, fmt.Sprintf("![image](data:%s;base64,%s)", mimeType, data), true)
+				} else if strings.HasPrefix(mimeType, "audio/") {
+					deltas = append(deltas, models.OpenAIDelta{Audio: t.audioDelta(index, mimeType, data)})
+					lastKind = "audio"
+				}
+			}
+		}
+	}
+
+	return deltas
+}
+
+// audioDelta returns a streamed audio part under the choice's audio ID. The
+// first one also names the format, which Gemini keeps for the whole clip;
+// speech arrives as raw PCM since a WAV header can't precede unknown length.
+func (t *StreamTransformer) audioDelta(index int, mimeType string, data string) *models.OpenAIAudio {
+	audio := &models.OpenAIAudio{ID: t.audioIDs[index], Data: data}
+	if audio.ID == "" {
+		audio.ID = newAudioID()
+		audio.MimeType = mimeType
+		t.audioIDs[index] = audio.ID
+	}
+	return audio
+}
+
+// appendContentDelta appends text or an image to the trailing content delta,
+// joined the same way as non-streaming content, or starts a new one
+func (t *StreamTransformer) appendContentDelta(deltas []models.OpenAIDelta, lastKind string, text string, image bool) ([]models.OpenAIDelta, string) {
+	kind := "content"
+	if image {
+		kind = "image"
+	}
+	if lastKind == "content" || lastKind == "image" {
+		last := &deltas[len(deltas)-1]
+		*last.Content = joinContent(*last.Content, lastKind == "image", text, image, t.separator)
+		return deltas, kind
+	}
+	return append(deltas, models.OpenAIDelta{Content: stringPtr(text)}), kind
+}
@@ -0,0 +1,141 @@
+package transformers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"geminicli2api/pkg/models"
+)
+
+// transformChunks runs Gemini chunks given as JSON through one stream transformer
+func transformChunks(t *testing.T, transformer *StreamTransformer, chunks ...string) []*models.OpenAIChatCompletionStreamResponse {
+	t.Helper()
+	var responses []*models.OpenAIChatCompletionStreamResponse
+	for _, chunk := range chunks {
+		responses = append(responses, transformer.Transform(decodeJSON(t, chunk).(map[string]interface{}))...)
+	}
+	return responses
+}
+
+func TestTransformInterleavedParts(t *testing.T) {
+	chunk := `{"candidates": [{"content": {"role": "model", "parts": [
+		{"text": "Let me "},
+		{"text": "check."},
+		{"functionCall": {"name": "get_weather", "args": {"city": "Paris"}}},
+		{"functionCall": {"name": "get_time", "args": {}}},
+		{"text": "Checking both."},
+		{"functionCall": {"name": "get_news", "args": {}}}
+	]}, "finishReason": "STOP"}]}`
+
+	responses := transformChunks(t, NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp"), chunk)
+
+	if len(responses) != 5 {
+		t.Fatalf("got %d chunks, want 4 deltas and a finish chunk", len(responses))
+	}
+	delta := func(i int) models.OpenAIDelta { return responses[i].Choices[0].Delta }
+
+	if content := delta(0).Content; content == nil || *content != "Let me check." {
+		t.Errorf("delta 0 content = %v, want the joined leading text", content)
+	}
+	if calls := delta(1).ToolCalls; len(calls) != 2 || calls[0].Function.Name != "get_weather" || calls[1].Function.Name != "get_time" {
+		t.Errorf("delta 1 tool calls = %+v, want get_weather and get_time", calls)
+	} else if *calls[0].Index != 0 || *calls[1].Index != 1 || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Errorf("delta 1 tool calls = %+v, want indices 0 and 1 with arguments", calls)
+	}
+	if content := delta(2).Content; content == nil || *content != "Checking both." {
+		t.Errorf("delta 2 content = %v, want the text between the calls", content)
+	}
+	if calls := delta(3).ToolCalls; len(calls) != 1 || calls[0].Function.Name != "get_news" || *calls[0].Index != 2 {
+		t.Errorf("delta 3 tool calls = %+v, want get_news at index 2", calls)
+	}
+	if finish := responses[4].Choices[0].FinishReason; finish == nil || *finish != "tool_calls" {
+		t.Errorf("finish_reason = %v, want tool_calls", finish)
+	}
+}
+
+func TestTransformCandidatesWithoutIndex(t *testing.T) {
+	chunk := `{"candidates": [
+		{"content": {"role": "model", "parts": [{"text": "first"}]}},
+		{"content": {"role": "model", "parts": [{"text": "second"}]}}]}`
+
+	responses := transformChunks(t, NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp"), chunk)
+
+	got := map[int]string{}
+	for _, response := range responses {
+		for _, choice := range response.Choices {
+			if choice.Delta.Content != nil {
+				got[choice.Index] += *choice.Delta.Content
+			}
+		}
+	}
+	if want := map[int]string{0: "first", 1: "second"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("content by choice index = %v, want %v", got, want)
+	}
+}
+
+func TestTransformTextPartJoin(t *testing.T) {
+	chunk := `{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}, {"text": "lo"}]}}]}`
+
+	for separator, want := range map[string]string{"": "Hello", "\n\n": "Hel\n\nlo"} {
+		transformer := NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp")
+		transformer.SeparateTextParts(separator)
+
+		responses := transformChunks(t, transformer, chunk)
+
+		if len(responses) != 1 || responses[0].Choices[0].Delta.Content == nil {
+			t.Fatalf("separator %q: got %d chunks, want one content delta", separator, len(responses))
+		}
+		if got := *responses[0].Choices[0].Delta.Content; got != want {
+			t.Errorf("separator %q: content = %q, want %q", separator, got, want)
+		}
+	}
+}
+
+func TestTransformFinishReasonInOwnChunk(t *testing.T) {
+	responses := transformChunks(t, NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp"),
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hello"}]}}]}`,
+		`{"candidates": [{"content": {"role": "model", "parts": [{"text": " there"}]}, "finishReason": "STOP"}]}`,
+	)
+
+	if len(responses) != 3 {
+		t.Fatalf("got %d chunks, want 2 content chunks and a finish chunk", len(responses))
+	}
+	for i, response := range responses[:2] {
+		if finish := response.Choices[0].FinishReason; finish != nil {
+			t.Errorf("content chunk %d finish_reason = %q, want none", i, *finish)
+		}
+	}
+	last := responses[2].Choices[0]
+	if last.FinishReason == nil || *last.FinishReason != "stop" {
+		t.Errorf("final finish_reason = %v, want stop", last.FinishReason)
+	}
+	if delta, _ := json.Marshal(last.Delta); string(delta) != "{}" {
+		t.Errorf("final delta = %s, want an empty delta", delta)
+	}
+}
+
+func TestTransformFinishesChoiceOnce(t *testing.T) {
+	responses := transformChunks(t, NewStreamTransformer("gemini-2.5-flash", "chatcmpl-1", "fp"),
+		`{"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": "Hello"}]}, "finishReason": "MALFORMED_FUNCTION_CALL"},
+			{"index": 1, "content": {"role": "model", "parts": [{"text": "Hi"}]}}]}`,
+		`{"candidates": [{"index": 0, "content": {"role": "model", "parts": [{"text": " again"}]}, "finishReason": "STOP"},
+			{"index": 1, "content": {"role": "model", "parts": []}, "finishReason": "STOP"}]}`,
+	)
+
+	finishes := map[int][]string{}
+	for _, response := range responses {
+		choice := response.Choices[0]
+		if choice.FinishReason != nil {
+			finishes[choice.Index] = append(finishes[choice.Index], *choice.FinishReason)
+		} else if len(finishes[choice.Index]) > 0 {
+			t.Errorf("choice %d got content after its finish chunk", choice.Index)
+		}
+	}
+	if len(finishes[0]) != 1 || finishes[0][0] == "stop" {
+		t.Errorf("choice 0 finish reasons = %q, want only the first", finishes[0])
+	}
+	if want := []string{"stop"}; !reflect.DeepEqual(finishes[1], want) {
+		t.Errorf("choice 1 finish reasons = %q, want %q", finishes[1], want)
+	}
+}
@@ -0,0 +1,216 @@
+package transformers
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"geminicli2api/pkg/models"
+)
+
+// MalformedFunctionCallReason is the Gemini finish reason set on a candidate
+// whose function call failed validation, matching the one Gemini itself uses
+// when it can't produce a well-formed call
+const MalformedFunctionCallReason = "MALFORMED_FUNCTION_CALL"
+
+// FunctionCallValidator checks the function calls Gemini returns against the
+// functions declared in the request: the name must be declared and the
+// arguments must match the function's parameter schema
+type FunctionCallValidator struct {
+	parameters map[string]map[string]interface{}
+}
+
+// NewFunctionCallValidator creates a validator for a request's tools, or
+// returns nil when the request declares none
+func NewFunctionCallValidator(tools []models.OpenAITool) *FunctionCallValidator {
+	if len(tools) == 0 {
+		return nil
+	}
+	v := &FunctionCallValidator{parameters: make(map[string]map[string]interface{}, len(tools))}
+	for _, tool := range tools {
+		v.parameters[tool.Function.Name] = tool.Function.Parameters
+	}
+	return v
+}
+
+// Check describes the first invalid function call in a Gemini response, or
+// returns "" if every call is valid
+func (v *FunctionCallValidator) Check(geminiResponse map[string]interface{}) string {
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	for _, c := range candidates {
+		candidate, _ := c.(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			if call, ok := part["functionCall"].(map[string]interface{}); ok {
+				if problem := v.checkCall(call); problem != "" {
+					return problem
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Enforce removes invalid function calls from a Gemini response and finishes
+// their candidates with MALFORMED_FUNCTION_CALL, returning the problems found
+func (v *FunctionCallValidator) Enforce(geminiResponse map[string]interface{}) []string {
+	var problems []string
+	candidates, _ := geminiResponse["candidates"].([]interface{})
+	for _, c := range candidates {
+		candidate, _ := c.(map[string]interface{})
+		content, _ := candidate["content"].(map[string]interface{})
+		parts, _ := content["parts"].([]interface{})
+
+		kept := make([]interface{}, 0, len(parts))
+		var candidateProblems []string
+		for _, p := range parts {
+			part, _ := p.(map[string]interface{})
+			if call, ok := part["functionCall"].(map[string]interface{}); ok {
+				if problem := v.checkCall(call); problem != "" {
+					candidateProblems = append(candidateProblems, problem)
+					continue
+				}
+			}
+			kept = append(kept, p)
+		}
+		if len(candidateProblems) == 0 {
+			continue
+		}
+
+		content["parts"] = kept
+		candidate["finishReason"] = MalformedFunctionCallReason
+		candidate["finishMessage"] = strings.Join(candidateProblems, "; ")
+		problems = append(problems, candidateProblems...)
+	}
+	return problems
+}
+
+// checkCall describes what's wrong with a single function call, or returns ""
+func (v *FunctionCallValidator) checkCall(call map[string]interface{}) string {
+	name, _ := call["name"].(string)
+	schema, declared := v.parameters[name]
+	if !declared {
+		return fmt.Sprintf("function %q is not declared", name)
+	}
+	args, ok := call["args"]
+	if !ok || args == nil {
+		args = map[string]interface{}{}
+	}
+	if len(schema) == 0 {
+		return ""
+	}
+	if problem := validateSchema(args, schema, "arguments"); problem != "" {
+		return fmt.Sprintf("function %q: %s", name, problem)
+	}
+	return ""
+}
+
+// validateSchema checks a decoded JSON value against the subset of JSON Schema
+// used for function parameters: type, enum, properties, required,
+// additionalProperties and items. Type names are matched case-insensitively,
+// since Gemini-style schemas use upper case.
+func validateSchema(value interface{}, schema map[string]interface{}, path string) string {
+	if schemaType, ok := schema["type"]; ok && !matchesSchemaType(value, schemaType) {
+		return fmt.Sprintf("%s must be of type %v", path, schemaType)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(value, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Sprintf("%s must be one of %v", path, enum)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if key, _ := r.(string); key != "" {
+				if _, ok := value[key]; !ok {
+					return fmt.Sprintf("%s is missing required property %q", path, key)
+				}
+			}
+		}
+
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propertySchema, declared := properties[key].(map[string]interface{})
+			if !declared {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					return fmt.Sprintf("%s has unexpected property %q", path, key)
+				}
+				continue
+			}
+			if problem := validateSchema(value[key], propertySchema, path+"."+key); problem != "" {
+				return problem
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range value {
+				if problem := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, i)); problem != "" {
+					return problem
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// matchesSchemaType reports whether a value has the schema type, or one of
+// them when the schema lists several
+func matchesSchemaType(value interface{}, schemaType interface{}) bool {
+	switch schemaType := schemaType.(type) {
+	case string:
+		return matchesTypeName(value, schemaType)
+	case []interface{}:
+		for _, t := range schemaType {
+			if name, ok := t.(string); ok && matchesTypeName(value, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesTypeName(value interface{}, name string) bool {
+	switch strings.ToLower(name) {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package transformers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	apierrors "geminicli2api/pkg/errors"
+	"geminicli2api/pkg/models"
+)
+
+// toolsToGemini converts OpenAI function tools into a Gemini functionDeclarations tool
+func toolsToGemini(tools []models.OpenAITool) (map[string]interface{}, error) {
+	var declarations []map[string]interface{}
+	for i, tool := range tools {
+		if tool.Type != "" && tool.Type != "function" {
+			return nil, apierrors.InvalidParam(fmt.Sprintf("tools[%d].type", i), apierrors.CodeUnsupportedValue, "tools[%d]: unsupported tool type %q", i, tool.Type)
+		}
+		if tool.Function.Name == "" {
+			return nil, apierrors.InvalidParam(fmt.Sprintf("tools[%d].function.name", i), apierrors.CodeMissingParameter, "tools[%d]: function name is required", i)
+		}
+
+		declaration := map[string]interface{}{
+			"name": tool.Function.Name,
+		}
+		if tool.Function.Description != "" {
+			declaration["description"] = tool.Function.Description
+		}
+		if len(tool.Function.Parameters) > 0 {
+			declaration["parameters"] = tool.Function.Parameters
+		}
+		declarations = append(declarations, declaration)
+	}
+
+	if len(declarations) == 0 {
+		return nil, nil
+	}
+	return map[string]interface{}{"functionDeclarations": declarations}, nil
+}
+
+// toolChoiceToGemini maps an OpenAI tool_choice onto Gemini's toolConfig:
+// "none" -> NONE, "auto" -> AUTO, "required" -> ANY, and a specific function
+// -> ANY restricted to that function via allowedFunctionNames
+func toolChoiceToGemini(toolChoice interface{}) (map[string]interface{}, error) {
+	functionCallingConfig := map[string]interface{}{}
+
+	switch choice := toolChoice.(type) {
+	case string:
+		switch choice {
+		case "none":
+			functionCallingConfig["mode"] = "NONE"
+		case "auto":
+			functionCallingConfig["mode"] = "AUTO"
+		case "required":
+			functionCallingConfig["mode"] = "ANY"
+		default:
+			return nil, apierrors.InvalidParam("tool_choice", apierrors.CodeUnsupportedValue, "unsupported tool_choice %q", choice)
+		}
+	case map[string]interface{}:
+		function, _ := choice["function"].(map[string]interface{})
+		name, _ := function["name"].(string)
+		if name == "" {
+			return nil, apierrors.InvalidParam("tool_choice.function.name", apierrors.CodeMissingParameter, "tool_choice must name a function")
+		}
+		functionCallingConfig["mode"] = "ANY"
+		functionCallingConfig["allowedFunctionNames"] = []string{name}
+	default:
+		return nil, apierrors.InvalidParam("tool_choice", apierrors.CodeInvalidType, "unsupported tool_choice type: %T", toolChoice)
+	}
+
+	return map[string]interface{}{"functionCallingConfig": functionCallingConfig}, nil
+}
+
+// toolCallsToParts converts an assistant message's tool calls into Gemini functionCall parts
+func toolCallsToParts(toolCalls []models.OpenAIToolCall) ([]map[string]interface{}, error) {
+	var parts []map[string]interface{}
+	for _, toolCall := range toolCalls {
+		args := map[string]interface{}{}
+		if toolCall.Function.Arguments != "" {
+			if err := json.Unmarshal([]byte(toolCall.Function.Arguments), &args); err != nil {
+				return nil, apierrors.InvalidParam("messages", apierrors.CodeInvalidValue, "tool call %s has invalid JSON arguments: %v", toolCall.ID, err)
+			}
+		}
+		parts = append(parts, map[string]interface{}{
+			"functionCall": map[string]interface{}{
+				"name": toolCall.Function.Name,
+				"args": args,
+			},
+		})
+	}
+	return parts, nil
+}
+
+// toolMessageToPart converts a "tool" role message into a Gemini functionResponse
+// part. Gemini identifies responses by function name, so the name is resolved
+// from the tool_call_id of an earlier assistant tool call.
+func toolMessageToPart(message models.OpenAIChatMessage, toolCallNames map[string]string) (map[string]interface{}, error) {
+	name := message.Name
+	if name == "" {
+		name = toolCallNames[message.ToolCallID]
+	}
+	if name == "" {
+		return nil, apierrors.InvalidParam("messages", apierrors.CodeInvalidValue, "tool message references unknown tool_call_id %q", message.ToolCallID)
+	}
+
+	// Gemini expects an object; wrap anything that isn't one
+	text, _ := message.Content.(string)
+	response := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(text), &response); err != nil {
+		response = map[string]interface{}{"content": text}
+	}
+
+	return map[string]interface{}{
+		"functionResponse": map[string]interface{}{
+			"name":     name,
+			"response": response,
+		},
+	}, nil
+}
+
+// ParallelToolCallsEnabled reports whether a request allows several tool calls
+// per turn. Gemini has no equivalent setting, so parallel_tool_calls: false is
+// enforced by surfacing only the first functionCall of each candidate.
+func ParallelToolCallsEnabled(request *models.OpenAIChatCompletionRequest) bool {
+	return request.ParallelToolCalls == nil || *request.ParallelToolCalls
+}
+
+// KeepFirstToolCall drops all but the first tool call of each choice
+func KeepFirstToolCall(response *models.OpenAIChatCompletionResponse) {
+	for _, choice := range response.Choices {
+		if len(choice.Message.ToolCalls) > 1 {
+			choice.Message.ToolCalls = choice.Message.ToolCalls[:1]
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package transformers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	apierrors "geminicli2api/pkg/errors"
+)
+
+func TestToolChoiceToGemini(t *testing.T) {
+	tests := []struct {
+		name       string
+		toolChoice string
+		want       map[string]interface{}
+		wantParam  string
+	}{
+		{
+			name:       "none",
+			toolChoice: `"none"`,
+			want:       map[string]interface{}{"mode": "NONE"},
+		},
+		{
+			name:       "auto",
+			toolChoice: `"auto"`,
+			want:       map[string]interface{}{"mode": "AUTO"},
+		},
+		{
+			name:       "required",
+			toolChoice: `"required"`,
+			want:       map[string]interface{}{"mode": "ANY"},
+		},
+		{
+			name:       "named function",
+			toolChoice: `{"type": "function", "function": {"name": "get_weather"}}`,
+			want:       map[string]interface{}{"mode": "ANY", "allowedFunctionNames": []string{"get_weather"}},
+		},
+		{
+			name:       "unknown mode",
+			toolChoice: `"sometimes"`,
+			wantParam:  "tool_choice",
+		},
+		{
+			name:       "function without a name",
+			toolChoice: `{"type": "function", "function": {}}`,
+			wantParam:  "tool_choice.function.name",
+		},
+		{
+			name:       "wrong type",
+			toolChoice: `true`,
+			wantParam:  "tool_choice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			toolConfig, err := toolChoiceToGemini(decodeJSON(t, tt.toolChoice))
+
+			if tt.wantParam != "" {
+				var paramErr *apierrors.ParamError
+				if !errors.As(err, &paramErr) || paramErr.Param != tt.wantParam {
+					t.Errorf("error = %v, want a ParamError for %s", err, tt.wantParam)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toolChoiceToGemini() error = %v", err)
+			}
+			if got := toolConfig["functionCallingConfig"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("functionCallingConfig = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
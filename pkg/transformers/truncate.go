@@ -0,0 +1,114 @@
+package transformers
+
+import (
+	"log"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// imagePartTokens approximates the tokens of a non-text content part
+const imagePartTokens = 258
+
+// truncateMessages trims a conversation to the configured message and token
+// budgets by dropping the oldest turns. System and developer messages and the
+// latest user turn, with everything after it, are always kept. Tool results are
+// dropped together with the assistant message that requested them.
+func truncateMessages(messages []models.OpenAIChatMessage, cfg *config.Config) []models.OpenAIChatMessage {
+	if cfg.TruncateStrategy != "drop-oldest" || (cfg.TruncateMaxMessages <= 0 && cfg.TruncateMaxTokens <= 0) {
+		return messages
+	}
+
+	// Messages from the latest user turn onwards are protected
+	protectedFrom := len(messages)
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "user" {
+			protectedFrom = i
+			break
+		}
+	}
+
+	dropped := make([]bool, len(messages))
+	count := len(messages)
+	tokens := 0
+	for _, message := range messages {
+		tokens += estimateMessageTokens(message)
+	}
+
+	overBudget := func() bool {
+		return (cfg.TruncateMaxMessages > 0 && count > cfg.TruncateMaxMessages) ||
+			(cfg.TruncateMaxTokens > 0 && tokens > cfg.TruncateMaxTokens)
+	}
+
+	for i := 0; i < protectedFrom && overBudget(); i++ {
+		if isSystemMessage(messages[i]) || dropped[i] {
+			continue
+		}
+		dropped[i] = true
+		count--
+		tokens -= estimateMessageTokens(messages[i])
+
+		// Don't leave tool results without the call they answer
+		if len(messages[i].ToolCalls) > 0 {
+			for j := i + 1; j < protectedFrom && messages[j].Role == "tool"; j++ {
+				dropped[j] = true
+				count--
+				tokens -= estimateMessageTokens(messages[j])
+			}
+		}
+	}
+
+	// Gemini expects the conversation to open with a user turn
+	for i := 0; i < protectedFrom && count < len(messages); i++ {
+		if dropped[i] || isSystemMessage(messages[i]) {
+			continue
+		}
+		if messages[i].Role == "user" {
+			break
+		}
+		dropped[i] = true
+		count--
+		tokens -= estimateMessageTokens(messages[i])
+	}
+
+	if count == len(messages) {
+		return messages
+	}
+	log.Printf("Truncated conversation from %d to %d messages (about %d tokens)", len(messages), count, tokens)
+
+	kept := make([]models.OpenAIChatMessage, 0, count)
+	for i, message := range messages {
+		if !dropped[i] {
+			kept = append(kept, message)
+		}
+	}
+	return kept
+}
+
+func isSystemMessage(message models.OpenAIChatMessage) bool {
+	return message.Role == "system" || message.Role == "developer"
+}
+
+// estimateMessageTokens roughly estimates a message's tokens at four
+// characters per token, with a fixed cost per image or other non-text part
+func estimateMessageTokens(message models.OpenAIChatMessage) int {
+	chars := 0
+	tokens := 0
+	switch content := message.Content.(type) {
+	case string:
+		chars += len(content)
+	case []interface{}:
+		for _, part := range content {
+			partMap, _ := part.(map[string]interface{})
+			if text, ok := partMap["text"].(string); ok {
+				chars += len(text)
+			} else {
+				tokens += imagePartTokens
+			}
+		}
+	}
+	for _, toolCall := range message.ToolCalls {
+		chars += len(toolCall.Function.Name) + len(toolCall.Function.Arguments)
+	}
+	return tokens + chars/4
+}
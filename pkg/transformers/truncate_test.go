@@ -0,0 +1,95 @@
+package transformers
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"geminicli2api/pkg/config"
+	"geminicli2api/pkg/models"
+)
+
+// toolCallTurn is an assistant message with two tool calls, as JSON
+const toolCallTurn = `{"role": "assistant", "content": null, "tool_calls": [
+	{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{}"}},
+	{"id": "call_2", "type": "function", "function": {"name": "get_time", "arguments": "{}"}}]}`
+
+func TestTruncateMessagesDropOldest(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxMessages int
+		maxTokens   int
+		messages    string
+		want        []string // Role and content of each kept message
+	}{
+		{
+			name:        "keeps the system message and last user turn",
+			maxMessages: 2,
+			messages: `[{"role": "system", "content": "sys"}, {"role": "user", "content": "u1"}, {"role": "assistant", "content": "a1"},
+				{"role": "user", "content": "u2"}, {"role": "assistant", "content": "a2"}, {"role": "user", "content": "u3"}]`,
+			want: []string{"system:sys", "user:u3"},
+		},
+		{
+			name:        "tool results are dropped with their call",
+			maxMessages: 5,
+			messages: `[{"role": "user", "content": "u1"}, ` + toolCallTurn + `,
+				{"role": "tool", "tool_call_id": "call_1", "content": "sunny"}, {"role": "tool", "tool_call_id": "call_2", "content": "noon"},
+				{"role": "user", "content": "u2"}, {"role": "assistant", "content": "a2"}, {"role": "user", "content": "u3"}]`,
+			want: []string{"user:u2", "assistant:a2", "user:u3"},
+		},
+		{
+			name:        "conversation starts with a user turn",
+			maxMessages: 3,
+			messages: `[{"role": "system", "content": "sys"}, {"role": "user", "content": "u1"}, {"role": "assistant", "content": "a1"},
+				{"role": "user", "content": "u2"}]`,
+			want: []string{"system:sys", "user:u2"},
+		},
+		{
+			name:      "token budget",
+			maxTokens: 10,
+			messages: `[{"role": "developer", "content": "be brief"}, {"role": "user", "content": "` + strings.Repeat("x", 400) + `"},
+				{"role": "assistant", "content": "a1"}, {"role": "user", "content": "u2"}]`,
+			want: []string{"developer:be brief", "user:u2"},
+		},
+		{
+			name:        "within budget",
+			maxMessages: 10,
+			messages:    `[{"role": "user", "content": "u1"}, {"role": "assistant", "content": "a1"}, {"role": "user", "content": "u2"}]`,
+			want:        []string{"user:u1", "assistant:a1", "user:u2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.NewConfig()
+			cfg.TruncateStrategy = "drop-oldest"
+			cfg.TruncateMaxMessages = tt.maxMessages
+			cfg.TruncateMaxTokens = tt.maxTokens
+			var messages []models.OpenAIChatMessage
+			if err := json.Unmarshal([]byte(tt.messages), &messages); err != nil {
+				t.Fatalf("invalid test messages: %v", err)
+			}
+
+			var got []string
+			for _, message := range truncateMessages(messages, cfg) {
+				content, _ := message.Content.(string)
+				got = append(got, message.Role+":"+content)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateMessagesDisabled(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.TruncateStrategy = "none"
+	cfg.TruncateMaxMessages = 1
+	messages := []models.OpenAIChatMessage{{Role: "user", Content: "u1"}, {Role: "assistant", Content: "a1"}, {Role: "user", Content: "u2"}}
+
+	if got := truncateMessages(messages, cfg); len(got) != len(messages) {
+		t.Errorf("kept %d messages with TRUNCATE_STRATEGY=none, want all %d", len(got), len(messages))
+	}
+}
@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"geminicli2api/pkg/config"
+)
+
+// NewUpstreamTransport creates the HTTP transport shared by all upstream clients.
+// Defaults favor sustained throughput to a single host (Google).
+func NewUpstreamTransport(cfg *config.Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// Both values are validated at startup by Config.Validate
+	minVersion, _ := cfg.UpstreamTLSVersion()
+	cipherSuites, _ := cfg.UpstreamTLSCiphers()
+
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		ForceAttemptHTTP2:   cfg.UpstreamForceHTTP2,
+		MaxIdleConns:        cfg.UpstreamMaxIdleConnsPerHost,
+		MaxIdleConnsPerHost: cfg.UpstreamMaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.UpstreamIdleConnTimeout,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		// Non-streaming responses only send headers once generation is done,
+		// so this is off unless configured
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout,
+		TLSClientConfig: &tls.Config{
+			MinVersion:   minVersion,
+			CipherSuites: cipherSuites,
+		},
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// FailedPhase names the connection phase an upstream request failed in: "dns",
+// "dial", "tls" or "response headers". It returns "" for failures after the
+// connection was established, such as a cancelled or timed out generation.
+func FailedPhase(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return "dns"
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return "dial"
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	// net/http doesn't export its timeout errors, so match on their text
+	message := err.Error()
+	switch {
+	case errors.As(err, &certErr), errors.As(err, &recordErr), strings.Contains(message, "TLS handshake timeout"):
+		return "tls"
+	case strings.Contains(message, "timeout awaiting response headers"):
+		return "response headers"
+	}
+	return ""
+}
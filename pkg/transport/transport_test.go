@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+
+	"geminicli2api/pkg/config"
+)
+
+func TestNewUpstreamTransportTLSConfig(t *testing.T) {
+	tests := []struct {
+		name             string
+		minVersion       string
+		cipherSuites     string
+		wantMinVersion   uint16
+		wantCipherSuites []uint16
+	}{
+		{
+			name:           "defaults",
+			wantMinVersion: tls.VersionTLS12,
+		},
+		{
+			name:           "TLS 1.3",
+			minVersion:     "1.3",
+			wantMinVersion: tls.VersionTLS13,
+		},
+		{
+			name:             "cipher suites",
+			minVersion:       "1.2",
+			cipherSuites:     "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+			wantMinVersion:   tls.VersionTLS12,
+			wantCipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("UPSTREAM_TLS_MIN_VERSION", tt.minVersion)
+			t.Setenv("UPSTREAM_TLS_CIPHER_SUITES", tt.cipherSuites)
+
+			tlsConfig := NewUpstreamTransport(config.NewConfig()).TLSClientConfig
+
+			if tlsConfig.MinVersion != tt.wantMinVersion {
+				t.Errorf("MinVersion = %#x, want %#x", tlsConfig.MinVersion, tt.wantMinVersion)
+			}
+			if !reflect.DeepEqual(tlsConfig.CipherSuites, tt.wantCipherSuites) {
+				t.Errorf("CipherSuites = %v, want %v", tlsConfig.CipherSuites, tt.wantCipherSuites)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSValidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		minVersion   string
+		cipherSuites string
+	}{
+		{"unsupported version", "1.1", ""},
+		{"insecure cipher suite", "1.2", "TLS_RSA_WITH_RC4_128_SHA"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("UPSTREAM_TLS_MIN_VERSION", tt.minVersion)
+			t.Setenv("UPSTREAM_TLS_CIPHER_SUITES", tt.cipherSuites)
+
+			if err := config.NewConfig().Validate(); err == nil {
+				t.Error("Validate() accepted the TLS settings")
+			}
+		})
+	}
+}